@@ -0,0 +1,94 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// The tag of an ACLEntry, saying who the entry applies to.
+type ACLTag uint16
+
+const (
+	ACLUserObj  ACLTag = 0x01 // The file's owner.
+	ACLUser     ACLTag = 0x02 // The user with the entry's ID.
+	ACLGroupObj ACLTag = 0x04 // The file's group.
+	ACLGroup    ACLTag = 0x08 // The group with the entry's ID.
+	ACLMask     ACLTag = 0x10 // The maximum permissions granted by ACLUser, ACLGroupObj, and ACLGroup entries.
+	ACLOther    ACLTag = 0x20 // Everyone else.
+)
+
+// An entry in a POSIX ACL.
+type ACLEntry struct {
+	Tag  ACLTag
+	Perm uint16 // Permission bits: 4 (read), 2 (write), and 1 (execute).
+	ID   uint32 // The uid or gid for ACLUser and ACLGroup entries. Unused for other tags.
+}
+
+// A POSIX access control list, as stored in the system.posix_acl_access and system.posix_acl_default xattrs.
+type ACL []ACLEntry
+
+// The version of the xattr ACL format.
+const aclVersion = 2
+
+// Parses the value of a system.posix_acl_access or system.posix_acl_default xattr. squashfs can't store system xattrs,
+// so ACLs come from other sources, such as a file extracted from the archive or a tar's SCHILY.xattr records.
+func ParseACL(b []byte) (ACL, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return nil, errors.New("invalid ACL size")
+	}
+	if v := binary.LittleEndian.Uint32(b); v != aclVersion {
+		return nil, errors.New("unsupported ACL version " + strconv.FormatUint(uint64(v), 10))
+	}
+	out := make(ACL, 0, (len(b)-4)/8)
+	for b = b[4:]; len(b) > 0; b = b[8:] {
+		out = append(out, ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(b)),
+			Perm: binary.LittleEndian.Uint16(b[2:]),
+			ID:   binary.LittleEndian.Uint32(b[4:]),
+		})
+	}
+	return out, nil
+}
+
+// Returns the ACL in the short text form used by setfacl, such as "user::rwx,user:1000:r--,group::r-x,mask::r-x,other::r--".
+func (a ACL) String() string {
+	out := make([]string, len(a))
+	for i, e := range a {
+		out[i] = e.String()
+	}
+	return strings.Join(out, ",")
+}
+
+// Returns the entry in the text form used by setfacl, such as "user:1000:r--".
+func (e ACLEntry) String() string {
+	var out string
+	switch e.Tag {
+	case ACLUserObj:
+		out = "user::"
+	case ACLUser:
+		out = "user:" + strconv.FormatUint(uint64(e.ID), 10) + ":"
+	case ACLGroupObj:
+		out = "group::"
+	case ACLGroup:
+		out = "group:" + strconv.FormatUint(uint64(e.ID), 10) + ":"
+	case ACLMask:
+		out = "mask::"
+	case ACLOther:
+		out = "other::"
+	default:
+		out = "unknown(" + strconv.Itoa(int(e.Tag)) + "):" + strconv.FormatUint(uint64(e.ID), 10) + ":"
+	}
+	perm := []byte("---")
+	if e.Perm&4 != 0 {
+		perm[0] = 'r'
+	}
+	if e.Perm&2 != 0 {
+		perm[1] = 'w'
+	}
+	if e.Perm&1 != 0 {
+		perm[2] = 'x'
+	}
+	return out + string(perm)
+}
@@ -0,0 +1,115 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Linux capability names, indexed by their number.
+var capNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner", "cap_fsetid", "cap_kill", "cap_setgid",
+	"cap_setuid", "cap_setpcap", "cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast", "cap_net_admin",
+	"cap_net_raw", "cap_ipc_lock", "cap_ipc_owner", "cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice", "cap_sys_resource", "cap_sys_time",
+	"cap_sys_tty_config", "cap_mknod", "cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm", "cap_block_suspend", "cap_audit_read",
+	"cap_perfmon", "cap_bpf", "cap_checkpoint_restore",
+}
+
+// File capabilities, as stored in the security.capability xattr. Bit n of Permitted and Inheritable is capability n,
+// such as 10 for cap_net_bind_service.
+type Capabilities struct {
+	Permitted   uint64
+	Inheritable uint64
+	Effective   bool   // If set, permitted capabilities are effective as soon as the file is executed.
+	RootID      uint32 // The root uid of the user namespace the capabilities apply in. Only set by version 3 capabilities.
+}
+
+const (
+	vfsCapRevisionMask = 0xFF000000
+	vfsCapEffective    = 0x000001
+)
+
+// Parses the value of a security.capability xattr. Versions 1, 2, and 3 are supported.
+func ParseCapabilities(b []byte) (Capabilities, error) {
+	if len(b) < 4 {
+		return Capabilities{}, errors.New("invalid capabilities size")
+	}
+	magic := binary.LittleEndian.Uint32(b)
+	var out Capabilities
+	out.Effective = magic&vfsCapEffective != 0
+	rev := magic & vfsCapRevisionMask >> 24
+	var size int
+	switch rev {
+	case 1:
+		size = 12
+	case 2:
+		size = 20
+	case 3:
+		size = 24
+	default:
+		return Capabilities{}, errors.New("unsupported capabilities version " + strconv.Itoa(int(rev)))
+	}
+	if len(b) != size {
+		return Capabilities{}, errors.New("invalid capabilities size")
+	}
+	out.Permitted = uint64(binary.LittleEndian.Uint32(b[4:]))
+	out.Inheritable = uint64(binary.LittleEndian.Uint32(b[8:]))
+	if rev > 1 {
+		out.Permitted |= uint64(binary.LittleEndian.Uint32(b[12:])) << 32
+		out.Inheritable |= uint64(binary.LittleEndian.Uint32(b[16:])) << 32
+	}
+	if rev == 3 {
+		out.RootID = binary.LittleEndian.Uint32(b[20:])
+	}
+	return out, nil
+}
+
+// Returns whether capability n is permitted, such as 10 for cap_net_bind_service.
+func (c Capabilities) Has(n int) bool {
+	return n >= 0 && n < 64 && c.Permitted&(1<<n) != 0
+}
+
+// Returns the capabilities in the text form used by getcap, such as "cap_net_bind_service+ep".
+// Capabilities with the same flags are grouped together, such as "cap_net_admin,cap_net_raw+ep cap_chown+i".
+func (c Capabilities) String() string {
+	// Capabilities grouped by flags, with permitted and inheritable, then just permitted, then just inheritable.
+	var groups [3][]string
+	for n := range 64 {
+		p, i := c.Permitted&(1<<n) != 0, c.Inheritable&(1<<n) != 0
+		name := "cap_" + strconv.Itoa(n)
+		if n < len(capNames) {
+			name = capNames[n]
+		}
+		switch {
+		case p && i:
+			groups[0] = append(groups[0], name)
+		case p:
+			groups[1] = append(groups[1], name)
+		case i:
+			groups[2] = append(groups[2], name)
+		}
+	}
+	var out []string
+	for g, flags := range []string{"ip", "p", "i"} {
+		if len(groups[g]) == 0 {
+			continue
+		}
+		if c.Effective && flags != "i" {
+			flags = "e" + flags
+		}
+		out = append(out, strings.Join(groups[g], ",")+"+"+flags)
+	}
+	return strings.Join(out, " ")
+}
+
+// Returns the file's capabilities from its security.capability xattr. Returns ErrNoXattr if it doesn't have any.
+func (f *File) Capabilities() (Capabilities, error) {
+	val, err := f.Xattr("security.capability")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return ParseCapabilities(val)
+}
@@ -0,0 +1,241 @@
+package squashfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+
+	squashfslow "github.com/CalebQ42/squashfs/low"
+	"github.com/CalebQ42/squashfs/low/directory"
+	"github.com/CalebQ42/squashfs/low/inode"
+)
+
+// A problem found by Check.
+type CheckError struct {
+	Where  string // A file's path, or the part of the archive, such as "id table" or "inode 12".
+	Offset int64  // Where the problem is in the archive, or -1 if it isn't known. For a file's data, where its data starts.
+	Err    error
+}
+
+func (e CheckError) Error() string {
+	if e.Offset < 0 {
+		return e.Where + ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("%s (offset %#x): %v", e.Where, e.Offset, e.Err)
+}
+
+func (e CheckError) Unwrap() error {
+	return e.Err
+}
+
+// The result of Check.
+type CheckReport struct {
+	Files  int          // The number of files, including directories, reachable from the root.
+	Errors []CheckError // Empty if no problems were found.
+}
+
+// Returns whether no problems were found.
+func (c CheckReport) OK() bool {
+	return len(c.Errors) == 0
+}
+
+// Reads the entire archive, decompressing every metadata and data block, and checks that the tables agree with each other,
+// such as that inodes' uid, gid, xattr, and fragment indexes are in range and that directory entries point to the inodes they say.
+// Problems don't stop the check, so every problem that can be found is reported. Useful to reject corrupted archives
+// before they're used, such as user uploads.
+func (r *Reader) Check() CheckReport {
+	c := checker{r: r}
+	c.tables()
+	c.inodes()
+	c.directories()
+	c.files()
+	return c.report
+}
+
+type checker struct {
+	r      *Reader
+	report CheckReport
+	ids    []uint32
+	frags  []squashfslow.FragmentEntry
+	xattrs []squashfslow.XattrID
+	// Decompressed fragment block sizes, or -1 if the block couldn't be read.
+	fragSizes []int
+}
+
+func (c *checker) problem(where string, offset int64, err error) {
+	c.report.Errors = append(c.report.Errors, CheckError{Where: where, Offset: offset, Err: err})
+}
+
+func (c *checker) tables() {
+	sb := c.r.Low.Superblock
+	var err error
+	if c.ids, err = c.r.IDs(); err != nil {
+		c.problem("id table", int64(sb.IdTableStart), err)
+	}
+	if c.xattrs, err = c.r.XattrTable(); err != nil {
+		c.problem("xattr table", int64(sb.XattrTableStart), err)
+	}
+	for i := range c.xattrs {
+		if _, err = c.r.Low.Xattrs(uint32(i)); err != nil {
+			c.problem("xattr "+strconv.Itoa(i), -1, err)
+		}
+	}
+	if c.frags, err = c.r.Fragments(); err != nil {
+		c.problem("fragment table", int64(sb.FragTableStart), err)
+	}
+	c.fragSizes = make([]int, len(c.frags))
+	for i, ent := range c.frags {
+		if ent.Start+uint64(ent.StoredSize()) > sb.Size {
+			c.problem("fragment block "+strconv.Itoa(i), int64(ent.Start), errors.New("block extends past the end of the archive"))
+			c.fragSizes[i] = -1
+			continue
+		}
+		dat, err := c.r.Low.FragmentBlock(uint32(i))
+		if err != nil {
+			c.problem("fragment block "+strconv.Itoa(i), int64(ent.Start), err)
+			c.fragSizes[i] = -1
+			continue
+		}
+		c.fragSizes[i] = len(dat)
+	}
+	if !sb.Exportable() {
+		return
+	}
+	table, err := c.r.ExportTable()
+	if err != nil {
+		c.problem("export table", int64(sb.ExportTableStart), err)
+		return
+	}
+	for i, ref := range table {
+		in, err := c.r.Low.InodeFromRef(ref)
+		if err != nil {
+			c.problem("export table entry "+strconv.Itoa(i+1), c.inodeOffset(ref), err)
+		} else if in.Num != uint32(i+1) {
+			c.problem("export table entry "+strconv.Itoa(i+1), c.inodeOffset(ref), fmt.Errorf("points to inode %d", in.Num))
+		}
+	}
+}
+
+func (c *checker) inodeOffset(ref squashfslow.MetaRef) int64 {
+	return int64(c.r.Low.Superblock.InodeTableStart + ref.Block())
+}
+
+func (c *checker) inodes() {
+	sb := c.r.Low.Superblock
+	seen := make(map[uint32]bool)
+	err := c.r.Inodes(func(ref squashfslow.MetaRef, i inode.Inode) error {
+		where, off := "inode "+strconv.FormatUint(uint64(i.Num), 10), c.inodeOffset(ref)
+		if i.Num == 0 || i.Num > sb.InodeCount {
+			c.problem(where, off, fmt.Errorf("inode number out of range (%d inodes)", sb.InodeCount))
+		} else if seen[i.Num] {
+			c.problem(where, off, errors.New("inode number is used more than once"))
+		}
+		seen[i.Num] = true
+		if c.ids != nil && (int(i.UidInd) >= len(c.ids) || int(i.GidInd) >= len(c.ids)) {
+			c.problem(where, off, fmt.Errorf("uid or gid index out of range (%d ids)", len(c.ids)))
+		}
+		if x := i.XattrInd(); x != inode.NoXattr && c.xattrs != nil && int(x) >= len(c.xattrs) {
+			c.problem(where, off, fmt.Errorf("xattr index %d out of range (%d xattr ids)", x, len(c.xattrs)))
+		}
+		c.checkFileData(where, off, i)
+		return nil
+	})
+	if err != nil {
+		c.problem("inode table", int64(sb.InodeTableStart), err)
+		return
+	}
+	if len(seen) != int(sb.InodeCount) {
+		c.problem("inode table", int64(sb.InodeTableStart), fmt.Errorf("found %d inodes, but the superblock says there are %d", len(seen), sb.InodeCount))
+	}
+}
+
+// Checks that a regular file's data blocks are in the archive and its fragment is in range.
+func (c *checker) checkFileData(where string, off int64, i inode.Inode) {
+	b := squashfslow.FileBase{Inode: i}
+	if !b.IsRegular() {
+		return
+	}
+	var start uint64
+	var sizes []uint32
+	switch data := i.Data.(type) {
+	case inode.File:
+		start, sizes = uint64(data.BlockStart), data.BlockSizes
+	case inode.EFile:
+		start, sizes = data.BlockStart, data.BlockSizes
+	}
+	end := start
+	for _, s := range sizes {
+		end += uint64(s &^ (1 << 24))
+	}
+	if end > c.r.Low.Superblock.Size {
+		c.problem(where, int64(start), errors.New("data blocks extend past the end of the archive"))
+	}
+	frag, ok := b.Fragment(&c.r.Low)
+	if !ok || c.frags == nil {
+		return
+	}
+	if int(frag.Index) >= len(c.frags) {
+		c.problem(where, off, fmt.Errorf("fragment index %d out of range (%d fragments)", frag.Index, len(c.frags)))
+	} else if size := c.fragSizes[frag.Index]; size >= 0 && uint64(frag.Offset)+uint64(frag.Size) > uint64(size) {
+		c.problem(where, off, fmt.Errorf("data extends past the end of fragment block %d", frag.Index))
+	}
+}
+
+func (c *checker) directories() {
+	sb := c.r.Low.Superblock
+	err := c.r.DirectoryTable(func(header squashfslow.MetaRef, h directory.Header, e directory.Entry) error {
+		where, off := "directory entry "+strconv.Quote(e.Name), int64(sb.DirTableStart)+int64(header.Block())
+		in, err := c.r.Low.InodeFromRef(squashfslow.NewMetaRef(uint64(e.BlockStart), e.Offset))
+		switch {
+		case err != nil:
+			c.problem(where, off, err)
+		case in.Num != e.Num:
+			c.problem(where, off, fmt.Errorf("entry is for inode %d, but points to inode %d", e.Num, in.Num))
+		case in.Type.Basic() != inode.Type(e.InodeType):
+			c.problem(where, off, fmt.Errorf("entry is a %v, but points to a %v", inode.Type(e.InodeType), in.Type))
+		}
+		return nil
+	})
+	if err != nil {
+		c.problem("directory table", int64(sb.DirTableStart), err)
+	}
+}
+
+// Walks the archive, decompressing every file's data.
+func (c *checker) files() {
+	err := c.r.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			c.problem(path, -1, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		c.report.Files++
+		f, err := c.r.OpenFile(path)
+		if err != nil {
+			c.problem(path, -1, err)
+			return nil
+		}
+		defer f.Close()
+		if !f.IsRegular() {
+			return nil
+		}
+		if _, err = f.WriteTo(io.Discard); err != nil {
+			off := int64(-1)
+			switch data := f.b.Inode.Data.(type) {
+			case inode.File:
+				off = int64(data.BlockStart)
+			case inode.EFile:
+				off = int64(data.BlockStart)
+			}
+			c.problem(path, off, err)
+		}
+		return nil
+	})
+	if err != nil {
+		c.problem(".", -1, err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 func main() {
 	verbose := flag.Bool("v", false, "Verbose")
 	ignore := flag.Bool("ip", false, "Ignore Permissions and extract all files/folders with 0755")
+	mtime := flag.Bool("mtime", false, "Set extracted files' modification times to match the archive")
 	flag.Parse()
 	if len(flag.Args()) < 2 {
 		fmt.Println("Please provide a file name and extraction path")
@@ -28,6 +29,7 @@ func main() {
 	op := squashfs.DefaultOptions()
 	op.Verbose = *verbose
 	op.IgnorePerm = *ignore
+	op.PreserveModTime = *mtime
 	n := time.Now()
 	err = r.ExtractWithOptions(flag.Arg(1), op)
 	if err != nil {
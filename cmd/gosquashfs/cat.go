@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func cat(args []string) error {
+	set := flag.NewFlagSet("cat", flag.ExitOnError)
+	set.Parse(args)
+	if set.NArg() < 2 {
+		return errors.New("an archive and at least one path are needed")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, p := range set.Args()[1:] {
+		f, err := r.OpenResolved(archivePath(p))
+		if err != nil {
+			return err
+		}
+		if !f.IsRegular() {
+			return errors.New(p + ": not a regular file")
+		}
+		// WriteTo decompresses blocks in parallel.
+		_, err = f.WriteTo(out)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
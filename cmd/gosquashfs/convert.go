@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func convert(args []string) error {
+	set := flag.NewFlagSet("convert", flag.ExitOnError)
+	set.Parse(args)
+	if set.NArg() != 2 {
+		return errors.New("an input archive and an output file are needed")
+	}
+	out := set.Arg(1)
+	var write func(*squashfs.Reader, io.Writer) error
+	switch {
+	case strings.HasSuffix(out, ".tar"):
+		write = writeTar
+	case strings.HasSuffix(out, ".tar.gz"), strings.HasSuffix(out, ".tgz"):
+		write = func(r *squashfs.Reader, w io.Writer) error {
+			gz := gzip.NewWriter(w)
+			return errors.Join(writeTar(r, gz), gz.Close())
+		}
+	case strings.HasSuffix(out, ".zip"):
+		write = writeZip
+	case strings.HasSuffix(out, ".squashfs"), strings.HasSuffix(out, ".sfs"), strings.HasSuffix(out, ".sqfs"):
+		return errors.New("creating squashfs archives isn't supported, since the library can only read them")
+	default:
+		return errors.New("unknown output format. The output must end in .tar, .tar.gz, .tgz, or .zip")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	err = write(r, f)
+	if err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the archive as a tar, keeping hard links, device numbers, and xattrs (as PAX records).
+func writeTar(r *squashfs.Reader, w io.Writer) error {
+	links, err := r.HardLinks()
+	if err != nil {
+		return err
+	}
+	// The path each hard linked inode was first written as.
+	written := make(map[uint32]string)
+	tw := tar.NewWriter(w)
+	err = r.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		f, err := r.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, f.SymlinkPath())
+		if err != nil {
+			return err
+		}
+		hdr.Name = path
+		if f.IsDir() {
+			hdr.Name += "/"
+		}
+		sys := info.Sys().(*squashfs.Stat)
+		hdr.Uid, hdr.Gid = int(sys.Uid), int(sys.Gid)
+		maj, min := sys.Device()
+		hdr.Devmajor, hdr.Devminor = int64(maj), int64(min)
+		hdr.Format = tar.FormatPAX
+		xattrs, err := f.Xattrs()
+		if err != nil {
+			return err
+		}
+		for name, val := range xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string)
+			}
+			hdr.PAXRecords["SCHILY.xattr."+name] = string(val)
+		}
+		if _, ok := links[sys.Inode]; ok {
+			if first, ok := written[sys.Inode]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+				return tw.WriteHeader(hdr)
+			}
+			written[sys.Inode] = path
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if f.IsRegular() {
+			_, err = f.WriteTo(tw)
+		}
+		return err
+	})
+	return errors.Join(err, tw.Close())
+}
+
+// Writes the archive as a zip. Symlinks are stored with their target as their contents, like Info-ZIP.
+// Special files, such as devices, can't be stored in a zip and are skipped.
+func writeZip(r *squashfs.Reader, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := r.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		f, err := r.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if !f.IsDir() && !f.IsRegular() && !f.IsSymlink() {
+			return nil
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path
+		if f.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.IsRegular():
+			_, err = f.WriteTo(fw)
+		case f.IsSymlink():
+			_, err = io.WriteString(fw, f.SymlinkPath())
+		}
+		return err
+	})
+	return errors.Join(err, zw.Close())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// What diff compares for each file.
+type diffEntry struct {
+	mode    fs.FileMode
+	uid     uint32
+	gid     uint32
+	size    int64
+	modTime time.Time
+	target  string   // Symlinks' targets.
+	sum     [32]byte // SHA-256 of regular files' contents.
+}
+
+// A difference between the archives.
+type diffChange struct {
+	Path    string   `json:"path"`
+	Change  string   `json:"change"` // "added", "removed", or "modified".
+	Details []string `json:"details,omitempty"`
+}
+
+func diff(args []string) error {
+	set := flag.NewFlagSet("diff", flag.ExitOnError)
+	ignoreTime := set.Bool("no-mtime", false, "Don't report files whose only change is their modification time")
+	set.Parse(args)
+	if set.NArg() != 2 {
+		return errors.New("two archives are needed")
+	}
+	a, err := diffEntries(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := diffEntries(set.Arg(1))
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for p := range a {
+		paths = append(paths, p)
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	changes := []diffChange{}
+	for _, p := range paths {
+		old, inA := a[p]
+		cur, inB := b[p]
+		switch {
+		case !inA:
+			changes = append(changes, diffChange{p, "added", nil})
+		case !inB:
+			changes = append(changes, diffChange{p, "removed", nil})
+		default:
+			if c := old.changes(cur, *ignoreTime); len(c) > 0 {
+				changes = append(changes, diffChange{p, "modified", c})
+			}
+		}
+	}
+	if jsonOutput {
+		printJSON(changes)
+	} else {
+		for _, c := range changes {
+			switch c.Change {
+			case "added":
+				fmt.Println("+", c.Path)
+			case "removed":
+				fmt.Println("-", c.Path)
+			default:
+				fmt.Println("M", c.Path, "("+strings.Join(c.Details, ", ")+")")
+			}
+		}
+	}
+	if len(changes) > 0 {
+		// Like diff, exit with 1 when the archives differ.
+		return errFailed
+	}
+	return nil
+}
+
+// Returns what's different between e and other, such as "content" or "mode 0644 -> 0755".
+func (e diffEntry) changes(other diffEntry, ignoreTime bool) []string {
+	var out []string
+	if e.mode.Type() != other.mode.Type() {
+		return []string{"type " + e.mode.Type().String() + " -> " + other.mode.Type().String()}
+	}
+	if e.sum != other.sum || e.size != other.size {
+		out = append(out, "content")
+	}
+	if e.target != other.target {
+		out = append(out, "target "+e.target+" -> "+other.target)
+	}
+	if e.mode != other.mode {
+		out = append(out, fmt.Sprintf("mode %v -> %v", e.mode, other.mode))
+	}
+	if e.uid != other.uid || e.gid != other.gid {
+		out = append(out, fmt.Sprintf("owner %d/%d -> %d/%d", e.uid, e.gid, other.uid, other.gid))
+	}
+	if !ignoreTime && !e.modTime.Equal(other.modTime) {
+		out = append(out, "mtime "+e.modTime.Format(time.RFC3339)+" -> "+other.modTime.Format(time.RFC3339))
+	}
+	return out
+}
+
+func diffEntries(archive string) (map[string]diffEntry, error) {
+	r, err := squashfs.OpenFile(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out := make(map[string]diffEntry)
+	err = r.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		f, err := r.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sys := info.Sys().(*squashfs.Stat)
+		e := diffEntry{
+			mode:    info.Mode(),
+			uid:     sys.Uid,
+			gid:     sys.Gid,
+			modTime: info.ModTime(),
+		}
+		switch {
+		case f.IsRegular():
+			e.size = info.Size()
+			h := sha256.New()
+			if _, err = f.WriteTo(h); err != nil {
+				return err
+			}
+			h.Sum(e.sum[:0])
+		case f.IsSymlink():
+			e.target = f.SymlinkPath()
+		}
+		out[path] = e
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Join(errors.New(archive), err)
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func extract(args []string) error {
+	set := flag.NewFlagSet("extract", flag.ExitOnError)
+	dest := set.String("d", "squashfs-root", "Extract to the given directory")
+	force := set.Bool("f", false, "Extract into the destination even if it already exists, overwriting files")
+	noProgress := set.Bool("n", false, "Don't print a summary when done")
+	verbose := set.Bool("v", false, "Log files as they're extracted and any errors")
+	mtime := set.Bool("mtime", false, "Set extracted files' modification times to match the archive")
+	set.Parse(args)
+	if set.NArg() < 1 {
+		return errors.New("no archive given")
+	}
+	if _, err := os.Lstat(*dest); err == nil && !*force {
+		return errors.New(*dest + " already exists. Use -f to extract into it anyway")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	op := squashfs.DefaultOptions()
+	op.Verbose = *verbose
+	op.PreserveModTime = *mtime
+	start := time.Now()
+	paths := set.Args()[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var count int
+	for _, p := range paths {
+		p = archivePath(p)
+		f, err := r.OpenFile(p)
+		if err != nil {
+			return err
+		}
+		// Keep the file's location in the archive. Directories' contents are extracted into the given folder,
+		// while other files are extracted into the folder as themselves.
+		to := filepath.Join(*dest, filepath.FromSlash(p))
+		if !f.IsDir() {
+			to = filepath.Dir(to)
+		}
+		err = f.ExtractWithOptions(to, op)
+		if err != nil {
+			return err
+		}
+		count++
+		if f.IsDir() {
+			n, _, err := f.CountRecursive()
+			if err != nil {
+				return err
+			}
+			count += n
+		}
+	}
+	if jsonOutput {
+		return printJSON(struct {
+			Destination string  `json:"destination"`
+			Files       int     `json:"files"`
+			Seconds     float64 `json:"seconds"`
+		}{*dest, count, time.Since(start).Seconds()})
+	}
+	if !*noProgress {
+		fmt.Println("Extracted", count, "files to", *dest, "in", time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
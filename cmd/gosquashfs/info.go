@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// info's JSON output.
+type infoJSON struct {
+	Version                 string       `json:"version"`
+	ModTime                 time.Time    `json:"mtime"`
+	Compression             string       `json:"compression"`
+	CompressionOptions      any          `json:"compression_options,omitempty"`
+	CompressionOptionsError string       `json:"compression_options_error,omitempty"`
+	BlockSize               uint32       `json:"block_size"`
+	InodeCount              uint32       `json:"inodes"`
+	FragmentCount           uint32       `json:"fragments"`
+	IDCount                 uint16       `json:"ids"`
+	RootInode               string       `json:"root_inode"`
+	Flags                   uint16       `json:"flags"`
+	FlagNames               string       `json:"flag_names"`
+	BytesUsed               int64        `json:"bytes_used"`
+	ContainerSize           int64        `json:"container_size,omitempty"`
+	Layout                  []regionJSON `json:"layout"`
+}
+
+type regionJSON struct {
+	Name       string `json:"name"`
+	Start      int64  `json:"start"`
+	Size       int64  `json:"size"`
+	Compressed bool   `json:"compressed"`
+}
+
+func info(args []string) error {
+	set := flag.NewFlagSet("info", flag.ExitOnError)
+	set.Parse(args)
+	if set.NArg() != 1 {
+		return errors.New("an archive is needed")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	inf := r.Info()
+	sb := r.Superblock()
+	regions, err := r.Layout()
+	if err != nil {
+		return err
+	}
+	opts, optsErr := r.CompressionOptions()
+	if jsonOutput {
+		out := infoJSON{
+			Version:            fmt.Sprintf("%d.%d", sb.VerMaj, sb.VerMin),
+			ModTime:            inf.ModTime,
+			Compression:        inf.Compression,
+			CompressionOptions: opts,
+			BlockSize:          inf.BlockSize,
+			InodeCount:         inf.InodeCount,
+			FragmentCount:      inf.FragmentCount,
+			IDCount:            inf.IDCount,
+			RootInode:          sb.RootInodeRef.String(),
+			Flags:              uint16(inf.Flags),
+			FlagNames:          inf.Flags.String(),
+			BytesUsed:          inf.BytesUsed,
+		}
+		for _, reg := range regions {
+			out.Layout = append(out.Layout, regionJSON(reg))
+		}
+		if size, ok := r.ContainerSize(); ok {
+			out.ContainerSize = size
+		}
+		if optsErr != nil {
+			out.CompressionOptionsError = optsErr.Error()
+		}
+		return printJSON(out)
+	}
+	fmt.Printf("Squashfs %d.%d, %s compressed, block size %d\n", sb.VerMaj, sb.VerMin, inf.Compression, inf.BlockSize)
+	fmt.Println("Created:", inf.ModTime.Local().Format("Mon Jan 2 15:04:05 2006"))
+	fmt.Printf("Bytes used: %d (%.2f KiB)\n", inf.BytesUsed, float64(inf.BytesUsed)/1024)
+	if size, ok := r.ContainerSize(); ok && size != inf.BytesUsed {
+		fmt.Println("Container size:", size)
+	}
+	fmt.Println("Inodes:", inf.InodeCount)
+	fmt.Println("Fragments:", inf.FragmentCount)
+	fmt.Println("Ids:", inf.IDCount)
+	fmt.Println("Root inode:", sb.RootInodeRef)
+	fmt.Printf("Flags: %#04x (%s)\n", uint16(inf.Flags), inf.Flags)
+	if optsErr != nil {
+		fmt.Println("Compression options: error:", optsErr)
+	} else if opts != nil {
+		fmt.Printf("Compression options: %+v\n", opts)
+	} else if inf.Flags.CompressionOptions() {
+		raw, _ := r.CompressionOptionsRaw()
+		fmt.Printf("Compression options: unknown format %x\n", raw)
+	}
+	fmt.Println("\nLayout:")
+	fmt.Printf("  %-28s %12s %12s\n", "region", "start", "size")
+	for _, reg := range regions {
+		name := reg.Name
+		if reg.Compressed {
+			name += " (compressed)"
+		}
+		fmt.Printf("  %-28s %12d %12d\n", name, reg.Start, reg.Size)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// A file in ls's JSON output.
+type lsEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	Uid     uint32    `json:"uid"`
+	Gid     uint32    `json:"gid"`
+	User    string    `json:"user,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Target  string    `json:"target,omitempty"`
+}
+
+func ls(args []string) error {
+	set := flag.NewFlagSet("ls", flag.ExitOnError)
+	long := set.Bool("l", false, "Show each file's permissions, owner, size, and modification time")
+	recursive := set.Bool("R", false, "List the contents of subdirectories")
+	numeric := set.Bool("numeric-owner", false, "Show uids and gids instead of the host's user and group names")
+	set.Parse(args)
+	if set.NArg() < 1 || set.NArg() > 2 {
+		return errors.New("an archive and optionally a path are needed")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dir, err := r.OpenDir(archivePath(set.Arg(1)))
+	if err != nil {
+		return err
+	}
+	op := &squashfs.ListOptions{
+		LongFormat: *long,
+		NoRoot:     true,
+		NoRecurse:  !*recursive,
+	}
+	if !*numeric {
+		op.UserName = squashfs.HostUserName
+		op.GroupName = squashfs.HostGroupName
+	}
+	if jsonOutput {
+		// Everything is included, like -l.
+		return lsJSON(dir, op)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return dir.ListWithOptions(out, op)
+}
+
+func lsJSON(dir *squashfs.FS, op *squashfs.ListOptions) error {
+	out := []lsEntry{}
+	err := dir.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		f, err := dir.OpenFile(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sys := info.Sys().(*squashfs.Stat)
+		e := lsEntry{
+			Path:    path,
+			Type:    f.InodeType().Basic().String(),
+			Mode:    info.Mode().String(),
+			Uid:     sys.Uid,
+			Gid:     sys.Gid,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Target:  f.SymlinkPath(),
+		}
+		if op.UserName != nil {
+			e.User = op.UserName(sys.Uid)
+		}
+		if op.GroupName != nil {
+			e.Group = op.GroupName(sys.Gid)
+		}
+		out = append(out, e)
+		if op.NoRecurse && d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return printJSON(out)
+}
@@ -0,0 +1,104 @@
+// gosquashfs works with squashfs archives using only this library, so squashfs-tools doesn't need to be installed.
+//
+// Usage:
+//
+//	gosquashfs [--json] <command> [flags] <archive> [args...]
+//
+// With --json, output is JSON instead of text, so it can be used by other tools.
+// Run gosquashfs help for a list of commands.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"cat":     {"cat <archive> <paths...>\n\tWrite the given files to stdout, following symlinks, like sqfscat.", cat},
+	"info":    {"info <archive>\n\tPrint the archive's superblock, like unsquashfs -s, and where each of its tables is.", info},
+	"ls":      {"ls [-l] [-R] [-numeric-owner] <archive> [path]\n\tList the files in the archive's root directory, or the given directory.", ls},
+	"verify":  {"verify [-q] <archive>\n\tRead the whole archive, decompressing every block, and list any problems. Exits with 1 if there are any.", verify},
+	"convert": {"convert <archive> <output>\n\tConvert the archive to a tar (.tar, .tar.gz, or .tgz) or zip (.zip) file.", convert},
+	"diff":    {"diff [-no-mtime] <old archive> <new archive>\n\tList added (+), removed (-), and modified (M) files. Exits with 1 if the archives differ.", diff},
+	"extract": {"extract [-d dest] [-f] [-n] <archive> [paths...]\n\tExtract the archive, or only the given paths, like unsquashfs.", extract},
+}
+
+// Set by --json. Commands print JSON instead of text.
+var jsonOutput bool
+
+// Returned by commands whose output already says why they failed, such as verify finding problems, to exit with 1 without printing an error.
+var errFailed = errors.New("failed")
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// Runs the command in args and returns the exit code. Commands return before main exits, so their deferred calls run.
+func run(args []string) int {
+	for len(args) > 0 && (args[0] == "--json" || args[0] == "-json") {
+		jsonOutput = true
+		args = args[1:]
+	}
+	if len(args) < 1 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command:", args[0])
+		usage()
+		return 2
+	}
+	err := cmd.run(args[1:])
+	if errors.Is(err, errFailed) {
+		return 1
+	} else if err != nil {
+		if jsonOutput {
+			printJSON(struct {
+				Error string `json:"error"`
+			}{err.Error()})
+		}
+		fmt.Fprintln(os.Stderr, "gosquashfs "+args[0]+":", err)
+		return 1
+	}
+	return 0
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gosquashfs [--json] <command> [flags] <archive> [args...]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// Writes v to stdout as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// Returns p as a path in the archive, which can be used with Open. Leading slashes are allowed, as with unsquashfs.
+func archivePath(p string) string {
+	p = path.Clean("/" + filepath.ToSlash(p))[1:]
+	if p == "" {
+		return "."
+	}
+	return p
+}
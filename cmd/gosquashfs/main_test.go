@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CalebQ42/squashfs/internal/testimage"
+)
+
+var testContent = bytes.Repeat([]byte("hello squashfs "), 500)
+
+func testTree() *testimage.Node {
+	hello := testimage.File("hello.txt", testContent)
+	return testimage.Dir("",
+		hello,
+		testimage.Symlink("link", "sub/nested.txt"),
+		testimage.Link("more.txt", hello),
+		testimage.Dir("sub",
+			testimage.File("nested.txt", []byte("nested")),
+		),
+		&testimage.Node{Name: "null", Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, Rdev: 1<<8 | 3},
+	)
+}
+
+// Writes an archive built from root to a temporary file and returns its path.
+func writeImage(t *testing.T, root *testimage.Node, op testimage.Options) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.sfs")
+	err := os.WriteFile(p, testimage.Build(root, op), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Runs gosquashfs with args, returning the exit code and what was written to stdout.
+func runCmd(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	jsonOutput = false
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout = w
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = devNull
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code := run(args)
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	devNull.Close()
+	return code, <-out
+}
+
+func TestHelp(t *testing.T) {
+	for _, args := range [][]string{nil, {"help"}, {"--help"}} {
+		if code, _ := runCmd(t, args...); code != 0 {
+			t.Errorf("%v: got exit code %d", args, code)
+		}
+	}
+	if code, _ := runCmd(t, "nope"); code != 2 {
+		t.Errorf("unknown command: got exit code %d", code)
+	}
+	if code, _ := runCmd(t, "info", filepath.Join(t.TempDir(), "missing.sfs")); code != 1 {
+		t.Errorf("missing archive: got exit code %d", code)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{Compress: true})
+	code, out := runCmd(t, "info", img)
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if !strings.HasPrefix(out, "Squashfs 4.0, gzip compressed, block size 4096\n") {
+		t.Errorf("got %q", out)
+	}
+	if !strings.Contains(out, "Inodes: 6\n") {
+		t.Errorf("inode count missing from %q", out)
+	}
+	code, out = runCmd(t, "--json", "info", img)
+	if code != 0 {
+		t.Fatalf("--json: got exit code %d", code)
+	}
+	var inf infoJSON
+	if err := json.Unmarshal([]byte(out), &inf); err != nil {
+		t.Fatal(err, out)
+	}
+	if inf.Version != "4.0" || inf.Compression != "gzip" || inf.BlockSize != 4096 || inf.InodeCount != 6 || len(inf.Layout) == 0 {
+		t.Errorf("got %+v", inf)
+	}
+}
+
+func TestLs(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{})
+	code, out := runCmd(t, "ls", img)
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if want := "hello.txt\nlink\nmore.txt\nnull\nsub\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	code, out = runCmd(t, "ls", "-R", img)
+	if code != 0 {
+		t.Fatalf("-R: got exit code %d", code)
+	}
+	if !strings.Contains(out, "sub/nested.txt\n") {
+		t.Errorf("-R: got %q", out)
+	}
+	code, out = runCmd(t, "ls", "-l", "-numeric-owner", img, "/sub")
+	if code != 0 {
+		t.Fatalf("-l: got exit code %d", code)
+	}
+	if !strings.HasPrefix(out, "-rw-r--r--") || !strings.Contains(out, "nested.txt") {
+		t.Errorf("-l: got %q", out)
+	}
+	if code, _ = runCmd(t, "ls", img, "hello.txt"); code != 1 {
+		t.Errorf("file: got exit code %d", code)
+	}
+	code, out = runCmd(t, "--json", "ls", "-R", img)
+	if code != 0 {
+		t.Fatalf("--json: got exit code %d", code)
+	}
+	var entries []lsEntry
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatal(err, out)
+	}
+	found := make(map[string]lsEntry)
+	for _, e := range entries {
+		found[e.Path] = e
+	}
+	if e := found["hello.txt"]; e.Size != int64(len(testContent)) {
+		t.Errorf("--json: got hello.txt %+v", e)
+	}
+	if e := found["link"]; e.Target != "sub/nested.txt" {
+		t.Errorf("--json: got link %+v", e)
+	}
+	if _, ok := found["sub/nested.txt"]; !ok || len(entries) != 6 {
+		t.Errorf("--json: got %+v", entries)
+	}
+}
+
+func TestCat(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{Compress: true})
+	code, out := runCmd(t, "cat", img, "/hello.txt", "link")
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if out != string(testContent)+"nested" {
+		t.Errorf("got %d bytes, want %d", len(out), len(testContent)+len("nested"))
+	}
+	if code, _ = runCmd(t, "cat", img, "sub"); code != 1 {
+		t.Errorf("directory: got exit code %d", code)
+	}
+	if code, _ = runCmd(t, "cat", img, "missing"); code != 1 {
+		t.Errorf("missing file: got exit code %d", code)
+	}
+	loop := writeImage(t, testimage.Dir("", testimage.Symlink("a", "b"), testimage.Symlink("b", "a")), testimage.Options{})
+	if code, _ = runCmd(t, "cat", loop, "a"); code != 1 {
+		t.Errorf("symlink loop: got exit code %d", code)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{Compress: true})
+	code, out := runCmd(t, "verify", img)
+	if code != 0 {
+		t.Fatalf("got exit code %d: %s", code, out)
+	}
+	if out != "OK: 7 files checked\n" {
+		t.Errorf("got %q", out)
+	}
+	if code, out = runCmd(t, "verify", "-q", img); code != 0 || out != "" {
+		t.Errorf("-q: got exit code %d and %q", code, out)
+	}
+	// Corrupt hello.txt's first data block, which is right after the superblock.
+	data, err := os.ReadFile(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 120; i++ {
+		data[i] ^= 0xff
+	}
+	bad := filepath.Join(t.TempDir(), "bad.sfs")
+	if err = os.WriteFile(bad, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, out = runCmd(t, "verify", bad)
+	if code != 1 {
+		t.Errorf("corrupted: got exit code %d", code)
+	}
+	if !strings.Contains(out, "hello.txt") {
+		t.Errorf("corrupted: got %q", out)
+	}
+	code, out = runCmd(t, "--json", "verify", bad)
+	if code != 1 {
+		t.Errorf("--json: got exit code %d", code)
+	}
+	var res struct {
+		OK       bool            `json:"ok"`
+		Problems []verifyProblem `json:"problems"`
+	}
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatal(err, out)
+	}
+	if res.OK || len(res.Problems) == 0 {
+		t.Errorf("--json: got %+v", res)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := writeImage(t, testTree(), testimage.Options{})
+	if code, out := runCmd(t, "diff", old, old); code != 0 || out != "" {
+		t.Errorf("same archive: got exit code %d and %q", code, out)
+	}
+	changed := testimage.Dir("",
+		testimage.File("hello.txt", []byte("changed")),
+		testimage.Symlink("link", "sub/nested.txt"),
+		testimage.Dir("sub",
+			testimage.File("nested.txt", []byte("nested")),
+			testimage.File("new.txt", nil),
+		),
+		&testimage.Node{Name: "null", Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, Rdev: 1<<8 | 3},
+	)
+	cur := writeImage(t, changed, testimage.Options{})
+	code, out := runCmd(t, "diff", old, cur)
+	if code != 1 {
+		t.Errorf("got exit code %d", code)
+	}
+	if want := "M hello.txt (content)\n- more.txt\n+ sub/new.txt\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	code, out = runCmd(t, "--json", "diff", old, cur)
+	if code != 1 {
+		t.Errorf("--json: got exit code %d", code)
+	}
+	var changes []diffChange
+	if err := json.Unmarshal([]byte(out), &changes); err != nil {
+		t.Fatal(err, out)
+	}
+	if len(changes) != 3 || changes[0].Change != "modified" || changes[0].Details[0] != "content" {
+		t.Errorf("--json: got %+v", changes)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{})
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "out.tar")
+	if code, _ := runCmd(t, "convert", img, tarPath); code != 0 {
+		t.Fatalf("tar: got exit code %d", code)
+	}
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hdrs := make(map[string]*tar.Header)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		hdrs[hdr.Name] = hdr
+		if hdr.Name == "hello.txt" {
+			data, _ := io.ReadAll(tr)
+			if !bytes.Equal(data, testContent) {
+				t.Errorf("tar: hello.txt has the wrong contents")
+			}
+		}
+	}
+	if h := hdrs["more.txt"]; h == nil || h.Typeflag != tar.TypeLink || h.Linkname != "hello.txt" {
+		t.Errorf("tar: got more.txt %+v", h)
+	}
+	if h := hdrs["null"]; h == nil || h.Typeflag != tar.TypeChar || h.Devmajor != 1 || h.Devminor != 3 {
+		t.Errorf("tar: got null %+v", h)
+	}
+	if h := hdrs["sub/"]; h == nil || h.Typeflag != tar.TypeDir {
+		t.Errorf("tar: got sub/ %+v", h)
+	}
+
+	zipPath := filepath.Join(dir, "out.zip")
+	if code, _ := runCmd(t, "convert", img, zipPath); code != 0 {
+		t.Fatalf("zip: got exit code %d", code)
+	}
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if want := "hello.txt link more.txt sub/ sub/nested.txt"; strings.Join(names, " ") != want {
+		t.Errorf("zip: got %v, want %v", names, want)
+	}
+	data, err := fs.ReadFile(zr, "link")
+	if err != nil || string(data) != "sub/nested.txt" {
+		t.Errorf("zip: got link %q, %v", data, err)
+	}
+
+	if code, _ := runCmd(t, "convert", img, filepath.Join(dir, "out.sfs")); code != 1 {
+		t.Errorf("squashfs output: got exit code %d", code)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "out.sfs")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("squashfs output: file was created")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	img := writeImage(t, testTree(), testimage.Options{Compress: true})
+	dest := filepath.Join(t.TempDir(), "out")
+	code, out := runCmd(t, "extract", "-d", dest, img)
+	if code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if !strings.HasPrefix(out, "Extracted ") {
+		t.Errorf("got %q", out)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "hello.txt"))
+	if err != nil || !bytes.Equal(data, testContent) {
+		t.Errorf("hello.txt: got %d bytes, %v", len(data), err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "sub/nested.txt" {
+		t.Errorf("link: got %q, %v", target, err)
+	}
+	if code, _ = runCmd(t, "extract", "-d", dest, img); code != 1 {
+		t.Errorf("existing destination: got exit code %d", code)
+	}
+
+	part := filepath.Join(t.TempDir(), "part")
+	code, out = runCmd(t, "--json", "extract", "-d", part, img, "sub/nested.txt")
+	if code != 0 {
+		t.Fatalf("--json: got exit code %d", code)
+	}
+	var res struct {
+		Destination string `json:"destination"`
+		Files       int    `json:"files"`
+	}
+	if err = json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatal(err, out)
+	}
+	if res.Destination != part || res.Files != 1 {
+		t.Errorf("--json: got %+v", res)
+	}
+	if data, err = os.ReadFile(filepath.Join(part, "sub", "nested.txt")); err != nil || string(data) != "nested" {
+		t.Errorf("--json: got nested.txt %q, %v", data, err)
+	}
+	if _, err = os.Stat(filepath.Join(part, "hello.txt")); err == nil {
+		t.Errorf("--json: extracted more than the given path")
+	}
+}
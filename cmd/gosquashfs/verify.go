@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// A problem found by verify. Where is a file's path or the part of the archive, such as "id table".
+type verifyProblem struct {
+	Where  string `json:"where"`
+	Offset int64  `json:"offset"`
+	Error  string `json:"error"`
+}
+
+func verify(args []string) error {
+	set := flag.NewFlagSet("verify", flag.ExitOnError)
+	quiet := set.Bool("q", false, "Only print problems")
+	set.Parse(args)
+	if set.NArg() != 1 {
+		return errors.New("an archive is needed")
+	}
+	r, err := squashfs.OpenFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	report := r.Check()
+	if jsonOutput {
+		problems := []verifyProblem{}
+		for _, e := range report.Errors {
+			problems = append(problems, verifyProblem{e.Where, e.Offset, e.Err.Error()})
+		}
+		printJSON(struct {
+			OK       bool            `json:"ok"`
+			Files    int             `json:"files"`
+			Problems []verifyProblem `json:"problems"`
+		}{report.OK(), report.Files, problems})
+	} else {
+		for _, e := range report.Errors {
+			fmt.Println(e)
+		}
+		if !report.OK() {
+			fmt.Fprintln(os.Stderr, len(report.Errors), "problems found")
+		} else if !*quiet {
+			fmt.Println("OK:", report.Files, "files checked")
+		}
+	}
+	if !report.OK() {
+		return errFailed
+	}
+	return nil
+}
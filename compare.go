@@ -0,0 +1,207 @@
+package squashfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// What's different about a file, as reported by CompareDir. Differences can have more than one.
+type Mismatch uint8
+
+const (
+	MismatchMissing Mismatch = 1 << iota // The file is in the archive, but not on disk.
+	MismatchExtra                        // The file is on disk, but not in the archive.
+	MismatchType                         // The file is a different type, such as a directory instead of a regular file.
+	MismatchContent                      // A regular file's data or a symlink's target is different.
+	MismatchMode                         // The permissions, including the setuid, setgid, and sticky bits, are different.
+	MismatchOwner                        // The uid or gid is different. Only checked on unix.
+	MismatchModTime                      // The modification time is different.
+)
+
+var mismatchNames = []string{"missing", "extra", "type", "content", "mode", "owner", "mtime"}
+
+// Returns the mismatches' names separated by commas, such as "mode,mtime".
+func (m Mismatch) String() string {
+	var out []string
+	for i, n := range mismatchNames {
+		if m&(1<<i) != 0 {
+			out = append(out, n)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// A file that's different on disk than in the archive.
+type Difference struct {
+	Path     string // Relative to the root of the archive and the directory.
+	Mismatch Mismatch
+}
+
+// Compares the archive to the directory at path, such as one the archive was extracted to, and returns the files that differ,
+// sorted by path. Files missing from the directory, or extra files in it, are reported, but their contents aren't.
+// Symlinks aren't followed, and their mode and modification time aren't compared since they can't be set when extracting.
+// Socket files, which aren't extracted, are reported as missing. Returns an error if path isn't a directory.
+func (r *Reader) CompareDir(path string) ([]Difference, error) {
+	// Lstat, like the walk of the directory, so a symlink to a directory isn't walked into.
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "compare", Path: path, Err: ErrNotDir}
+	}
+	var out []Difference
+	// Paths in the archive, and whether the directory's contents were compared.
+	inArchive := make(map[string]bool)
+	err = r.walkFiles(func(name string, f *File, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		m, err := compareFile(f, filepath.Join(path, filepath.FromSlash(name)))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if m != 0 {
+			out = append(out, Difference{Path: name, Mismatch: m})
+		}
+		descend := m&(MismatchMissing|MismatchType) == 0
+		inArchive[name] = descend
+		if d.IsDir() && !descend {
+			return fs.SkipDir
+		}
+		return nil
+	}, &WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		descend, ok := inArchive[name]
+		if !ok {
+			out = append(out, Difference{Path: name, Mismatch: MismatchExtra})
+		}
+		if d.IsDir() && !descend {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(out, func(a, b Difference) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return out, nil
+}
+
+// Compares f to the file on disk at path.
+func compareFile(f *File, path string) (Mismatch, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return MismatchMissing, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if info.Mode().Type() != f.Mode().Type() {
+		return MismatchType, nil
+	}
+	var m Mismatch
+	if uid, gid, ok := diskOwner(info); ok {
+		archiveUid, err := f.Uid()
+		if err != nil {
+			return 0, err
+		}
+		archiveGid, err := f.Gid()
+		if err != nil {
+			return 0, err
+		}
+		if uid != archiveUid || gid != archiveGid {
+			m |= MismatchOwner
+		}
+	}
+	if f.IsSymlink() {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return 0, err
+		}
+		if target != f.SymlinkPath() {
+			m |= MismatchContent
+		}
+		return m, nil
+	}
+	const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	if info.Mode()&modeBits != f.Mode()&modeBits {
+		m |= MismatchMode
+	}
+	if !info.ModTime().Equal(f.ModTime()) {
+		m |= MismatchModTime
+	}
+	if f.IsRegular() {
+		same, err := sameContent(f, path, info.Size())
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			m |= MismatchContent
+		}
+	}
+	return m, nil
+}
+
+// Returned by compareWriter as soon as the data differs, to stop WriteTo.
+var errDifferent = errors.New("data is different")
+
+// Compares what's written to it with what's read from r.
+type compareWriter struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (c *compareWriter) Write(p []byte) (int, error) {
+	if cap(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+	_, err := io.ReadFull(c.r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && !bytes.Equal(buf, p)) {
+		return 0, errDifferent
+	} else if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Returns whether the regular file on disk at path, which is size bytes, has the same data as f.
+func sameContent(f *File, path string, size int64) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != size {
+		return false, nil
+	}
+	disk, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer disk.Close()
+	_, err = f.WriteTo(&compareWriter{r: disk})
+	if errors.Is(err, errDifferent) {
+		return false, nil
+	}
+	return err == nil, err
+}
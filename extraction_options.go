@@ -3,21 +3,32 @@ package squashfs
 import (
 	"io"
 	"io/fs"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/CalebQ42/squashfs/internal/routinemanager"
 )
 
 type ExtractionOptions struct {
 	manager            *routinemanager.Manager
+	extracted          *extractedPaths
 	LogOutput          io.Writer   //Where the verbose log should write.
 	DereferenceSymlink bool        //Replace symlinks with the target file.
-	UnbreakSymlink     bool        //Try to make sure symlinks remain unbroken when extracted, without changing the symlink.
+	UnbreakSymlink     bool        //Try to make sure symlinks remain unbroken when extracted, without changing the symlink. Targets outside the extraction folder aren't extracted.
 	Verbose            bool        //Prints extra info to log on an error.
 	IgnorePerm         bool        //Ignore file's permissions and instead use Perm.
 	Perm               fs.FileMode //Permission to use when IgnorePerm. Defaults to 0777.
+	PreserveModTime    bool        //Set extracted files' modification times to match the archive. Symlinks keep the time they're created.
 	SimultaneousFiles  uint16      //Number of files to process in parallel. Default set based on runtime.NumCPU().
 	ExtractionRoutines uint16      //Number of goroutines to use for each file's extraction. Only applies to regular files. Default set based on runtime.NumCPU().
+
+	//The namespaces of xattrs to apply to extracted files. Defaults to none. Only supported on Linux, and symlinks' xattrs are never applied.
+	//Unprivileged users can usually only set XattrUser.
+	Xattrs XattrNamespace
+	//Returns the owner to give extracted files, such as to match the archive's users to the host's by name. If nil, the archive's ids are used.
+	MapOwner func(uid, gid uint32) (int, int)
 }
 
 // The default extraction options.
@@ -47,3 +58,28 @@ func FastOptions() *ExtractionOptions {
 		ExtractionRoutines: uint16(runtime.NumCPU()),
 	}
 }
+
+// Tracks the paths created during a single extraction so nothing is extracted twice,
+// such as when a symlink's target is extracted to keep it unbroken.
+type extractedPaths struct {
+	paths map[string]struct{}
+	root  string // The folder the extraction started in.
+	mut   sync.Mutex
+}
+
+// Returns whether path is inside the folder the extraction started in.
+func (e *extractedPaths) inside(path string) bool {
+	rel, err := filepath.Rel(e.root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Returns true if path hasn't been extracted yet and marks it as extracted.
+func (e *extractedPaths) claim(path string) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if _, ok := e.paths[path]; ok {
+		return false
+	}
+	e.paths[path] = struct{}{}
+	return true
+}
@@ -7,9 +7,12 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/CalebQ42/squashfs/internal/routinemanager"
 	squashfslow "github.com/CalebQ42/squashfs/low"
@@ -18,13 +21,19 @@ import (
 )
 
 // File represents a file inside a squashfs archive.
+// A File holds its read position and readers, so it must not be copied. Open the file again for an independent File.
 type File struct {
 	full     *data.FullReader
 	rdr      *data.Reader
 	parent   *FS
 	r        *Reader
+	dir      *squashfslow.Directory
+	symlink  *File // The resolved symlink target. Copied when returned so read state isn't shared.
+	symOnce  sync.Once
 	b        squashfslow.FileBase
 	dirsRead int
+	fullMut  sync.Mutex
+	routines uint16
 }
 
 // Creates a new *File from the given *squashfs.Base
@@ -37,25 +46,40 @@ func (r *Reader) FileFromBase(b squashfslow.FileBase, parent *FS) *File {
 }
 
 func (f *File) FS() (*FS, error) {
+	d, err := f.directory()
+	if err != nil {
+		return nil, err
+	}
+	return f.r.FSFromDirectory(*d, f.parent), nil
+}
+
+// Returns the parsed directory. The directory is only read from the archive once.
+func (f *File) directory() (*squashfslow.Directory, error) {
+	if f.dir != nil {
+		return f.dir, nil
+	}
 	if !f.IsDir() {
-		return nil, errors.New("not a directory")
+		return nil, ErrNotDir
 	}
 	d, err := f.b.ToDir(&f.r.Low)
 	if err != nil {
 		return nil, err
 	}
-	return &FS{d: d, parent: f.parent, r: f.r}, nil
+	f.dir = &d
+	return f.dir, nil
 }
 
 // Closes the underlying readers.
-// Further calls to Read and WriteTo will re-create the readers.
+// Further calls to Read, ReadAt, and WriteTo will re-create the readers.
 // Never returns an error.
 func (f *File) Close() error {
 	if f.rdr != nil {
-		return f.rdr.Close()
+		f.rdr.Close()
 	}
 	f.rdr = nil
+	f.fullMut.Lock()
 	f.full = nil
+	f.fullMut.Unlock()
 	return nil
 }
 
@@ -65,14 +89,149 @@ func (f *File) GetSymlinkFile() fs.File {
 	if !f.IsSymlink() {
 		return nil
 	}
-	if filepath.IsAbs(f.SymlinkPath()) {
+	if path.IsAbs(f.SymlinkPath()) || f.parent == nil {
+		return nil
+	}
+	f.symOnce.Do(func() {
+		fil, err := f.parent.open(path.Clean(f.SymlinkPath()))
+		if err == nil {
+			f.symlink = fil
+		}
+	})
+	if f.symlink == nil {
 		return nil
 	}
-	fil, err := f.parent.Open(f.SymlinkPath())
+	return &File{
+		b:      f.symlink.b,
+		r:      f.symlink.r,
+		parent: f.symlink.parent,
+		dir:    f.symlink.dir,
+	}
+}
+
+// Returns the number of entries in the directory, without reading each entry's inode.
+func (f *File) NumChildren() (int, error) {
+	d, err := f.directory()
+	if err != nil {
+		return 0, err
+	}
+	return len(d.Entries), nil
+}
+
+// Returns the number of entries under the directory, including the contents of subdirectories, and the total size of the
+// regular files among them, without reading any file data. Symlinks aren't followed. Useful to check how much space extracting
+// the directory takes. Returns an error if the file isn't a directory.
+func (f *File) CountRecursive() (entries int, size int64, err error) {
+	dir, err := f.FS()
 	if err != nil {
+		return 0, 0, err
+	}
+	err = dir.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		entries++
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
 		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return
+}
+
+// Returns the file's inode number. Hard links to the same file share an inode number.
+func (f *File) InodeNum() uint32 {
+	return f.b.Inode.Num
+}
+
+// Returns the number of hard links to the file.
+func (f *File) NLink() uint32 {
+	return f.b.Inode.LinkCount()
+}
+
+// Returns the file's owner's uid, resolved using the archive's id table.
+func (f *File) Uid() (uint32, error) {
+	return f.b.Uid(&f.r.Low)
+}
+
+// Returns the file's group's gid, resolved using the archive's id table.
+func (f *File) Gid() (uint32, error) {
+	return f.b.Gid(&f.r.Low)
+}
+
+// Returns how many bytes the file's data takes up in the archive, for comparing against its size to find its compression ratio.
+// The file's share of a fragment block is proportional to how much of the block it takes up. Returns 0 for anything but regular files.
+func (f *File) StoredSize() (uint64, error) {
+	return f.b.StoredSize(&f.r.Low)
+}
+
+// Returned by File.Xattr when the file doesn't have the requested xattr.
+var ErrNoXattr = errors.New("xattr not found")
+
+// Returns the file's xattrs, keyed by their full name (such as "user.comment" or "security.capability").
+// If the file doesn't have any, returns an empty map.
+func (f *File) Xattrs() (map[string][]byte, error) {
+	xattrs, err := f.b.Xattrs(&f.r.Low)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(xattrs))
+	for _, x := range xattrs {
+		out[x.Name] = x.Value
 	}
-	return fil
+	return out, nil
+}
+
+// Returns the value of the file's xattr with the given full name, such as "security.selinux".
+// Returns ErrNoXattr if the file doesn't have it.
+func (f *File) Xattr(name string) ([]byte, error) {
+	xattrs, err := f.b.Xattrs(&f.r.Low)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range xattrs {
+		if x.Name == name {
+			return x.Value, nil
+		}
+	}
+	return nil, ErrNoXattr
+}
+
+// Returns how the file's data is stored: in data blocks, a fragment block, or both.
+func (f *File) Storage() squashfslow.Storage {
+	return f.b.Storage(&f.r.Low)
+}
+
+// Returns the number of data blocks the file is stored in, not including its fragment. Returns 0 for anything but regular files.
+func (f *File) BlockCount() int {
+	return f.b.BlockCount(&f.r.Low)
+}
+
+// Returns the file's data blocks from start up to (not including) end as stored in the archive, without decompressing them.
+// Useful for copying or deduplicating blocks in compressed form. Blocks can be decompressed with the archive's compression type.
+func (f *File) RawBlocks(start, end int) ([]squashfslow.RawBlock, error) {
+	return f.b.RawBlocks(&f.r.Low, start, end)
+}
+
+// Returns the file's inode type, such as inode.Fil or inode.EDir.
+func (f *File) InodeType() inode.Type {
+	return f.b.Inode.Type
+}
+
+// Returns where the end of the file is stored in a fragment block. If the file isn't a regular file, or its end isn't
+// stored in a fragment, returns false.
+func (f *File) Fragment() (squashfslow.FragmentPlacement, bool) {
+	return f.b.Fragment(&f.r.Low)
 }
 
 // Returns whether the file is a directory.
@@ -94,13 +253,20 @@ func (f *File) Mode() fs.FileMode {
 	return f.b.Inode.Mode()
 }
 
+// Returns the file's modification time, in UTC. Available for all file types.
+// Stored as an unsigned 32 bit number of seconds, so times range from 1970 to 2106.
+func (f *File) ModTime() time.Time {
+	return time.Unix(int64(f.b.Inode.ModTime), 0).UTC()
+}
+
 // Read reads the data from the file. Only works if file is a normal file.
 func (f *File) Read(b []byte) (int, error) {
 	if !f.IsRegular() {
 		return 0, errors.New("file is not a regular file")
 	}
 	if f.rdr == nil {
-		err := f.initializeReaders()
+		var err error
+		f.rdr, err = f.b.GetReader(&f.r.Low)
 		if err != nil {
 			return 0, err
 		}
@@ -112,9 +278,9 @@ func (f *File) Read(b []byte) (int, error) {
 // If n <= 0 all fs.DirEntry's are returned.
 func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 	if !f.IsDir() {
-		return nil, errors.New("file is not a directory")
+		return nil, ErrNotDir
 	}
-	d, err := f.b.ToDir(&f.r.Low)
+	d, err := f.directory()
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +308,10 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 
 // Returns the file's fs.FileInfo
 func (f *File) Stat() (fs.FileInfo, error) {
-	return newFileInfo(f.b.Name, &f.b.Inode), nil
+	if f.parent == nil && f.b.Name == "" {
+		return newFileInfo(&f.r.Low, ".", &f.b.Inode), nil
+	}
+	return newFileInfo(&f.r.Low, f.b.Name, &f.b.Inode), nil
 }
 
 // SymlinkPath returns the symlink's target path. Is the File isn't a symlink, returns an empty string.
@@ -159,32 +328,73 @@ func (f *File) SymlinkPath() string {
 // Writes all data from the file to the given writer in a multi-threaded manner.
 // The underlying reader is separate
 func (f *File) WriteTo(w io.Writer) (int64, error) {
+	full, err := f.fullReader()
+	if err != nil {
+		return 0, err
+	}
+	return full.WriteTo(w)
+}
+
+// Reads len(b) bytes from the file starting at off. Only the blocks containing the requested data are decompressed.
+// Independent of Read and safe to call concurrently.
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	full, err := f.fullReader()
+	if err != nil {
+		return 0, err
+	}
+	return full.ReadAt(b, off)
+}
+
+// A section of a file, used with ReadRanges.
+type Range = data.Range
+
+// Reads multiple, possibly overlapping, ranges of the file and returns their data in the same order.
+// Each data block is only decompressed once, which is faster than ReadAt when many small ranges share blocks.
+// Ranges that go past the end of the file are truncated.
+func (f *File) ReadRanges(ranges []Range) ([][]byte, error) {
+	full, err := f.fullReader()
+	if err != nil {
+		return nil, err
+	}
+	return full.ReadRanges(ranges)
+}
+
+// Returns the file's FullReader, creating it if necessary.
+func (f *File) fullReader() (*data.FullReader, error) {
 	if !f.IsRegular() {
-		return 0, errors.New("file is not a regular file")
+		return nil, errors.New("file is not a regular file")
 	}
+	f.fullMut.Lock()
+	defer f.fullMut.Unlock()
 	if f.full == nil {
-		err := f.initializeReaders()
+		var err error
+		f.full, err = f.b.GetFullReader(&f.r.Low)
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+		if f.routines != 0 {
+			f.full.SetGoroutineLimit(f.routines)
 		}
 	}
-	return f.full.WriteTo(w)
+	return f.full, nil
 }
 
-func (f *File) initializeReaders() error {
-	var err error
-	f.rdr, f.full, err = f.b.GetRegFileReaders(&f.r.Low)
-	return err
+// Set the maximum number of goroutines used by WriteTo for this file, overriding the Reader's limit.
+// If limit is 0, the Reader's limit is used.
+func (f *File) SetGoroutineLimit(limit uint16) {
+	f.routines = limit
+	f.fullMut.Lock()
+	defer f.fullMut.Unlock()
+	if f.full != nil {
+		if limit == 0 {
+			limit = f.r.Low.GoroutineLimit()
+		}
+		f.full.SetGoroutineLimit(limit)
+	}
 }
 
 func (f *File) deviceDevices() (maj uint32, min uint32) {
-	var dev uint32
-	if f.b.Inode.Type == inode.Char || f.b.Inode.Type == inode.Block {
-		dev = f.b.Inode.Data.(inode.Device).Dev
-	} else if f.b.Inode.Type == inode.EChar || f.b.Inode.Type == inode.EBlock {
-		dev = f.b.Inode.Data.(inode.EDevice).Dev
-	}
-	return dev >> 8, dev & 0x000FF
+	return splitDev(f.b.Inode.Dev())
 }
 
 func (f *File) path() string {
@@ -204,7 +414,11 @@ func (f *File) Extract(folder string) error {
 // Allows setting various extraction options via ExtractionOptions.
 func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 	if op.manager == nil {
+		// Copy the options so the same options can be used for multiple extractions.
+		opCopy := *op
+		op = &opCopy
 		op.manager = routinemanager.NewManager(op.SimultaneousFiles)
+		op.extracted = &extractedPaths{paths: map[string]struct{}{filepath.Clean(path): {}}, root: filepath.Clean(path)}
 		if op.LogOutput != nil {
 			log.SetOutput(op.LogOutput)
 		}
@@ -218,13 +432,14 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 	}
 	switch f.b.Inode.Type {
 	case inode.Dir, inode.EDir:
-		d, err := f.b.ToDir(&f.r.Low)
+		d, err := f.directory()
 		if err != nil {
 			if op.Verbose {
 				log.Println("Failed to create squashfs.Directory for", path)
 			}
 			return errors.Join(errors.New("failed to create squashfs.Directory: "+path), err)
 		}
+		dirFS := f.r.FSFromDirectory(*d, f.parent)
 		errChan := make(chan error, len(d.Entries))
 		for i := range d.Entries {
 			b, err := f.r.Low.BaseFromEntry(d.Entries[i])
@@ -235,10 +450,21 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 				return errors.Join(errors.New("failed to get base from entry: "+path), err)
 			}
 			go func(b squashfslow.FileBase, path string) {
+				if b.Inode.Type == inode.Sym || b.Inode.Type == inode.ESym {
+					// Symlinks might extract their target, which can need the manager, so they can't hold onto it.
+					errChan <- f.r.FileFromBase(b, dirFS).ExtractWithOptions(path, op)
+					return
+				}
 				i := op.manager.Lock()
 				if b.IsDir() {
 					extDir := filepath.Join(path, b.Name)
-					err = os.Mkdir(extDir, 0777)
+					if !op.extracted.claim(extDir) {
+						// Already extracted to keep a symlink unbroken.
+						op.manager.Unlock(i)
+						errChan <- nil
+						return
+					}
+					err = os.MkdirAll(extDir, 0777)
 					op.manager.Unlock(i)
 					if err != nil {
 						if op.Verbose {
@@ -247,7 +473,7 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 						errChan <- errors.Join(errors.New("failed to create directory: "+path), err)
 						return
 					}
-					err = f.r.FileFromBase(b, f.r.FSFromDirectory(d, f.parent)).ExtractWithOptions(extDir, op)
+					err = f.r.FileFromBase(b, dirFS).ExtractWithOptions(extDir, op)
 					if err != nil {
 						if op.Verbose {
 							log.Println("Failed to extract directory", path)
@@ -257,7 +483,7 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 					}
 					errChan <- nil
 				} else {
-					fil := f.r.FileFromBase(b, f.r.FSFromDirectory(d, f.parent))
+					fil := f.r.FileFromBase(b, dirFS)
 					err = fil.ExtractWithOptions(path, op)
 					op.manager.Unlock(i)
 					fil.Close()
@@ -277,6 +503,9 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 		}
 	case inode.Fil, inode.EFil:
 		path = filepath.Join(path, f.b.Name)
+		if !op.extracted.claim(path) {
+			return nil
+		}
 		outFil, err := os.Create(path)
 		if err != nil {
 			if op.Verbose {
@@ -292,7 +521,9 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 			}
 			return errors.Join(errors.New("failed to create full reader: "+path), err)
 		}
-		full.SetGoroutineLimit(op.ExtractionRoutines)
+		if !f.r.Low.LowMemory() {
+			full.SetGoroutineLimit(op.ExtractionRoutines)
+		}
 		_, err = full.WriteTo(outFil)
 		if err != nil {
 			if op.Verbose {
@@ -312,14 +543,31 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 			}
 			fil := filTmp.(*File)
 			fil.b.Name = f.b.Name
-			err := fil.ExtractWithOptions(path, op)
+			var err error
+			if fil.IsDir() {
+				dirPath := filepath.Join(path, f.b.Name)
+				if !op.extracted.claim(dirPath) {
+					return nil
+				}
+				err = os.MkdirAll(dirPath, 0777)
+				if err == nil {
+					err = fil.ExtractWithOptions(dirPath, op)
+				}
+			} else {
+				err = fil.ExtractWithOptions(path, op)
+			}
 			if err != nil {
 				if op.Verbose {
 					log.Println("Failed to extract symlink's file:", filepath.Join(path, f.b.Name))
 				}
 				return errors.Join(errors.New("failed to extract symlink's file: "+path), err)
 			}
+			// The target's permissions were applied when it was extracted.
+			return nil
 		} else {
+			if !op.extracted.claim(filepath.Join(path, f.b.Name)) {
+				return nil
+			}
 			if op.UnbreakSymlink {
 				filTmp := f.GetSymlinkFile()
 				if filTmp == nil {
@@ -328,9 +576,29 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 					}
 					return errors.New("failed to get symlink's file")
 				}
-				extractLoc := filepath.Join(path, filepath.Dir(symPath))
+				// Where the target needs to be for the symlink to work. If it's already been, or is being, extracted there, nothing needs to be done.
+				extractLoc := filepath.Join(path, filepath.FromSlash(symPath))
 				fil := filTmp.(*File)
-				err := fil.ExtractWithOptions(extractLoc, op)
+				var err error
+				if !op.extracted.inside(extractLoc) {
+					// Never write outside of the extraction folder, so the symlink is left broken.
+					if op.Verbose {
+						log.Println("Not extracting", fil.path(), "for symlink at", f.path(), "since it's outside of the extraction folder")
+					}
+				} else if fil.IsDir() {
+					if op.extracted.claim(extractLoc) {
+						err = os.MkdirAll(extractLoc, 0777)
+						if err == nil {
+							err = fil.ExtractWithOptions(extractLoc, op)
+						}
+					}
+				} else {
+					fil.b.Name = filepath.Base(extractLoc)
+					err = os.MkdirAll(filepath.Dir(extractLoc), 0777)
+					if err == nil {
+						err = fil.ExtractWithOptions(filepath.Dir(extractLoc), op)
+					}
+				}
 				if err != nil {
 					if op.Verbose {
 						log.Println("Error while extracting", fil.path(), "to make sure symlink at", f.path(), "is unbroken")
@@ -362,6 +630,9 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 			return errors.Join(errors.New("mknot command not found"), err)
 		}
 		path = filepath.Join(path, f.b.Name)
+		if !op.extracted.claim(path) {
+			return nil
+		}
 		var typ string
 		if f.b.Inode.Type == inode.Char || f.b.Inode.Type == inode.EChar {
 			typ = "c"
@@ -398,31 +669,70 @@ func (f *File) ExtractWithOptions(path string, op *ExtractionOptions) error {
 		}
 		return nil
 	default:
-		return errors.New("Unsupported file type. Inode type: " + strconv.Itoa(int(f.b.Inode.Type)))
+		return errors.New("Unsupported file type: " + f.b.Inode.Type.String())
 	}
 	if op.Verbose {
 		log.Println(f.path(), "extracted to", path)
 	}
-	if op.IgnorePerm {
-		return nil
+	if !op.IgnorePerm {
+		f.setPerm(path, op)
 	}
-	uid, err := f.b.Uid(&f.r.Low)
+	// Setxattr follows symlinks.
+	if op.Xattrs != 0 && !f.IsSymlink() {
+		f.setXattrs(path, op)
+	}
+	// Chtimes follows symlinks, so a symlink's time can't be set.
+	if op.PreserveModTime && !f.IsSymlink() {
+		err := os.Chtimes(path, time.Time{}, f.ModTime())
+		if err != nil && op.Verbose {
+			log.Println("Failed to set modification time of", path)
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// Applies the file's xattrs in op.Xattrs to the extracted file at path. Like permissions, errors are only logged.
+func (f *File) setXattrs(path string, op *ExtractionOptions) {
+	xattrs, err := f.XattrsIn(op.Xattrs)
 	if err != nil {
 		if op.Verbose {
-			log.Println("Failed to get uid for", path)
+			log.Println("Failed to read xattrs for", path)
+			log.Println(err)
+		}
+		return
+	}
+	for name, value := range xattrs {
+		err = setXattr(path, name, value)
+		if err != nil && op.Verbose {
+			log.Println("Failed to set xattr", name, "on", path)
 			log.Println(err)
 		}
-		return nil
 	}
-	gid, err := f.b.Gid(&f.r.Low)
+}
+
+// Sets the owner and mode of the extracted file at path. Errors are ignored, since they're expected when not running as root.
+func (f *File) setPerm(path string, op *ExtractionOptions) {
+	// Chown clears the setuid and setgid bits, so the owner is set before the mode.
+	uid, err := f.b.Uid(&f.r.Low)
 	if err != nil {
+		if op.Verbose {
+			log.Println("Failed to get uid for", path)
+			log.Println(err)
+		}
+	} else if gid, err := f.b.Gid(&f.r.Low); err != nil {
 		if op.Verbose {
 			log.Println("Failed to get gid for", path)
 			log.Println(err)
 		}
-		return nil
+	} else if op.MapOwner != nil {
+		newUid, newGid := op.MapOwner(uid, gid)
+		os.Lchown(path, newUid, newGid)
+	} else {
+		os.Lchown(path, int(uid), int(gid))
+	}
+	// Chmod follows symlinks, and a symlink's own mode is ignored anyway.
+	if !f.IsSymlink() {
+		os.Chmod(path, f.Mode())
 	}
-	os.Chmod(path, f.Mode())
-	os.Chown(path, int(uid), int(gid))
-	return nil
 }
@@ -4,39 +4,63 @@ import (
 	"io/fs"
 	"time"
 
+	squashfslow "github.com/CalebQ42/squashfs/low"
 	"github.com/CalebQ42/squashfs/low/directory"
 	"github.com/CalebQ42/squashfs/low/inode"
 )
 
 type fileInfo struct {
+	r        *squashfslow.Reader
 	name     string
 	size     int64
-	perm     uint32
+	mode     fs.FileMode
 	modTime  uint32
-	fileType uint16
+	inodeNum uint32
+	nlink    uint32
+	rdev     uint32
+	uidInd   uint16
+	gidInd   uint16
 }
 
-func (r Reader) newFileInfo(e directory.Entry) (fileInfo, error) {
+// Returned by the Sys method of a squashfs fs.FileInfo. Mirrors the fields of syscall.Stat_t that squashfs stores.
+type Stat struct {
+	Uid   uint32
+	Gid   uint32
+	Inode uint32
+	Nlink uint32
+	Rdev  uint32 // The device number of block and char devices, as stored in the archive. Use Device to split it.
+}
+
+// Returns the major and minor numbers of a block or char device's Rdev.
+func (s *Stat) Device() (major, minor uint32) {
+	return splitDev(s.Rdev)
+}
+
+// Splits a device number stored the same way as Linux's new_encode_dev into its major and minor numbers.
+func splitDev(dev uint32) (major, minor uint32) {
+	return (dev >> 8) & 0xfff, dev&0xff | (dev>>12)&0xfff00
+}
+
+func (r *Reader) newFileInfo(e directory.Entry) (fileInfo, error) {
 	i, err := r.Low.InodeFromEntry(e)
 	if err != nil {
 		return fileInfo{}, err
 	}
-	return newFileInfo(e.Name, &i), nil
+	return newFileInfo(&r.Low, e.Name, &i), nil
 }
 
-func newFileInfo(name string, i *inode.Inode) fileInfo {
-	var size int64
-	if i.Type == inode.Fil {
-		size = int64(i.Data.(inode.File).Size)
-	} else if i.Type == inode.EFil {
-		size = int64(i.Data.(inode.EFile).Size)
-	}
+func newFileInfo(r *squashfslow.Reader, name string, i *inode.Inode) fileInfo {
 	return fileInfo{
+		r:        r,
 		name:     name,
-		size:     size,
-		perm:     uint32(i.Perm),
+		size:     int64(i.Size()),
+		mode:     i.Mode(),
 		modTime:  i.ModTime,
-		fileType: i.Type,
+		inodeNum: i.Num,
+		nlink:    i.LinkCount(),
+		rdev:     i.Dev(),
+		uidInd:   i.UidInd,
+		gidInd:   i.GidInd,
 	}
 }
 
@@ -49,20 +73,27 @@ func (f fileInfo) Size() int64 {
 }
 
 func (f fileInfo) Mode() fs.FileMode {
-	if f.IsDir() {
-		return fs.FileMode(f.perm | uint32(fs.ModeDir))
-	}
-	return fs.FileMode(f.perm)
+	return f.mode
 }
 
 func (f fileInfo) ModTime() time.Time {
-	return time.Unix(int64(f.modTime), 0)
+	return time.Unix(int64(f.modTime), 0).UTC()
 }
 
 func (f fileInfo) IsDir() bool {
-	return f.fileType == inode.Dir || f.fileType == inode.EDir
+	return f.mode.IsDir()
 }
 
+// Returns a *Stat. The uid and gid are resolved using the archive's id table. If they can't be, they're left as 0.
 func (f fileInfo) Sys() any {
-	return nil
+	out := &Stat{
+		Inode: f.inodeNum,
+		Nlink: f.nlink,
+		Rdev:  f.rdev,
+	}
+	if f.r != nil {
+		out.Uid, _ = f.r.Id(f.uidInd)
+		out.Gid, _ = f.r.Id(f.gidInd)
+	}
+	return out
 }
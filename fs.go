@@ -1,45 +1,143 @@
 package squashfs
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	squashfslow "github.com/CalebQ42/squashfs/low"
 	"github.com/CalebQ42/squashfs/low/directory"
+	"github.com/CalebQ42/squashfs/low/inode"
+)
+
+// Returned when an inode has a type that isn't known, such as from a corrupted or newer archive.
+// Use errors.As with an inode.UnknownTypeError to get the inode's header. Other files can still be read, and Walk reports the error
+// for the affected entry, so the rest of the archive can still be walked.
+var ErrUnknownInodeType = inode.ErrUnknownType
+
+// Returned when using CaseInsensitiveStrict and a name matches multiple entries.
+var ErrAmbiguousName = errors.New("name matches multiple entries when ignoring case")
+
+// Returned when a directory is needed, but the file isn't one.
+var ErrNotDir = errors.New("not a directory")
+
+// CaseMode determines how an FS matches path components to directory entries.
+type CaseMode uint8
+
+const (
+	// Names must match exactly. The default.
+	CaseSensitive CaseMode = iota
+	// Names are compared using strings.EqualFold. An exact match is preferred, otherwise the first match (in name order) is used.
+	CaseInsensitive
+	// Same as CaseInsensitive, but returns ErrAmbiguousName if a name matches multiple entries.
+	CaseInsensitiveStrict
 )
 
 // FS is a fs.FS representation of a squashfs directory.
 // Implements fs.GlobFS, fs.ReadDirFS, fs.ReadFileFS, fs.StatFS, and fs.SubFS
 type FS struct {
-	r      *Reader
-	parent *FS
-	d      squashfslow.Directory
+	r        *Reader
+	parent   *FS
+	loadOnce *sync.Once // Only set if the entries haven't been read yet.
+	loadErr  error
+	d        squashfslow.Directory
+	caseMode CaseMode
 }
 
 // Creates a new *FS from the given squashfs.directory
 func (r *Reader) FSFromDirectory(d squashfslow.Directory, parent *FS) *FS {
-	return &FS{
+	out := &FS{
 		d:      d,
 		r:      r,
 		parent: parent,
 	}
+	if parent != nil {
+		out.caseMode = parent.caseMode
+	} else if r.FS != nil {
+		out.caseMode = r.FS.caseMode
+	}
+	return out
+}
+
+// Creates an FS for the directory without reading its entries until they're needed.
+// Used when opening paths so directories with an index don't need to be fully read.
+func (f *FS) lazyFS(b squashfslow.FileBase) *FS {
+	return &FS{
+		r:        f.r,
+		parent:   f,
+		loadOnce: &sync.Once{},
+		d:        squashfslow.Directory{FileBase: b},
+		caseMode: f.caseMode,
+	}
+}
+
+// Reads the directory's entries if they haven't been read yet.
+func (f *FS) load() error {
+	if f.loadOnce == nil {
+		return nil
+	}
+	f.loadOnce.Do(func() {
+		d, err := f.d.ToDir(&f.r.Low)
+		f.d.Entries, f.loadErr = d.Entries, err
+	})
+	return f.loadErr
+}
+
+// Sets how path components are matched to directory entries.
+// Any FS or File opened from this FS afterwards uses the same CaseMode.
+func (f *FS) SetCaseMode(m CaseMode) {
+	f.caseMode = m
+}
+
+// Returns the index of the entry with the given name, taking the FS's CaseMode into account.
+func (f *FS) entryIndex(name string) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	i, found := slices.BinarySearchFunc(f.d.Entries, name, func(e directory.Entry, name string) int {
+		return strings.Compare(e.Name, name)
+	})
+	if f.caseMode == CaseSensitive || (found && f.caseMode == CaseInsensitive) {
+		if !found {
+			return 0, fs.ErrNotExist
+		}
+		return i, nil
+	}
+	match := -1
+	for i := range f.d.Entries {
+		if !strings.EqualFold(f.d.Entries[i].Name, name) {
+			continue
+		}
+		if match == -1 {
+			match = i
+			if f.caseMode == CaseInsensitive {
+				break
+			}
+		} else {
+			return 0, ErrAmbiguousName
+		}
+	}
+	if match == -1 {
+		return 0, fs.ErrNotExist
+	}
+	return match, nil
 }
 
 // Glob returns the name of the files at the given pattern.
 // All paths are relative to the FS.
-// Uses filepath.Match to compare names.
+// Uses path.Match to compare names.
 func (f *FS) Glob(pattern string) (out []string, err error) {
-	pattern = filepath.Clean(pattern)
-	if !fs.ValidPath(pattern) {
-		return nil, &fs.PathError{
-			Op:   "glob",
-			Path: pattern,
-			Err:  fs.ErrInvalid,
-		}
+	pattern, err = cleanPath("glob", pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.load(); err != nil {
+		return nil, err
 	}
 	split := strings.Split(pattern, "/")
 	for i := 0; i < len(f.d.Entries); i++ {
@@ -91,15 +189,72 @@ func (f *FS) Glob(pattern string) (out []string, err error) {
 
 // Opens the file at name. Returns a *File as an fs.File.
 func (f *FS) Open(name string) (fs.File, error) {
-	name = filepath.Clean(name)
-	if !fs.ValidPath(name) {
+	fil, err := f.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return fil, nil
+}
+
+// Opens the file at name. Same as Open, but returns a *File.
+func (f *FS) OpenFile(name string) (*File, error) {
+	name, err := cleanPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.open(name)
+}
+
+// Opens the file at name like OpenFile, but follows symlinks, including the last element.
+// If a symlink can't be resolved inside the archive, or more than 40 need to be followed, returns fs.ErrNotExist.
+func (f *FS) OpenResolved(name string) (*File, error) {
+	fil, err := f.OpenFile(name)
+	if err != nil || !fil.IsSymlink() {
+		return fil, err
+	}
+	target := fil.resolveSymlink()
+	fil.Close()
+	if target == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return target, nil
+}
+
+// Opens the directory at name as an *FS.
+// If name is not a directory, returns an error.
+func (f *FS) OpenDir(name string) (*FS, error) {
+	name, err := cleanPath("opendir", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return f, nil
+	}
+	fil, err := f.open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fil.IsDir() {
+		return nil, &fs.PathError{
+			Op:   "opendir",
+			Path: name,
+			Err:  ErrNotDir,
+		}
+	}
+	return fil.FS()
+}
+
+// Opens the file at the cleaned path name.
+// Unlike Open, leading ".." elements are allowed and resolve to the parent directory so symlinks can be followed.
+func (f *FS) open(name string) (*File, error) {
+	if f.r.Low.Closed() {
 		return nil, &fs.PathError{
 			Op:   "open",
 			Path: name,
-			Err:  fs.ErrInvalid,
+			Err:  ErrReaderClosed,
 		}
 	}
-	if name == "." || name == "" {
+	if name == "." {
 		return f.File(), nil
 	}
 	split := strings.Split(name, "/")
@@ -110,22 +265,21 @@ func (f *FS) Open(name string) (fs.File, error) {
 				Path: name,
 				Err:  fs.ErrNotExist,
 			}
-		} else {
-			return f.parent.Open(strings.Join(split[1:], "/"))
 		}
-	}
-	i, found := slices.BinarySearchFunc(f.d.Entries, split[0], func(e directory.Entry, name string) int {
-		return strings.Compare(e.Name, name)
-	})
-	if !found {
-		return nil, &fs.PathError{
-			Op:   "open",
-			Path: name,
-			Err:  fs.ErrNotExist,
+		if len(split) == 1 {
+			return f.parent.File(), nil
 		}
+		return f.parent.open(strings.Join(split[1:], "/"))
 	}
-	b, err := f.r.Low.BaseFromEntry(f.d.Entries[i])
+	b, err := f.lookup(split[0])
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || err == ErrAmbiguousName {
+			return nil, &fs.PathError{
+				Op:   "open",
+				Path: name,
+				Err:  err,
+			}
+		}
 		return nil, err
 	}
 	if len(split) == 1 {
@@ -142,52 +296,54 @@ func (f *FS) Open(name string) (fs.File, error) {
 			Err:  fs.ErrNotExist,
 		}
 	}
-	d, err := b.ToDir(&f.r.Low)
+	return f.lazyFS(b).open(strings.Join(split[1:], "/"))
+}
+
+// Returns the FileBase of the entry with the given name.
+// If the directory's entries haven't been read, the directory index is used when possible.
+func (f *FS) lookup(name string) (squashfslow.FileBase, error) {
+	if f.loadOnce != nil && f.caseMode == CaseSensitive && f.d.HasDirIndex() {
+		e, err := f.d.Lookup(&f.r.Low, name)
+		if err != nil {
+			return squashfslow.FileBase{}, err
+		}
+		return f.r.Low.BaseFromEntry(e)
+	}
+	i, err := f.entryIndex(name)
 	if err != nil {
-		return nil, err
+		return squashfslow.FileBase{}, err
 	}
-	return f.r.FSFromDirectory(d, f).Open(strings.Join(split[1:], "/"))
+	return f.r.Low.BaseFromEntry(f.d.Entries[i])
 }
 
 // Returns all DirEntry's for the directory at name.
 // If name is not a directory, returns an error.
 func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
-	name = filepath.Clean(name)
-	if !fs.ValidPath(name) {
-		return nil, &fs.PathError{
-			Op:   "readdir",
-			Path: name,
-			Err:  fs.ErrInvalid,
-		}
-	}
-	if name == "." || name == "" {
-		return f.File().ReadDir(-1)
+	name, err := cleanPath("readdir", name)
+	if err != nil {
+		return nil, err
 	}
-	fil, err := f.Open(name)
+	fil, err := f.open(name)
 	if err != nil {
 		return nil, err
 	}
-	return fil.(*File).ReadDir(-1)
+	return fil.ReadDir(-1)
 }
 
 // Returns the contents of the file at name.
 func (f *FS) ReadFile(name string) (out []byte, err error) {
-	name = filepath.Clean(name)
-	if !fs.ValidPath(name) {
-		return nil, &fs.PathError{
-			Op:   "readfile",
-			Path: name,
-			Err:  fs.ErrInvalid,
-		}
+	name, err = cleanPath("readfile", name)
+	if err != nil {
+		return nil, err
 	}
-	if name == "." || name == "" {
+	if name == "." {
 		return nil, fs.ErrInvalid
 	}
-	fil, err := f.Open(name)
+	fil, err := f.open(name)
 	if err != nil {
 		return nil, err
 	}
-	if !fil.(*File).IsRegular() {
+	if !fil.IsRegular() {
 		return nil, fs.ErrInvalid
 	}
 	return io.ReadAll(fil)
@@ -195,49 +351,41 @@ func (f *FS) ReadFile(name string) (out []byte, err error) {
 
 // Returns the fs.FileInfo for the file at name.
 func (f *FS) Stat(name string) (fs.FileInfo, error) {
-	name = filepath.Clean(name)
-	if !fs.ValidPath(name) {
-		return nil, &fs.PathError{
-			Op:   "stat",
-			Path: name,
-			Err:  fs.ErrInvalid,
-		}
-	}
-	if name == "." || name == "" {
-		return f.File().Stat()
+	name, err := cleanPath("stat", name)
+	if err != nil {
+		return nil, err
 	}
-	fil, err := f.Open(name)
+	fil, err := f.open(name)
 	if err != nil {
 		return nil, err
 	}
-	return fil.(*File).Stat()
+	return fil.Stat()
 }
 
 // Returns the FS at dir
 func (f *FS) Sub(dir string) (fs.FS, error) {
-	dir = filepath.Clean(dir)
-	if !fs.ValidPath(dir) {
-		return nil, &fs.PathError{
-			Op:   "dir",
-			Path: dir,
-			Err:  fs.ErrInvalid,
-		}
-	}
-	if dir == "." || dir == "" {
-		return f, nil
-	}
-	fil, err := f.Open(dir)
+	sub, err := f.OpenDir(dir)
 	if err != nil {
+		if pathErr, ok := err.(*fs.PathError); ok {
+			return nil, &fs.PathError{Op: "sub", Path: pathErr.Path, Err: pathErr.Err}
+		}
 		return nil, err
 	}
-	if !fil.(*File).IsDir() {
-		return nil, &fs.PathError{
-			Op:   "dir",
-			Path: dir,
-			Err:  fs.ErrInvalid,
-		}
+	return sub, nil
+}
+
+// Returns whether a and b describe the same file (share an inode), such as when they are hard links.
+// a and b must both come from this archive. If either wasn't created by this library, returns false.
+func (f *FS) SameFile(a, b fs.FileInfo) bool {
+	aInfo, ok := a.(fileInfo)
+	if !ok {
+		return false
+	}
+	bInfo, ok := b.(fileInfo)
+	if !ok {
+		return false
 	}
-	return fil.(*File).FS()
+	return aInfo.inodeNum == bInfo.inodeNum
 }
 
 // Extract the FS to the given folder. If the file is a folder, the folder's contents will be extracted to the folder.
@@ -254,11 +402,29 @@ func (f *FS) ExtractWithOptions(folder string, op *ExtractionOptions) error {
 
 // Returns the FS as a *File
 func (f *FS) File() *File {
-	return &File{
+	out := &File{
 		b:      f.d.FileBase,
 		parent: f.parent,
 		r:      f.r,
 	}
+	if f.load() == nil {
+		out.dir = &f.d
+	}
+	return out
+}
+
+// Cleans name and makes sure it's valid according to fs.ValidPath.
+// Trailing slashes and "./" elements are removed.
+func cleanPath(op, name string) (string, error) {
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{
+			Op:   op,
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	return name, nil
 }
 
 func (f *FS) path() string {
@@ -0,0 +1,146 @@
+package squashfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/CalebQ42/squashfs"
+	"github.com/CalebQ42/squashfs/low/directory"
+	"github.com/CalebQ42/squashfs/low/inode"
+)
+
+// Archives used as the starting corpus for the fuzz targets. They're kept small, since the fuzzer is far slower with large inputs.
+func fuzzSeeds(f *testing.F) [][]byte {
+	big := testFile("big", bytes.Repeat([]byte("fuzz "), 1000))
+	root := testDir("",
+		big,
+		testDir("dir", testFile("small", []byte("small")), withXattrs(testFile("x", []byte("x")), "user.a", "1")),
+		testSymlink("link", "big"),
+		testLink("hard", big),
+	)
+	return [][]byte{
+		buildTestImage(f, root, testImageOptions{blockSize: 4096}),
+		buildTestImage(f, root, testImageOptions{blockSize: 4096, compress: true, exportable: true}),
+		buildTestImage(f, root, testImageOptions{blockSize: 4096, noFrags: true, unsorted: true}),
+	}
+}
+
+func openSeed(f *testing.F, img []byte) *squashfs.Reader {
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return rdr
+}
+
+// Opens the archive and reads everything in it. Errors are expected, panics aren't.
+func readAll(img []byte) {
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		return
+	}
+	defer rdr.Close()
+	rdr.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		f, err := rdr.OpenFile(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		f.Stat()
+		f.Xattrs()
+		if f.IsSymlink() {
+			f.SymlinkPath()
+		} else if f.IsRegular() {
+			f.WriteTo(io.Discard)
+		}
+		return nil
+	})
+	rdr.Check()
+}
+
+func FuzzReader(f *testing.F) {
+	for _, img := range fuzzSeeds(f) {
+		f.Add(img)
+	}
+	f.Fuzz(func(t *testing.T, img []byte) {
+		readAll(img)
+	})
+}
+
+// Fuzzes the superblock of a valid archive, keeping the rest of the archive intact.
+func FuzzSuperblock(f *testing.F) {
+	img := fuzzSeeds(f)[0]
+	f.Add(img[:96])
+	f.Fuzz(func(t *testing.T, sb []byte) {
+		if len(sb) > 96 {
+			sb = sb[:96]
+		}
+		readAll(append(sb[:len(sb):len(sb)], img[len(sb):]...))
+	})
+}
+
+// Fuzzes the fragment table entries of a valid archive.
+func FuzzFragment(f *testing.F) {
+	img := fuzzSeeds(f)[0]
+	rdr := openSeed(f, img)
+	// The test images' metadata is uncompressed, so the entries start after the block's 2 byte header.
+	var loc uint64
+	binary.Read(bytes.NewReader(img[rdr.Superblock().FragTableStart:]), binary.LittleEndian, &loc)
+	start := int(loc) + 2
+	frags, err := rdr.Fragments()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(img[start : start+16*len(frags)])
+	f.Fuzz(func(t *testing.T, ents []byte) {
+		bad := bytes.Clone(img)
+		copy(bad[start:start+16*len(frags)], ents)
+		readAll(bad)
+	})
+}
+
+// Returns the data of the uncompressed metadata block at start.
+func metadataBlock(img []byte, start uint64) []byte {
+	size := uint64(binary.LittleEndian.Uint16(img[start:]) &^ 0x8000)
+	return img[start+2 : start+2+size]
+}
+
+func FuzzInode(f *testing.F) {
+	for _, img := range fuzzSeeds(f) {
+		sb := openSeed(f, img).Superblock()
+		if !sb.UncompressedInodes() {
+			continue
+		}
+		f.Add(metadataBlock(img, sb.InodeTableStart), sb.BlockSize)
+	}
+	f.Fuzz(func(t *testing.T, dat []byte, blockSize uint32) {
+		r := bytes.NewReader(dat)
+		for r.Len() > 0 {
+			if _, err := inode.Read(r, blockSize); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzDirectory(f *testing.F) {
+	for _, img := range fuzzSeeds(f) {
+		sb := openSeed(f, img).Superblock()
+		if !sb.UncompressedInodes() {
+			continue
+		}
+		// The first directory's listing is at the start of the table. Its size isn't known without its inode, so use the whole block.
+		dat := metadataBlock(img, sb.DirTableStart)
+		f.Add(dat, uint32(len(dat)+3))
+	}
+	f.Fuzz(func(t *testing.T, dat []byte, size uint32) {
+		directory.ReadDirectory(bytes.NewReader(dat), size)
+		directory.FindEntry(bytes.NewReader(dat), size, "small")
+	})
+}
@@ -0,0 +1,118 @@
+package squashfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Returns an http.FileSystem for the FS, for use with http.FileServer. Unlike http.FS, files can be seeked, which
+// http.ServeContent needs for Range requests, and symlinks are followed.
+func (f *FS) HTTPFileSystem() http.FileSystem {
+	return httpFS{f}
+}
+
+// Returns a handler serving the FS like http.FileServer, with support for Range requests and ETags, so an archive can
+// back a static website or update server. ETags are based on the archive and each file's inode number, so clients'
+// cached copies are only invalidated when the archive changes. Like http.FileServer, directories are served as a listing
+// unless they contain index.html.
+func (f *FS) HTTPHandler() http.Handler {
+	srv := http.FileServer(f.HTTPFileSystem())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fil, err := f.OpenResolved(httpPath(r.URL.Path)); err == nil {
+			if !fil.IsDir() {
+				w.Header().Set("ETag", etag(fil))
+			}
+			fil.Close()
+		}
+		srv.ServeHTTP(w, r)
+	})
+}
+
+type httpFS struct {
+	fs *FS
+}
+
+func (h httpFS) Open(name string) (http.File, error) {
+	f, err := h.fs.OpenResolved(httpPath(name))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &httpFile{File: f, sec: io.NewSectionReader(f, 0, info.Size())}, nil
+}
+
+// Returns the FS path for an HTTP request's path.
+func httpPath(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// A File that can seek, as needed by http.File.
+type httpFile struct {
+	*File
+	sec *io.SectionReader
+}
+
+func (h *httpFile) Read(b []byte) (int, error) {
+	if h.IsDir() {
+		return 0, errors.New("file is a directory")
+	}
+	return h.sec.Read(b)
+}
+
+func (h *httpFile) Seek(offset int64, whence int) (int64, error) {
+	return h.sec.Seek(offset, whence)
+}
+
+func (h *httpFile) Readdir(count int) ([]fs.FileInfo, error) {
+	ents, err := h.ReadDir(count)
+	out := make([]fs.FileInfo, 0, len(ents))
+	for _, e := range ents {
+		info, infoErr := e.Info()
+		if infoErr != nil {
+			return out, infoErr
+		}
+		out = append(out, info)
+	}
+	return out, err
+}
+
+// Serves the regular file f, with support for Range and conditional requests.
+func serveFile(w http.ResponseWriter, r *http.Request, f *File) {
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(f))
+	http.ServeContent(w, r, f.b.Name, info.ModTime(), io.NewSectionReader(f, 0, info.Size()))
+}
+
+// Returns a weak ETag for the file from its inode number and the archive's id. Files in an archive never change,
+// so the ETag only changes if the archive is replaced. It's weak since the id is a hash of the superblock, not of the file's data.
+func etag(f *File) string {
+	return fmt.Sprintf(`W/"%08x-%x"`, f.r.ArchiveID(), f.InodeNum())
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrInvalid):
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+	default:
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,116 @@
+package squashfs_test
+
+// Test archives are built with internal/testimage. testNode mirrors testimage.Node so tests can use short, unexported names.
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/CalebQ42/squashfs"
+	"github.com/CalebQ42/squashfs/internal/testimage"
+)
+
+type testNode struct {
+	name     string
+	mode     fs.FileMode
+	data     []byte
+	target   string
+	children []*testNode
+	link     *testNode // If set, the entry is a hard link to link's inode.
+	uid      uint32
+	gid      uint32
+	mtime    uint32
+	rdev     uint32
+	xattrs   []testXattr
+}
+
+func testDir(name string, children ...*testNode) *testNode {
+	return &testNode{name: name, mode: fs.ModeDir | 0755, children: children}
+}
+
+func testFile(name string, data []byte) *testNode {
+	return &testNode{name: name, mode: 0644, data: data}
+}
+
+func testSymlink(name, target string) *testNode {
+	return &testNode{name: name, mode: fs.ModeSymlink | 0777, target: target}
+}
+
+func testLink(name string, to *testNode) *testNode {
+	return &testNode{name: name, link: to}
+}
+
+type testXattr struct {
+	name  string
+	value string
+}
+
+// Adds xattrs to n. kv holds alternating names and values.
+func withXattrs(n *testNode, kv ...string) *testNode {
+	for i := 0; i < len(kv); i += 2 {
+		n.xattrs = append(n.xattrs, testXattr{kv[i], kv[i+1]})
+	}
+	return n
+}
+
+type testImageOptions struct {
+	blockSize  uint32
+	compress   bool
+	noFrags    bool
+	exportable bool
+	unsorted   bool // Write directory entries in the given order instead of sorting them.
+	modTime    uint32
+	compOpts   []byte // Zlib compression options, written uncompressed after the superblock.
+}
+
+// Builds a squashfs archive, with root as the root directory.
+func buildTestImage(t testing.TB, root *testNode, op testImageOptions) []byte {
+	t.Helper()
+	return testimage.Build(root.node(make(map[*testNode]*testimage.Node)), testimage.Options{
+		BlockSize:  op.blockSize,
+		Compress:   op.compress,
+		NoFrags:    op.noFrags,
+		Exportable: op.exportable,
+		Unsorted:   op.unsorted,
+		ModTime:    op.modTime,
+		CompOpts:   op.compOpts,
+	})
+}
+
+// Converts n to a testimage.Node. converted holds the nodes already converted, so hard links point to the same Node.
+func (n *testNode) node(converted map[*testNode]*testimage.Node) *testimage.Node {
+	if out, ok := converted[n]; ok {
+		return out
+	}
+	out := &testimage.Node{
+		Name:   n.name,
+		Mode:   n.mode,
+		Data:   n.data,
+		Target: n.target,
+		Uid:    n.uid,
+		Gid:    n.gid,
+		Mtime:  n.mtime,
+		Rdev:   n.rdev,
+	}
+	converted[n] = out
+	for _, x := range n.xattrs {
+		out.Xattrs = append(out.Xattrs, testimage.Xattr{Name: x.name, Value: x.value})
+	}
+	if n.link != nil {
+		out.Link = n.link.node(converted)
+	}
+	for _, c := range n.children {
+		out.Children = append(out.Children, c.node(converted))
+	}
+	return out
+}
+
+// Builds an archive from root and opens it.
+func openTestImage(t testing.TB, root *testNode, op testImageOptions) *squashfs.Reader {
+	t.Helper()
+	rdr, err := squashfs.NewReaderFromBytes(buildTestImage(t, root, op))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
@@ -0,0 +1,53 @@
+package squashfs
+
+import (
+	"strconv"
+	"time"
+
+	squashfslow "github.com/CalebQ42/squashfs/low"
+)
+
+// A summary of the archive, with the same information as unsquashfs -s.
+type Info struct {
+	ModTime       time.Time         // When the archive was made, in UTC.
+	Compression   string            // The compression type, named like mksquashfs's -comp option, such as "gzip" or "zstd".
+	BlockSize     uint32            // The size of data blocks.
+	InodeCount    uint32            // The number of inodes, which is the number of files including the root directory.
+	FragmentCount uint32            // The number of fragment blocks.
+	IDCount       uint16            // The number of unique uids and gids.
+	Flags         squashfslow.Flags // How the archive was built, such as whether duplicates were removed.
+	BytesUsed     int64             // The size of the archive. Same as ArchiveSize.
+}
+
+// Returns a summary of the archive from its superblock.
+func (r *Reader) Info() Info {
+	sb := r.Low.Superblock
+	return Info{
+		ModTime:       r.ModTime(),
+		Compression:   compressionName(sb.CompType),
+		BlockSize:     sb.BlockSize,
+		InodeCount:    sb.InodeCount,
+		FragmentCount: sb.FragCount,
+		IDCount:       sb.IdCount,
+		Flags:         sb.Flags,
+		BytesUsed:     int64(sb.Size),
+	}
+}
+
+func compressionName(comp uint16) string {
+	switch comp {
+	case squashfslow.ZlibCompression:
+		return "gzip"
+	case squashfslow.LZMACompression:
+		return "lzma"
+	case squashfslow.LZOCompression:
+		return "lzo"
+	case squashfslow.XZCompression:
+		return "xz"
+	case squashfslow.LZ4Compression:
+		return "lz4"
+	case squashfslow.ZSTDCompression:
+		return "zstd"
+	}
+	return "unknown (" + strconv.Itoa(int(comp)) + ")"
+}
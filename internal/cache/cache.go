@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"slices"
+	"sync"
+)
+
+// A concurrency safe LRU cache. If multiple goroutines request the same missing key, the value is only loaded once.
+type Cache[K comparable, V any] struct {
+	items map[K]*item[V]
+	order []K // Least recently used first.
+	mut   sync.Mutex
+	size  int
+}
+
+type item[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// Creates a cache holding at most size items. If size <= 0, nothing is cached.
+func New[K comparable, V any](size int) *Cache[K, V] {
+	return &Cache[K, V]{
+		items: make(map[K]*item[V]),
+		size:  size,
+	}
+}
+
+// Returns the value at key, calling load to populate it if it's not present.
+// Values that fail to load are not cached.
+func (c *Cache[K, V]) Get(key K, load func() (V, error)) (V, error) {
+	if c == nil || c.size <= 0 {
+		return load()
+	}
+	c.mut.Lock()
+	if it, ok := c.items[key]; ok {
+		c.touch(key)
+		c.mut.Unlock()
+		<-it.done
+		return it.val, it.err
+	}
+	it := &item[V]{done: make(chan struct{})}
+	c.items[key] = it
+	c.order = append(c.order, key)
+	if len(c.order) > c.size {
+		delete(c.items, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.mut.Unlock()
+	it.val, it.err = load()
+	close(it.done)
+	if it.err != nil {
+		c.mut.Lock()
+		if c.items[key] == it {
+			delete(c.items, key)
+			c.order = slices.DeleteFunc(c.order, func(k K) bool { return k == key })
+		}
+		c.mut.Unlock()
+	}
+	return it.val, it.err
+}
+
+// Returns the maximum number of items held.
+func (c *Cache[K, V]) Size() int {
+	if c == nil {
+		return 0
+	}
+	return c.size
+}
+
+// Removes all items from the cache.
+func (c *Cache[K, V]) Clear() {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	clear(c.items)
+	c.order = nil
+	c.mut.Unlock()
+}
+
+// Moves key to the end of the LRU order. c.mut must be held.
+func (c *Cache[K, V]) touch(key K) {
+	i := slices.Index(c.order, key)
+	if i == -1 || i == len(c.order)-1 {
+		return
+	}
+	c.order = append(slices.Delete(c.order, i, i+1), key)
+}
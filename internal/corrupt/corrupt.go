@@ -0,0 +1,18 @@
+// Package corrupt holds the error returned when an archive's structures are invalid, so every package reports corruption the same way.
+package corrupt
+
+import "errors"
+
+// Matched, with errors.Is, by every Error.
+var Err = errors.New("corrupted archive")
+
+// Describes how the archive is corrupted.
+type Error string
+
+func (e Error) Error() string {
+	return "corrupted archive: " + string(e)
+}
+
+func (e Error) Is(target error) bool {
+	return target == Err
+}
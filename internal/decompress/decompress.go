@@ -1,5 +1,16 @@
 package decompress
 
+import "errors"
+
+var ErrTooLarge = errors.New("decompressed data is larger than the buffer")
+
 type Decompressor interface {
 	Decompress([]byte) ([]byte, error)
 }
+
+// Decompressors that can decompress directly into a provided buffer, avoiding allocations.
+type ToDecompressor interface {
+	// Decompress src into dst, returning the number of bytes written.
+	// Returns ErrTooLarge if the decompressed data doesn't fit in dst.
+	DecompressTo(dst, src []byte) (int, error)
+}
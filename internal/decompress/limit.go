@@ -0,0 +1,23 @@
+package decompress
+
+import (
+	"io"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+)
+
+// The largest a block can decompress to, which is the largest allowed block size.
+// Anything larger is from a corrupted (or malicious) archive, so decompression stops instead of using unbounded memory.
+const MaxSize = 1 << 20
+
+// Like io.ReadAll, but returns an error if rdr has more than MaxSize bytes.
+func readAll(rdr io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(rdr, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxSize {
+		return nil, corrupt.Error("block decompresses to more than 1MiB")
+	}
+	return out, nil
+}
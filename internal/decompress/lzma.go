@@ -2,7 +2,6 @@ package decompress
 
 import (
 	"bytes"
-	"io"
 
 	"github.com/ulikunitz/xz/lzma"
 )
@@ -14,5 +13,5 @@ func (l Lzma) Decompress(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(rdr)
+	return readAll(rdr)
 }
@@ -3,11 +3,16 @@ package decompress
 import (
 	"bytes"
 
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 	"github.com/rasky/go-lzo"
 )
 
 type Lzo struct{}
 
 func (l Lzo) Decompress(data []byte) ([]byte, error) {
-	return lzo.Decompress1X(bytes.NewReader(data), len(data))
+	out, err := lzo.Decompress1X(bytes.NewReader(data), len(data))
+	if err == nil && len(out) > MaxSize {
+		return nil, corrupt.Error("block decompresses to more than 1MiB")
+	}
+	return out, err
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"io"
+	"sync"
 )
 
 type Zlib struct{}
@@ -14,5 +15,39 @@ func (z Zlib) Decompress(data []byte) ([]byte, error) {
 		return nil, err
 	}
 	defer rdr.Close()
-	return io.ReadAll(rdr)
+	return readAll(rdr)
+}
+
+// zlib readers are large, so they're reused.
+var zlibPool sync.Pool
+
+func (z Zlib) DecompressTo(dst, src []byte) (int, error) {
+	var rdr io.ReadCloser
+	var err error
+	if p := zlibPool.Get(); p != nil {
+		rdr = p.(io.ReadCloser)
+		err = rdr.(zlib.Resetter).Reset(bytes.NewReader(src), nil)
+	} else {
+		rdr, err = zlib.NewReader(bytes.NewReader(src))
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer zlibPool.Put(rdr)
+	return readTo(rdr, dst)
+}
+
+// Reads all of rdr into dst.
+func readTo(rdr io.Reader, dst []byte) (int, error) {
+	n, err := io.ReadFull(rdr, dst)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, nil
+	} else if err != nil {
+		return n, err
+	}
+	var extra [1]byte
+	if m, _ := rdr.Read(extra[:]); m > 0 {
+		return n, ErrTooLarge
+	}
+	return n, nil
 }
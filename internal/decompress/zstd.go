@@ -2,7 +2,7 @@ package decompress
 
 import (
 	"bytes"
-	"io"
+	"sync"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -10,10 +10,30 @@ import (
 type Zstd struct{}
 
 func (z Zstd) Decompress(data []byte) ([]byte, error) {
-	rdr, err := zstd.NewReader(bytes.NewReader(data))
+	rdr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(MaxSize))
 	if err != nil {
 		return nil, err
 	}
 	defer rdr.Close()
-	return io.ReadAll(rdr)
+	return readAll(rdr)
+}
+
+// A zstd decoder is expensive to create, and DecodeAll is safe for concurrent use, so one is shared.
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxSize))
+})
+
+func (z Zstd) DecompressTo(dst, src []byte) (int, error) {
+	dec, err := zstdDecoder()
+	if err != nil {
+		return 0, err
+	}
+	out, err := dec.DecodeAll(src, dst[:0])
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > len(dst) {
+		return 0, ErrTooLarge
+	}
+	return len(out), nil
 }
@@ -4,9 +4,13 @@ import (
 	"encoding/binary"
 	"io"
 
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 	"github.com/CalebQ42/squashfs/internal/decompress"
 )
 
+// The largest a metadata block can be, both compressed and decompressed.
+const BlockSize = 8192
+
 type Reader struct {
 	r         io.Reader
 	d         decompress.Decompressor
@@ -21,24 +25,44 @@ func NewReader(r io.Reader, d decompress.Decompressor) *Reader {
 	}
 }
 
-func (r *Reader) advance() error {
+func (r *Reader) advance() (err error) {
 	r.curOffset = 0
+	r.dat, err = ReadBlock(r.r, r.d)
+	return err
+}
+
+// Reads a single metadata block from r, decompressing it if necessary.
+func ReadBlock(r io.Reader, d decompress.Decompressor) ([]byte, error) {
 	var size uint16
-	err := binary.Read(r.r, binary.LittleEndian, &size)
+	err := binary.Read(r, binary.LittleEndian, &size)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	realSize := size &^ 0x8000
-	r.dat = make([]byte, realSize)
-	err = binary.Read(r.r, binary.LittleEndian, &r.dat)
+	if realSize > BlockSize {
+		return nil, corrupt.Error("metadata block is larger than 8KiB")
+	}
+	dat := make([]byte, realSize)
+	err = binary.Read(r, binary.LittleEndian, &dat)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if size != realSize {
-		return nil
+		return dat, nil
 	}
-	r.dat, err = r.d.Decompress(r.dat)
-	return err
+	return decompressBlock(d, dat)
+}
+
+// Decompresses a metadata block, making sure it's no larger than BlockSize.
+func decompressBlock(d decompress.Decompressor, dat []byte) ([]byte, error) {
+	dat, err := d.Decompress(dat)
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) > BlockSize {
+		return nil, corrupt.Error("metadata block decompresses to more than 8KiB")
+	}
+	return dat, nil
 }
 
 func (r *Reader) Read(b []byte) (int, error) {
@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+	"github.com/CalebQ42/squashfs/internal/decompress"
+)
+
+// A fully decompressed metadata table, such as the inode or directory table.
+type Table struct {
+	dat    []byte
+	blocks map[uint64]int // Location of each block (relative to the table's start) to where its data starts in dat.
+	locs   []uint64       // Location of each block, in order.
+}
+
+// Reads and decompresses all metadata blocks between start and end.
+func ReadTable(r io.ReaderAt, start, end int64, d decompress.Decompressor) (*Table, error) {
+	t := &Table{blocks: make(map[uint64]int)}
+	var hdr [2]byte
+	for off := start; off < end; {
+		t.blocks[uint64(off-start)] = len(t.dat)
+		t.locs = append(t.locs, uint64(off-start))
+		_, err := r.ReadAt(hdr[:], off)
+		if err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint16(hdr[:])
+		realSize := size &^ 0x8000
+		if realSize > BlockSize {
+			return nil, corrupt.Error("metadata block is larger than 8KiB")
+		}
+		dat := make([]byte, realSize)
+		_, err = r.ReadAt(dat, off+2)
+		if err != nil {
+			return nil, err
+		}
+		if size == realSize {
+			dat, err = decompressBlock(d, dat)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t.dat = append(t.dat, dat...)
+		off += 2 + int64(realSize)
+	}
+	return t, nil
+}
+
+// Returns a reader starting offset bytes into the block at the given location (relative to the table's start).
+// Reads continue into the following blocks.
+func (t *Table) Reader(block uint64, offset uint16) (io.Reader, error) {
+	pos, ok := t.blocks[block]
+	if !ok || pos+int(offset) > len(t.dat) {
+		return nil, corrupt.Error("metadata location out of bounds")
+	}
+	return bytes.NewReader(t.dat[pos+int(offset):]), nil
+}
+
+// Returns the size of the decompressed table.
+func (t *Table) Size() int {
+	return len(t.dat)
+}
+
+// Returns the decompressed table.
+func (t *Table) Data() []byte {
+	return t.dat
+}
+
+// Returns the location of the block (relative to the table's start) and the offset into it of pos in the decompressed table.
+// All blocks, except the last, decompress to 8KiB.
+func (t *Table) Location(pos int) (block uint64, offset uint16) {
+	i := min(pos/8192, len(t.locs)-1)
+	return t.locs[i], uint16(pos - i*8192)
+}
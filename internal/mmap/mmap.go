@@ -0,0 +1,46 @@
+package mmap
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrClosed = errors.New("mmap: mapping is closed")
+
+// A read-only memory mapping of a file, implementing io.ReaderAt.
+type Mapping struct {
+	data []byte
+	mut  sync.RWMutex // Held for reading during ReadAt, so Close doesn't unmap the memory while it's being read.
+}
+
+func (m *Mapping) ReadAt(p []byte, off int64) (int, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	if m.data == nil {
+		return 0, ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("mmap: negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns the size of the mapping.
+func (m *Mapping) Len() int {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return len(m.data)
+}
+
+// Returns the size of the mapping. Same as Len, but matches bytes.Reader and io.SectionReader.
+func (m *Mapping) Size() int64 {
+	return int64(m.Len())
+}
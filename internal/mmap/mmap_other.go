@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package mmap
+
+import (
+	"errors"
+	"os"
+)
+
+// Whether memory mapping is supported on this platform.
+const Supported = false
+
+func Map(f *os.File) (*Mapping, error) {
+	return nil, errors.New("mmap: not supported on this platform")
+}
+
+func (m *Mapping) Close() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.data = nil
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package mmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Whether memory mapping is supported on this platform.
+const Supported = true
+
+// Maps the entirety of f into memory. f can be closed once mapped.
+func Map(f *os.File) (*Mapping, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() <= 0 {
+		return nil, errors.New("mmap: cannot map an empty file")
+	}
+	if int64(int(stat.Size())) != stat.Size() {
+		return nil, errors.New("mmap: file too large to map")
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{data: data}, nil
+}
+
+// Unmaps the memory once in progress calls to ReadAt return. Further calls to ReadAt return ErrClosed.
+func (m *Mapping) Close() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,556 @@
+// Package testimage is a tiny squashfs writer used to generate small, deterministic archives for tests
+// without needing mksquashfs or a network connection.
+package testimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/fs"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// A file, directory, or other entry in an archive.
+type Node struct {
+	Name     string
+	Mode     fs.FileMode
+	Data     []byte
+	Target   string
+	Children []*Node
+	Link     *Node // If set, the entry is a hard link to Link's inode.
+	Uid      uint32
+	Gid      uint32
+	Mtime    uint32
+	Rdev     uint32
+	Xattrs   []Xattr
+
+	num uint32
+	ref uint64
+}
+
+func Dir(name string, children ...*Node) *Node {
+	return &Node{Name: name, Mode: fs.ModeDir | 0755, Children: children}
+}
+
+func File(name string, data []byte) *Node {
+	return &Node{Name: name, Mode: 0644, Data: data}
+}
+
+func Symlink(name, target string) *Node {
+	return &Node{Name: name, Mode: fs.ModeSymlink | 0777, Target: target}
+}
+
+func Link(name string, to *Node) *Node {
+	return &Node{Name: name, Link: to}
+}
+
+type Xattr struct {
+	Name  string
+	Value string
+}
+
+// Adds xattrs to n. kv holds alternating names and values.
+func WithXattrs(n *Node, kv ...string) *Node {
+	for i := 0; i < len(kv); i += 2 {
+		n.Xattrs = append(n.Xattrs, Xattr{kv[i], kv[i+1]})
+	}
+	return n
+}
+
+type Options struct {
+	BlockSize  uint32 // Defaults to 4096.
+	Compress   bool   // Compress data and metadata with zlib. Otherwise everything is stored uncompressed.
+	NoFrags    bool
+	Exportable bool
+	Unsorted   bool // Write directory entries in the given order instead of sorting them.
+	ModTime    uint32
+	CompOpts   []byte // Zlib compression options, written uncompressed after the superblock.
+}
+
+type metaWriter struct {
+	out      []byte
+	cur      []byte
+	compress bool
+}
+
+func (m *metaWriter) pos() (block uint32, offset uint16) {
+	return uint32(len(m.out)), uint16(len(m.cur))
+}
+
+func (m *metaWriter) ref() uint64 {
+	b, o := m.pos()
+	return uint64(b)<<16 | uint64(o)
+}
+
+func (m *metaWriter) write(b []byte) {
+	for len(b) > 0 {
+		n := min(8192-len(m.cur), len(b))
+		m.cur = append(m.cur, b[:n]...)
+		b = b[n:]
+		if len(m.cur) == 8192 {
+			m.flush()
+		}
+	}
+}
+
+func (m *metaWriter) writeLE(v any) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, v)
+	m.write(buf.Bytes())
+}
+
+func (m *metaWriter) flush() {
+	if len(m.cur) == 0 {
+		return
+	}
+	dat, size := m.cur, uint16(len(m.cur))|0x8000
+	if m.compress {
+		if c := zlibCompress(m.cur); len(c) < len(m.cur) {
+			dat, size = c, uint16(len(c))
+		}
+	}
+	m.out = binary.LittleEndian.AppendUint16(m.out, size)
+	m.out = append(m.out, dat...)
+	m.cur = nil
+}
+
+func zlibCompress(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+type imageBuilder struct {
+	root     *Node
+	fileData map[*Node]fileData
+	op       Options
+	data     []byte
+	frag     []byte
+	fragEnts []uint64 // start, size pairs
+	ids      []uint32
+	inodes   metaWriter
+	dirs     metaWriter
+	count    uint32
+	export   map[uint32]uint64
+	xattrKV  metaWriter
+	xattrIDs []uint64          // ref, count and size pairs.
+	values   map[string]uint64 // Refs of xattr values, so repeated values are stored out of line like mksquashfs.
+}
+
+// Builds a squashfs archive, with root as the root directory. Nodes are numbered as they're written, so each Node can only
+// be in one archive at a time.
+func Build(root *Node, op Options) []byte {
+	if op.BlockSize == 0 {
+		op.BlockSize = 4096
+	}
+	b := &imageBuilder{
+		root:     root,
+		fileData: make(map[*Node]fileData),
+		op:       op,
+		data:     make([]byte, 96),
+		inodes:   metaWriter{compress: op.Compress},
+		dirs:     metaWriter{compress: op.Compress},
+		export:   make(map[uint32]uint64),
+		xattrKV:  metaWriter{compress: op.Compress},
+		values:   make(map[string]uint64),
+	}
+	if op.CompOpts != nil {
+		b.data = binary.LittleEndian.AppendUint16(b.data, uint16(len(op.CompOpts))|0x8000)
+		b.data = append(b.data, op.CompOpts...)
+	}
+	b.number(root)
+	b.writeData(root)
+	b.flushFrag()
+	b.writeInodes(root)
+	root.ref = b.writeDir(root, b.count+1)
+	b.inodes.flush()
+	b.dirs.flush()
+
+	out := b.data
+	inodeStart := uint64(len(out))
+	out = append(out, b.inodes.out...)
+	dirStart := uint64(len(out))
+	out = append(out, b.dirs.out...)
+
+	fragStart := uint64(len(out))
+	var fragCount uint32
+	if len(b.fragEnts) > 0 {
+		var fragMeta metaWriter
+		fragMeta.compress = op.Compress
+		for i := 0; i < len(b.fragEnts); i += 2 {
+			fragMeta.writeLE([]uint64{b.fragEnts[i], b.fragEnts[i+1]})
+			fragCount++
+		}
+		out, fragStart = appendTable(out, &fragMeta)
+	}
+	exportStart := ^uint64(0)
+	if op.Exportable {
+		var exportMeta metaWriter
+		exportMeta.compress = op.Compress
+		for i := uint32(1); i <= b.count; i++ {
+			exportMeta.writeLE(b.export[i])
+		}
+		out, exportStart = appendTable(out, &exportMeta)
+	}
+	var idMeta metaWriter
+	idMeta.compress = op.Compress
+	idMeta.writeLE(b.ids)
+	out, idStart := appendTable(out, &idMeta)
+
+	xattrStart := ^uint64(0)
+	if len(b.xattrIDs) > 0 {
+		b.xattrKV.flush()
+		kvStart := uint64(len(out))
+		out = append(out, b.xattrKV.out...)
+		var idsMeta metaWriter
+		idsMeta.compress = op.Compress
+		for i := 0; i < len(b.xattrIDs); i += 2 {
+			idsMeta.writeLE([]uint64{b.xattrIDs[i], b.xattrIDs[i+1]})
+		}
+		var idx uint64
+		out, idx = appendTable(out, &idsMeta)
+		// The header goes right before the block index.
+		index := slices.Clone(out[idx:])
+		out = out[:idx]
+		xattrStart = uint64(len(out))
+		out = binary.LittleEndian.AppendUint64(out, kvStart)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(b.xattrIDs)/2))
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = append(out, index...)
+	}
+
+	var flags uint16
+	if xattrStart == ^uint64(0) {
+		flags |= 0x200
+	}
+	if !op.Compress {
+		flags |= 0x1 | 0x2 | 0x8 | 0x800 | 0x100
+	}
+	if op.NoFrags {
+		flags |= 0x10
+	}
+	if op.Exportable {
+		flags |= 0x80
+	}
+	if op.CompOpts != nil {
+		flags |= 0x400
+	}
+	sb := []any{
+		uint32(0x73717368), b.count, op.ModTime, op.BlockSize, fragCount,
+		uint16(1), uint16(bits.TrailingZeros32(op.BlockSize)), flags, uint16(len(b.ids)), uint16(4), uint16(0),
+		root.ref, uint64(len(out)), idStart, xattrStart, inodeStart, dirStart, fragStart, exportStart,
+	}
+	var buf bytes.Buffer
+	for _, v := range sb {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	copy(out, buf.Bytes())
+	return out
+}
+
+// Appends the metadata blocks of a lookup table, followed by the table's block index.
+// Returns the location of the index.
+func appendTable(out []byte, m *metaWriter) ([]byte, uint64) {
+	m.flush()
+	var starts []uint64
+	for off := 0; off < len(m.out); {
+		starts = append(starts, uint64(len(out)+off))
+		size := int(binary.LittleEndian.Uint16(m.out[off:]) &^ 0x8000)
+		off += 2 + size
+	}
+	out = append(out, m.out...)
+	idx := uint64(len(out))
+	for _, s := range starts {
+		out = binary.LittleEndian.AppendUint64(out, s)
+	}
+	return out, idx
+}
+
+func (b *imageBuilder) number(n *Node) {
+	if n.Link == nil {
+		b.count++
+		n.num = b.count
+	}
+	for _, c := range n.Children {
+		b.number(c)
+	}
+}
+
+func (b *imageBuilder) idIndex(id uint32) uint16 {
+	i := slices.Index(b.ids, id)
+	if i == -1 {
+		b.ids = append(b.ids, id)
+		i = len(b.ids) - 1
+	}
+	return uint16(i)
+}
+
+// Writes n's xattrs to the xattr tables and returns their index. Returns 0xFFFFFFFF if n doesn't have any.
+func (b *imageBuilder) xattrIndex(n *Node) uint32 {
+	if len(n.Xattrs) == 0 {
+		return 0xFFFFFFFF
+	}
+	ref := b.xattrKV.ref()
+	var size uint64
+	for _, x := range n.Xattrs {
+		typ, name := uint16(0), x.Name
+		for i, prefix := range []string{"user.", "trusted.", "security."} {
+			if strings.HasPrefix(x.Name, prefix) {
+				typ, name = uint16(i), strings.TrimPrefix(x.Name, prefix)
+			}
+		}
+		valRef, repeated := b.values[x.Value]
+		if repeated {
+			typ |= 0x100
+		}
+		b.xattrKV.writeLE([]uint16{typ, uint16(len(name))})
+		b.xattrKV.write([]byte(name))
+		if repeated {
+			b.xattrKV.writeLE(uint32(8))
+			b.xattrKV.writeLE(valRef)
+		} else {
+			b.values[x.Value] = b.xattrKV.ref()
+			b.xattrKV.writeLE(uint32(len(x.Value)))
+			b.xattrKV.write([]byte(x.Value))
+		}
+		size += uint64(len(x.Name) + len(x.Value))
+	}
+	b.xattrIDs = append(b.xattrIDs, ref, uint64(len(n.Xattrs))|size<<32)
+	return uint32(len(b.xattrIDs)/2 - 1)
+}
+
+func (b *imageBuilder) flushFrag() {
+	if len(b.frag) == 0 {
+		return
+	}
+	dat, size := b.frag, uint64(len(b.frag))|1<<24
+	if b.op.Compress {
+		if c := zlibCompress(b.frag); len(c) < len(b.frag) {
+			dat, size = c, uint64(len(c))
+		}
+	}
+	b.fragEnts = append(b.fragEnts, uint64(len(b.data)), size)
+	b.data = append(b.data, dat...)
+	b.frag = nil
+}
+
+type fileData struct {
+	start   uint32
+	fragInd uint32
+	fragOff uint32
+	sizes   []uint32
+}
+
+func (b *imageBuilder) writeData(n *Node) {
+	for _, c := range n.Children {
+		b.writeData(c)
+	}
+	if n.Link != nil || n.Mode.Type() != 0 {
+		return
+	}
+	fd := fileData{start: uint32(len(b.data)), fragInd: 0xFFFFFFFF}
+	dat := n.Data
+	bs := int(b.op.BlockSize)
+	for len(dat) > 0 {
+		if len(dat) < bs && !b.op.NoFrags {
+			if len(b.frag)+len(dat) > bs {
+				b.flushFrag()
+			}
+			fd.fragInd = uint32(len(b.fragEnts) / 2)
+			fd.fragOff = uint32(len(b.frag))
+			b.frag = append(b.frag, dat...)
+			break
+		}
+		blk := dat[:min(bs, len(dat))]
+		dat = dat[len(blk):]
+		if !slices.ContainsFunc(blk, func(c byte) bool { return c != 0 }) {
+			fd.sizes = append(fd.sizes, 0)
+			continue
+		}
+		out, size := blk, uint32(len(blk))|1<<24
+		if b.op.Compress {
+			if c := zlibCompress(blk); len(c) < len(blk) {
+				out, size = c, uint32(len(c))
+			}
+		}
+		fd.sizes = append(fd.sizes, size)
+		b.data = append(b.data, out...)
+	}
+	b.fileData[n] = fd
+}
+
+func (b *imageBuilder) header(n *Node, typ uint16) {
+	perm := uint16(n.Mode.Perm())
+	if n.Mode&fs.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if n.Mode&fs.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if n.Mode&fs.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+	n.ref = b.inodes.ref()
+	b.export[n.num] = n.ref
+	b.inodes.writeLE([]uint16{typ, perm, b.idIndex(n.Uid), b.idIndex(n.Gid)})
+	b.inodes.writeLE([]uint32{n.Mtime, n.num})
+}
+
+func (n *Node) inodeType() uint16 {
+	switch n.Mode.Type() {
+	case fs.ModeDir:
+		return 1
+	case fs.ModeSymlink:
+		return 3
+	case fs.ModeDevice:
+		return 4
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return 5
+	case fs.ModeNamedPipe:
+		return 6
+	case fs.ModeSocket:
+		return 7
+	}
+	return 2
+}
+
+func (n *Node) linkCount() uint32 {
+	var count uint32 = 1
+	if n.Mode.IsDir() {
+		count = 2
+		for _, c := range n.Children {
+			if c.Link == nil && c.Mode.IsDir() {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Writes all non-directory inodes, then all directory inodes, children first.
+func (b *imageBuilder) writeInodes(n *Node) {
+	for _, c := range n.Children {
+		if c.Link != nil || c.Mode.IsDir() {
+			continue
+		}
+		links := c.linkCount() + b.linksTo(c, b.root)
+		typ := c.inodeType()
+		if (typ == 2 && links > 1) || len(c.Xattrs) > 0 {
+			// Basic inodes don't have a link count (for files) or xattrs, so use the extended type.
+			typ += 7
+		}
+		xattr := b.xattrIndex(c)
+		b.header(c, typ)
+		switch typ {
+		case 2:
+			fd := b.fileData[c]
+			b.inodes.writeLE([]uint32{fd.start, fd.fragInd, fd.fragOff, uint32(len(c.Data))})
+			b.inodes.writeLE(fd.sizes)
+		case 9:
+			fd := b.fileData[c]
+			b.inodes.writeLE([]uint64{uint64(fd.start), uint64(len(c.Data)), 0})
+			b.inodes.writeLE([]uint32{links, fd.fragInd, fd.fragOff, xattr})
+			b.inodes.writeLE(fd.sizes)
+		case 3, 10:
+			b.inodes.writeLE([]uint32{links, uint32(len(c.Target))})
+			b.inodes.write([]byte(c.Target))
+			if typ == 10 {
+				b.inodes.writeLE(xattr)
+			}
+		case 4, 5:
+			b.inodes.writeLE([]uint32{links, c.Rdev})
+		case 11, 12:
+			b.inodes.writeLE([]uint32{links, c.Rdev, xattr})
+		case 6, 7:
+			b.inodes.writeLE(links)
+		case 13, 14:
+			b.inodes.writeLE([]uint32{links, xattr})
+		}
+	}
+	for _, c := range n.Children {
+		if c.Link == nil && c.Mode.IsDir() {
+			b.writeInodes(c)
+			c.ref = b.writeDir(c, n.num)
+		}
+	}
+}
+
+// Returns the number of hard links in the tree at n that point to target.
+func (b *imageBuilder) linksTo(target, n *Node) (count uint32) {
+	if n.Link == target {
+		count++
+	}
+	for _, c := range n.Children {
+		count += b.linksTo(target, c)
+	}
+	return
+}
+
+// Writes the directory listing and the inode of the given directory and returns it's inode reference.
+func (b *imageBuilder) writeDir(n *Node, parent uint32) uint64 {
+	children := slices.Clone(n.Children)
+	if !b.op.Unsorted {
+		slices.SortFunc(children, func(a, b *Node) int { return strings.Compare(a.Name, b.Name) })
+	}
+	dirBlock, dirOffset := b.dirs.pos()
+	var size uint32
+	// Like mksquashfs, a new header is started whenever the listing crosses into a new metadata block
+	// and an index entry pointing to it is added so lookups can skip ahead.
+	type dirIndex struct {
+		index, start uint32
+		name         string
+	}
+	var indexes []dirIndex
+	for i := 0; i < len(children); {
+		target := func(c *Node) *Node {
+			if c.Link != nil {
+				return c.Link
+			}
+			return c
+		}
+		first := target(children[i])
+		headerBlock, off := b.dirs.pos()
+		if headerBlock != dirBlock && (len(indexes) == 0 || indexes[len(indexes)-1].start != headerBlock) {
+			indexes = append(indexes, dirIndex{index: size, start: headerBlock, name: children[i].Name})
+		}
+		// Only include entries that start in the header's metadata block.
+		j := i
+		for end := int(off) + 12; j < len(children) && j-i < 256 && target(children[j]).ref>>16 == first.ref>>16; j++ {
+			if j > i && end >= 8192 {
+				break
+			}
+			end += 8 + len(children[j].Name)
+		}
+		b.dirs.writeLE([]uint32{uint32(j - i - 1), uint32(first.ref >> 16), first.num})
+		size += 12
+		for _, c := range children[i:j] {
+			in := target(c)
+			b.dirs.writeLE([]uint16{uint16(in.ref & 0xFFFF), uint16(int16(in.num - first.num)), in.inodeType(), uint16(len(c.Name) - 1)})
+			b.dirs.write([]byte(c.Name))
+			size += 8 + uint32(len(c.Name))
+		}
+		i = j
+	}
+	if len(indexes) > 0 || len(n.Xattrs) > 0 {
+		xattr := b.xattrIndex(n)
+		b.header(n, 8)
+		b.inodes.writeLE([]uint32{n.linkCount(), size + 3, dirBlock, parent})
+		b.inodes.writeLE([]uint16{uint16(len(indexes)), dirOffset})
+		b.inodes.writeLE(xattr)
+		for _, ind := range indexes {
+			b.inodes.writeLE([]uint32{ind.index, ind.start, uint32(len(ind.name) - 1)})
+			b.inodes.write([]byte(ind.name))
+		}
+		return n.ref
+	}
+	b.header(n, 1)
+	b.inodes.writeLE(dirBlock)
+	b.inodes.writeLE(n.linkCount())
+	b.inodes.writeLE([]uint16{uint16(size + 3), dirOffset})
+	b.inodes.writeLE(parent)
+	return n.ref
+}
@@ -1,6 +1,9 @@
 package toreader
 
-import "io"
+import (
+	"io"
+	"io/fs"
+)
 
 type OffsetReader struct {
 	r   io.ReaderAt
@@ -17,3 +20,29 @@ func NewOffsetReader(r io.ReaderAt, off int64) *OffsetReader {
 func (r OffsetReader) ReadAt(p []byte, off int64) (n int, e error) {
 	return r.r.ReadAt(p, off+r.off)
 }
+
+// Returns the size of the underlying data after the offset, if it's known.
+func (r OffsetReader) Size() int64 {
+	size, ok := Size(r.r)
+	if !ok {
+		return -1
+	}
+	return max(size-r.off, 0)
+}
+
+// Returns the total size of r if it can be determined, either with a Size method (such as bytes.Reader and
+// io.SectionReader) or a Stat method (such as os.File). A negative size is treated as unknown.
+func Size(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		size := r.Size()
+		return size, size >= 0
+	case interface{ Stat() (fs.FileInfo, error) }:
+		stat, err := r.Stat()
+		if err != nil || !stat.Mode().IsRegular() {
+			return 0, false
+		}
+		return stat.Size(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,184 @@
+package squashfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The root directory's name when listing, matching unsquashfs's default destination.
+const listRoot = "squashfs-root"
+
+// Options for ListWithOptions. The zero value lists every file's path, like List without longFormat.
+type ListOptions struct {
+	LongFormat bool                    //Include each file's permissions, owner, size, and modification time, like unsquashfs -lls.
+	UserName   func(uid uint32) string //Returns the name to show for a uid. If nil or it returns "", the uid is shown.
+	GroupName  func(gid uint32) string //Returns the name to show for a gid. If nil or it returns "", the gid is shown.
+	NoRoot     bool                    //Show paths relative to the listed directory, without the "squashfs-root" prefix or a line for the directory itself.
+	NoRecurse  bool                    //Only list the directory's entries, not the contents of subdirectories.
+}
+
+// Writes the path of every file in the FS (the whole archive for a Reader) to w, one per line, starting with the root directory ("squashfs-root").
+// If longFormat is true, each line also has the file's permissions, owner, size, and modification time, like
+// unsquashfs -lls, with times in the local time zone. Owners are always numeric, as if unsquashfs was run with -numeric-owner.
+func (f *FS) List(w io.Writer, longFormat bool) error {
+	return f.ListWithOptions(w, &ListOptions{LongFormat: longFormat})
+}
+
+// Lists the FS like List, with owners' names resolved and what's listed set via ListOptions.
+func (f *FS) ListWithOptions(w io.Writer, op *ListOptions) error {
+	return f.Walk(func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		var skip error
+		if op.NoRecurse && p != "." && d.IsDir() {
+			skip = fs.SkipDir
+		}
+		name := path.Join(listRoot, p)
+		if op.NoRoot {
+			if p == "." {
+				return nil
+			}
+			name = p
+		}
+		if !op.LongFormat {
+			if _, err = fmt.Fprintln(w, name); err != nil {
+				return err
+			}
+			return skip
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sys := info.Sys().(*Stat)
+		uid, gid := idName(sys.Uid, op.UserName), idName(sys.Gid, op.GroupName)
+		// Padded the same way as unsquashfs.
+		pad := max(25-len(uid)-len(gid), 0)
+		var size string
+		if info.Mode()&fs.ModeDevice != 0 {
+			maj, min := sys.Device()
+			size = fmt.Sprintf("%*s%3d,%3d", max(pad-3, 0), " ", maj, min)
+		} else {
+			size = fmt.Sprintf("%*d", pad, info.Size())
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			fil, err := f.open(p)
+			if err != nil {
+				return err
+			}
+			name += " -> " + fil.SymlinkPath()
+		}
+		_, err = fmt.Fprintln(w, lsMode(info.Mode()), uid+"/"+gid, size, info.ModTime().Local().Format("2006-01-02 15:04"), name)
+		if err != nil {
+			return err
+		}
+		return skip
+	})
+}
+
+// Returns the mode formatted like ls -l, such as "drwxr-xr-x" or "-rwsr-xr-x".
+func lsMode(m fs.FileMode) string {
+	out := []byte("----------")
+	switch {
+	case m.IsDir():
+		out[0] = 'd'
+	case m&fs.ModeSymlink != 0:
+		out[0] = 'l'
+	case m&fs.ModeCharDevice != 0:
+		out[0] = 'c'
+	case m&fs.ModeDevice != 0:
+		out[0] = 'b'
+	case m&fs.ModeNamedPipe != 0:
+		out[0] = 'p'
+	case m&fs.ModeSocket != 0:
+		out[0] = 's'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := range 9 {
+		if m&(1<<(8-i)) != 0 {
+			out[i+1] = rwx[i]
+		}
+	}
+	// Special bits replace the execute bit, in upper case if it isn't set.
+	special := func(set bool, i int, c byte) {
+		if !set {
+			return
+		}
+		if out[i] == '-' {
+			c -= 'a' - 'A'
+		}
+		out[i] = c
+	}
+	special(m&fs.ModeSetuid != 0, 3, 's')
+	special(m&fs.ModeSetgid != 0, 6, 's')
+	special(m&fs.ModeSticky != 0, 9, 't')
+	return string(out)
+}
+
+func idName(id uint32, name func(uint32) string) string {
+	if name != nil {
+		if n := name(id); n != "" {
+			return n
+		}
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Parses a passwd or group file, such as the archive's /etc/passwd, into a map of ids to names.
+// The result's Name method can be used for ListOptions.UserName and ListOptions.GroupName.
+func ParseIDNames(r io.Reader) (IDNames, error) {
+	out := make(IDNames)
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name:password:id:...
+		fields := strings.SplitN(line, ":", 4)
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := out[uint32(id)]; !ok {
+			out[uint32(id)] = fields[0]
+		}
+	}
+	return out, scan.Err()
+}
+
+// Maps uids or gids to names.
+type IDNames map[uint32]string
+
+// Returns the name of id, or "" if it's not known.
+func (n IDNames) Name(id uint32) string {
+	return n[id]
+}
+
+// Returns the name of the user with the given uid on the host system, or "" if it's not known.
+func HostUserName(uid uint32) string {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// Returns the name of the group with the given gid on the host system, or "" if it's not known.
+func HostGroupName(gid uint32) string {
+	g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	if err != nil {
+		return ""
+	}
+	return g.Name
+}
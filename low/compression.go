@@ -0,0 +1,80 @@
+package squashfslow
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/CalebQ42/squashfs/internal/metadata"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+// Compression options of a zlib (gzip) archive.
+type ZlibOptions struct {
+	CompressionLevel uint32
+	WindowSize       uint16
+	Strategies       uint16 // A bit field of the strategies tried when compressing.
+}
+
+// Compression options of an lzo archive.
+type LZOOptions struct {
+	Algorithm        uint32
+	CompressionLevel uint32
+}
+
+// Compression options of an xz archive.
+type XZOptions struct {
+	DictionarySize uint32
+	Filters        uint32 // A bit field of the executable filters tried when compressing.
+}
+
+// Compression options of an lz4 archive.
+type LZ4Options struct {
+	Version uint32
+	Flags   uint32
+}
+
+// Compression options of a zstd archive.
+type ZSTDOptions struct {
+	CompressionLevel uint32
+}
+
+// Returns the compression options stored after the superblock, decompressed if necessary. These describe the settings
+// the archive was built with and aren't needed to read it. If the archive doesn't have compression options, returns nil.
+func (r *Reader) CompressionOptionsRaw() ([]byte, error) {
+	if !r.Superblock.CompressionOptions() {
+		return nil, nil
+	}
+	return metadata.ReadBlock(toreader.NewReader(r.r, 96), r.d)
+}
+
+// Returns the parsed compression options. Depending on the archive's compression type, the returned value is a
+// ZlibOptions, LZOOptions, XZOptions, LZ4Options, or ZSTDOptions. If the archive doesn't have compression options,
+// or their format isn't known (such as for lzma), returns nil. Use CompressionOptionsRaw to get the options regardless.
+func (r *Reader) CompressionOptions() (any, error) {
+	raw, err := r.CompressionOptionsRaw()
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	switch r.Superblock.CompType {
+	case ZlibCompression:
+		return parseOptions[ZlibOptions](raw)
+	case LZOCompression:
+		return parseOptions[LZOOptions](raw)
+	case XZCompression:
+		return parseOptions[XZOptions](raw)
+	case LZ4Compression:
+		return parseOptions[LZ4Options](raw)
+	case ZSTDCompression:
+		return parseOptions[ZSTDOptions](raw)
+	}
+	return nil, nil
+}
+
+func parseOptions[T any](raw []byte) (any, error) {
+	var out T
+	err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
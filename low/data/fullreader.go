@@ -1,15 +1,14 @@
 package data
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
-	"math"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/CalebQ42/squashfs/internal/decompress"
-	"github.com/CalebQ42/squashfs/internal/toreader"
 )
 
 type FragReaderConstructor func() (io.Reader, error)
@@ -19,6 +18,12 @@ type FullReader struct {
 	d              decompress.Decompressor
 	frag           FragReaderConstructor
 	retPool        *sync.Pool
+	goroutines     *atomic.Uint64
+	offsetsOnce    *sync.Once
+	lastMut        *sync.Mutex // Guards lastBlock, the most recent block decompressed by ReadAt.
+	lastBlock      *retValue
+	noLastBlock    bool // Set by DisableBlockCache.
+	offsets        []int64
 	sizes          []uint32
 	initialOffset  int64
 	finalBlockSize uint64
@@ -35,6 +40,8 @@ func NewFullReader(r io.ReaderAt, initialOffset int64, d decompress.Decompressor
 		goroutineLimit: uint16(runtime.NumCPU()),
 		finalBlockSize: finalBlockSize,
 		blockSize:      blockSize,
+		offsetsOnce:    &sync.Once{},
+		lastMut:        &sync.Mutex{},
 		retPool: &sync.Pool{
 			New: func() any {
 				return &retValue{}
@@ -47,58 +54,101 @@ func (r *FullReader) AddFrag(frag FragReaderConstructor) {
 	r.frag = frag
 }
 
+// Set a counter that's incremented whenever a goroutine is started to decompress a block.
+func (r *FullReader) SetGoroutineCounter(c *atomic.Uint64) {
+	r.goroutines = c
+}
+
+// Set the maximum number of blocks decompressed at the same time during WriteTo.
+// Also limits how many decompressed blocks are held in memory at once.
+// If limit is 0, runtime.NumCPU() is used.
 func (r *FullReader) SetGoroutineLimit(limit uint16) {
+	if limit == 0 {
+		limit = uint16(runtime.NumCPU())
+	}
 	r.goroutineLimit = limit
 }
 
+// Stops ReadAt from keeping the most recently decompressed block, so no block is held in memory between calls.
+// Small sequential ReadAt calls decompress the same block again each time.
+func (r *FullReader) DisableBlockCache() {
+	r.noLastBlock = true
+}
+
 type retValue struct {
-	err   error
-	data  []byte
-	index uint64
+	err    error
+	data   []byte
+	index  uint64
+	offset int64 // Only set when stream is true.
+	size   int64
+	pooled bool
+	stream bool // The block is uncompressed and is copied directly from the archive when written.
 }
 
 func (r *FullReader) process(index uint64, fileOffset uint64, retChan chan *retValue) {
 	ret := r.retPool.Get().(*retValue)
 	ret.index = index
-	realSize := r.sizes[index] &^ (1 << 24)
-	if realSize == 0 {
-		if index == uint64(len(r.sizes))-1 && r.frag == nil {
-			ret.data = make([]byte, r.finalBlockSize)
-		} else {
-			ret.data = make([]byte, r.blockSize)
-		}
-		ret.err = nil
+	if size := r.sizes[index] &^ (1 << 24); size != r.sizes[index] && size != 0 && size <= r.blockSize {
+		ret.stream = true
+		ret.offset = r.initialOffset + int64(fileOffset)
+		ret.size = int64(size)
 		retChan <- ret
 		return
 	}
-	ret.data = make([]byte, realSize)
-	ret.err = binary.Read(toreader.NewReader(r.r, int64(r.initialOffset)+int64(fileOffset)), binary.LittleEndian, &ret.data)
-	if r.sizes[index] == realSize {
-		ret.data, ret.err = r.d.Decompress(ret.data)
-	}
+	ret.data, ret.pooled, ret.err = readBlock(r.r, r.d, r.initialOffset+int64(fileOffset), r.sizes[index], sparseSize(index, len(r.sizes), r.frag != nil, r.finalBlockSize, r.blockSize), r.blockSize)
 	retChan <- ret
 }
 
+// Returns res and its data to their pools.
+func (r *FullReader) release(res *retValue) {
+	if res.pooled {
+		putBuf(res.data, r.blockSize)
+	}
+	res.data = nil
+	res.pooled = false
+	res.stream = false
+	r.retPool.Put(res)
+}
+
+// Writes the block's data to w. Uncompressed blocks are copied straight from the archive instead of being buffered.
+func (r *FullReader) write(w io.Writer, res *retValue) (int64, error) {
+	if !res.stream {
+		n, err := w.Write(res.data)
+		return int64(n), err
+	}
+	n, err := io.Copy(w, io.NewSectionReader(r.r, res.offset, res.size))
+	if err == nil && n < res.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
 func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 	var curIndex uint64
 	var curOffset uint64
-	var toProcess uint16
+	var toProcess int
 	var wrote int64
 	cache := make(map[uint64]*retValue)
 	var errCache []error
-	retChan := make(chan *retValue, r.goroutineLimit)
-	for i := uint64(0); i < uint64(math.Ceil(float64(len(r.sizes))/float64(r.goroutineLimit))); i++ {
-		toProcess = uint16(len(r.sizes)) - (uint16(i) * r.goroutineLimit)
-		if toProcess > r.goroutineLimit {
-			toProcess = r.goroutineLimit
-		}
+	limit := int(r.goroutineLimit)
+	retChan := make(chan *retValue, limit)
+	for batchStart := 0; batchStart < len(r.sizes); batchStart += limit {
+		toProcess = min(len(r.sizes)-batchStart, limit)
 		// Start all the goroutines
-		for j := uint16(0); j < toProcess; j++ {
-			go r.process((i*uint64(r.goroutineLimit))+uint64(j), curOffset, retChan)
-			curOffset += uint64(r.sizes[(i*uint64(r.goroutineLimit))+uint64(j)]) &^ (1 << 24)
+		for j := 0; j < toProcess; j++ {
+			if r.sizes[batchStart+j]&(1<<24) != 0 {
+				// Uncompressed blocks don't need any processing up front.
+				r.process(uint64(batchStart+j), curOffset, retChan)
+			} else {
+				if r.goroutines != nil {
+					r.goroutines.Add(1)
+				}
+				go r.process(uint64(batchStart+j), curOffset, retChan)
+			}
+			curOffset += uint64(r.sizes[batchStart+j]) &^ (1 << 24)
 		}
 		// Then consume the results on retChan
-		for j := uint16(0); j < toProcess; j++ {
+		for j := 0; j < toProcess; j++ {
 			res := <-retChan
 			// If there's an error, we don't care about the results.
 			if res.err != nil {
@@ -111,6 +161,7 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 			// If there has been an error previously, we don't care about the results.
 			// We still want to wait for all the goroutines to prevent resources being wasted.
 			if len(errCache) > 0 {
+				r.release(res)
 				continue
 			}
 			// If we don't need the data yet, we cache it and move on
@@ -119,8 +170,8 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 				continue
 			}
 			// If we do need the data, we write it
-			wr, err := w.Write(res.data)
-			wrote += int64(wr)
+			wr, err := r.write(w, res)
+			wrote += wr
 			if err != nil {
 				errCache = append(errCache, err)
 				if len(cache) > 0 {
@@ -128,7 +179,7 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 				}
 				continue
 			}
-			r.retPool.Put(res)
+			r.release(res)
 			curIndex++
 			// Now we recursively try to clear the cache
 			for len(cache) > 0 {
@@ -136,8 +187,8 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 				if !ok {
 					break
 				}
-				wr, err := w.Write(res.data)
-				wrote += int64(wr)
+				wr, err := r.write(w, res)
+				wrote += wr
 				if err != nil {
 					errCache = append(errCache, err)
 					if len(cache) > 0 {
@@ -146,7 +197,7 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 					break
 				}
 				delete(cache, curIndex)
-				r.retPool.Put(res)
+				r.release(res)
 				curIndex++
 			}
 		}
@@ -172,3 +223,199 @@ func (r *FullReader) WriteTo(w io.Writer) (int64, error) {
 	}
 	return wrote, nil
 }
+
+// Returns the uncompressed size of the file.
+func (r *FullReader) Size() int64 {
+	if len(r.sizes) == 0 {
+		return int64(r.finalBlockSize)
+	}
+	size := int64(len(r.sizes)-1) * int64(r.blockSize)
+	if r.frag != nil {
+		return size + int64(r.blockSize) + int64(r.finalBlockSize)
+	}
+	return size + int64(sparseSize(uint64(len(r.sizes)-1), len(r.sizes), false, r.finalBlockSize, r.blockSize))
+}
+
+// Returns the offset of each block, relative to initialOffset.
+// Computed on first use.
+func (r *FullReader) blockOffsets() []int64 {
+	r.offsetsOnce.Do(func() {
+		r.offsets = make([]int64, len(r.sizes))
+		var cur int64
+		for i := range r.sizes {
+			r.offsets[i] = cur
+			cur += int64(r.sizes[i] &^ (1 << 24))
+		}
+	})
+	return r.offsets
+}
+
+// Reads len(p) bytes starting at off. Only the blocks containing the requested data are read.
+// Safe to call concurrently.
+func (r *FullReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := r.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	for n < len(p) && off < size {
+		var copied int
+		copied, err = r.readAtBlock(p[n:], off)
+		n += copied
+		off += int64(copied)
+		if err != nil {
+			return
+		}
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// Reads from the block containing off into p. Only compressed blocks are fully read and decompressed.
+// The last compressed block decompressed is kept so small sequential reads don't decompress the same block repeatedly.
+func (r *FullReader) readAtBlock(p []byte, off int64) (int, error) {
+	index := uint64(off / int64(r.blockSize))
+	blockOff := off % int64(r.blockSize)
+	if index == uint64(len(r.sizes)) {
+		rdr, err := r.frag()
+		if err != nil {
+			return 0, err
+		}
+		if ra, ok := rdr.(io.ReaderAt); ok {
+			// Avoid copying the fragment data for small reads.
+			n, err := ra.ReadAt(p, blockOff)
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	if index < uint64(len(r.sizes)) {
+		size := r.sizes[index] &^ (1 << 24)
+		if size == 0 {
+			sparse := int64(sparseSize(index, len(r.sizes), r.frag != nil, r.finalBlockSize, r.blockSize))
+			n := min(int64(len(p)), sparse-blockOff)
+			clear(p[:n])
+			return int(n), nil
+		} else if size != r.sizes[index] && size <= r.blockSize {
+			n := min(int64(len(p)), int64(size)-blockOff)
+			if n <= 0 {
+				return 0, errors.New("block is smaller than expected. possible corrupted archive")
+			}
+			read, err := r.r.ReadAt(p[:n], r.initialOffset+r.blockOffsets()[index]+blockOff)
+			if read == int(n) {
+				err = nil
+			}
+			return read, err
+		}
+	}
+	r.lastMut.Lock()
+	if r.lastBlock != nil && r.lastBlock.index == index {
+		defer r.lastMut.Unlock()
+		return copyBlock(p, r.lastBlock.data, blockOff)
+	}
+	r.lastMut.Unlock()
+	dat, pooled, err := r.block(index)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyBlock(p, dat, blockOff)
+	if r.noLastBlock {
+		if pooled {
+			putBuf(dat, r.blockSize)
+		}
+		return n, err
+	}
+	r.lastMut.Lock()
+	old := r.lastBlock
+	r.lastBlock = &retValue{data: dat, pooled: pooled, index: index}
+	r.lastMut.Unlock()
+	if old != nil && old.pooled {
+		putBuf(old.data, r.blockSize)
+	}
+	return n, err
+}
+
+func copyBlock(p, dat []byte, blockOff int64) (int, error) {
+	if blockOff >= int64(len(dat)) {
+		return 0, errors.New("block is smaller than expected. possible corrupted archive")
+	}
+	return copy(p, dat[blockOff:]), nil
+}
+
+// Returns the decompressed data of the block at index. If index is just past the last block, returns the fragment data.
+// If pooled is true, the data should be given back with putBuf.
+func (r *FullReader) block(index uint64) (dat []byte, pooled bool, err error) {
+	if index == uint64(len(r.sizes)) {
+		if r.frag == nil {
+			return nil, false, io.EOF
+		}
+		var rdr io.Reader
+		rdr, err = r.frag()
+		if err != nil {
+			return
+		}
+		dat, err = io.ReadAll(rdr)
+		return
+	}
+	return readBlock(r.r, r.d, r.initialOffset+r.blockOffsets()[index], r.sizes[index], sparseSize(index, len(r.sizes), r.frag != nil, r.finalBlockSize, r.blockSize), r.blockSize)
+}
+
+// A section of a file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// Reads all the given ranges, returning the data for each in the same order.
+// Each block needed is only read and decompressed once, no matter how many ranges it's a part of.
+// Ranges that go past the end of the file are truncated.
+func (r *FullReader) ReadRanges(ranges []Range) ([][]byte, error) {
+	size := r.Size()
+	out := make([][]byte, len(ranges))
+	var blocks []uint64
+	for i, rng := range ranges {
+		if rng.Offset < 0 || rng.Length < 0 {
+			return nil, errors.New("negative range offset or length")
+		}
+		end := min(rng.Offset+rng.Length, size)
+		if rng.Offset >= end {
+			out[i] = []byte{}
+			continue
+		}
+		out[i] = make([]byte, end-rng.Offset)
+		for b := rng.Offset / int64(r.blockSize); b <= (end-1)/int64(r.blockSize); b++ {
+			blocks = append(blocks, uint64(b))
+		}
+	}
+	slices.Sort(blocks)
+	blocks = slices.Compact(blocks)
+	for _, index := range blocks {
+		dat, pooled, err := r.block(index)
+		if err != nil {
+			return nil, err
+		}
+		blockStart := int64(index) * int64(r.blockSize)
+		blockEnd := blockStart + int64(len(dat))
+		if blockEnd < min(size, blockStart+int64(r.blockSize)) {
+			if pooled {
+				putBuf(dat, r.blockSize)
+			}
+			return nil, errors.New("block is smaller than expected. possible corrupted archive")
+		}
+		for i, rng := range ranges {
+			start, end := max(rng.Offset, blockStart), min(rng.Offset+int64(len(out[i])), blockEnd)
+			if start < end {
+				copy(out[i][start-rng.Offset:], dat[start-blockStart:end-blockStart])
+			}
+		}
+		if pooled {
+			putBuf(dat, r.blockSize)
+		}
+	}
+	return out, nil
+}
@@ -1,87 +1,153 @@
 package data
 
 import (
-	"encoding/binary"
 	"io"
+	"sync/atomic"
 
 	"github.com/CalebQ42/squashfs/internal/decompress"
 )
 
+// The default number of blocks decompressed ahead of the current one.
+const DefaultReadahead = 1
+
 type Reader struct {
-	r              io.Reader
+	r              io.ReaderAt
 	d              decompress.Decompressor
+	fragInit       FragReaderConstructor
 	frag           io.Reader
 	sizes          []uint32
 	dat            []byte
+	ahead          []chan blockResult
+	goroutines     *atomic.Uint64
+	pooled         bool
+	initialOffset  int64
+	nextOffset     int64
 	curOffset      int
 	curIndex       uint64
+	nextIndex      uint64
 	finalBlockSize uint64
+	readahead      int
 	blockSize      uint32
 }
 
-func NewReader(r io.Reader, d decompress.Decompressor, sizes []uint32, finalBlockSize uint64, blockSize uint32) *Reader {
+type blockResult struct {
+	err    error
+	dat    []byte
+	pooled bool
+}
+
+func NewReader(r io.ReaderAt, initialOffset int64, d decompress.Decompressor, sizes []uint32, finalBlockSize uint64, blockSize uint32) *Reader {
 	return &Reader{
 		r:              r,
 		d:              d,
 		sizes:          sizes,
+		initialOffset:  initialOffset,
+		nextOffset:     initialOffset,
 		finalBlockSize: finalBlockSize,
+		readahead:      DefaultReadahead,
 		blockSize:      blockSize,
 	}
 }
 
-func (r *Reader) AddFrag(fragRdr io.Reader) {
-	r.frag = fragRdr
+// Set the constructor for the reader of the file's fragment data.
+// The constructor is only called once the fragment data is needed.
+func (r *Reader) AddFrag(frag FragReaderConstructor) {
+	r.fragInit = frag
+}
+
+// Set how many blocks are decompressed in the background ahead of the block being read.
+// If blocks is 0, blocks are only decompressed when needed.
+func (r *Reader) SetReadahead(blocks int) {
+	r.readahead = max(blocks, 0)
+}
+
+// Set a counter that's incremented whenever a goroutine is started to decompress a block.
+func (r *Reader) SetGoroutineCounter(c *atomic.Uint64) {
+	r.goroutines = c
+}
+
+// Returns how large the block at index is if it's sparse.
+func (r *Reader) sparseSize(index uint64) uint64 {
+	return sparseSize(index, len(r.sizes), r.fragInit != nil, r.finalBlockSize, r.blockSize)
+}
+
+// Only the final block can be smaller than blockSize, and only if the file doesn't end in a fragment.
+// A finalBlockSize of 0 means the file's size is a multiple of blockSize, so the final block is full.
+func sparseSize(index uint64, blocks int, hasFrag bool, finalBlockSize uint64, blockSize uint32) uint64 {
+	if index == uint64(blocks)-1 && !hasFrag && finalBlockSize != 0 {
+		return finalBlockSize
+	}
+	return uint64(blockSize)
+}
+
+// Start decompressing blocks in the background until n blocks are queued.
+func (r *Reader) queue(n int) {
+	for len(r.ahead) < n && r.nextIndex < uint64(len(r.sizes)) {
+		res := make(chan blockResult, 1)
+		if r.goroutines != nil {
+			r.goroutines.Add(1)
+		}
+		go func(index uint64, offset int64) {
+			dat, pooled, err := readBlock(r.r, r.d, offset, r.sizes[index], r.sparseSize(index), r.blockSize)
+			res <- blockResult{dat: dat, pooled: pooled, err: err}
+		}(r.nextIndex, r.nextOffset)
+		r.ahead = append(r.ahead, res)
+		r.nextOffset += int64(r.sizes[r.nextIndex] &^ (1 << 24))
+		r.nextIndex++
+	}
+}
+
+// Gives the current block's data back to the pool.
+func (r *Reader) release() {
+	if r.pooled {
+		putBuf(r.dat, r.blockSize)
+	}
+	r.dat = nil
+	r.pooled = false
 }
 
 func (r *Reader) advance() error {
+	r.release()
 	r.curOffset = 0
 	defer func() { r.curIndex++ }()
 	var err error
-	if r.curIndex == uint64(len(r.sizes)) && r.frag != nil {
+	if r.curIndex == uint64(len(r.sizes)) && r.fragInit != nil {
+		r.frag, err = r.fragInit()
+		if err != nil {
+			return err
+		}
 		r.dat, err = io.ReadAll(r.frag)
 		return err
 	} else if r.curIndex >= uint64(len(r.sizes)) {
 		return io.EOF
 	}
-	realSize := r.sizes[r.curIndex] &^ (1 << 24)
-	if realSize == 0 {
-		if r.curIndex == uint64(len(r.sizes))-1 && r.frag == nil {
-			r.dat = make([]byte, r.finalBlockSize)
-		} else {
-			r.dat = make([]byte, r.blockSize)
-		}
-		return nil
-	}
-	r.dat = make([]byte, realSize)
-	err = binary.Read(r.r, binary.LittleEndian, &r.dat)
-	if err != nil {
+	if r.readahead == 0 && len(r.ahead) == 0 {
+		r.dat, r.pooled, err = readBlock(r.r, r.d, r.nextOffset, r.sizes[r.curIndex], r.sparseSize(r.curIndex), r.blockSize)
+		r.nextOffset += int64(r.sizes[r.curIndex] &^ (1 << 24))
+		r.nextIndex++
 		return err
 	}
-	if r.sizes[r.curIndex] != realSize {
-		return nil
-	}
-	r.dat, err = r.d.Decompress(r.dat)
-	return err
+	r.queue(1)
+	res := <-r.ahead[0]
+	r.ahead = r.ahead[1:]
+	r.queue(r.readahead)
+	r.dat, r.pooled = res.dat, res.pooled
+	return res.err
 }
 
-func (r *Reader) Read(b []byte) (int, error) {
-	curRead := 0
-	var toRead int
-	for curRead < len(b) {
+func (r *Reader) Read(b []byte) (n int, err error) {
+	for n < len(b) {
 		if r.curOffset >= len(r.dat) {
-			if err := r.advance(); err != nil {
-				return curRead, err
+			if err = r.advance(); err != nil {
+				return
 			}
+			continue
 		}
-		toRead = len(b) - curRead
-		if toRead > len(r.dat)-r.curOffset {
-			toRead = len(r.dat) - r.curOffset
-		}
-		toRead = copy(b[curRead:], r.dat[r.curOffset:r.curOffset+toRead])
-		r.curOffset += toRead
-		curRead += toRead
+		copied := copy(b[n:], r.dat[r.curOffset:])
+		r.curOffset += copied
+		n += copied
 	}
-	return curRead, nil
+	return
 }
 
 func (r *Reader) Close() error {
@@ -92,6 +158,8 @@ func (r *Reader) Close() error {
 			}
 		}
 	}
-	r.dat = nil
+	// Any blocks being decompressed in the background are discarded once finished.
+	r.ahead = nil
+	r.release()
 	return nil
 }
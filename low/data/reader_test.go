@@ -0,0 +1,283 @@
+package data
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/CalebQ42/squashfs/internal/decompress"
+)
+
+const (
+	testBlockSize = 16
+	uncompressed  = 1 << 24
+)
+
+type readerTest struct {
+	name   string
+	sizes  []uint32
+	stored []byte // The data as stored in the archive. Sparse blocks aren't stored.
+	want   []byte
+	frag   []byte
+	final  uint64
+}
+
+func seq(start, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(start + i + 1)
+	}
+	return out
+}
+
+func join(b ...[]byte) []byte {
+	return bytes.Join(b, nil)
+}
+
+var readerTests = []readerTest{
+	{
+		name:   "full blocks",
+		sizes:  []uint32{16 | uncompressed, 16 | uncompressed, 16 | uncompressed},
+		stored: seq(0, 48),
+		want:   seq(0, 48),
+	},
+	{
+		name:   "partial final block",
+		sizes:  []uint32{16 | uncompressed, 7 | uncompressed},
+		stored: seq(0, 23),
+		want:   seq(0, 23),
+		final:  7,
+	},
+	{
+		name:   "sparse middle block with fragment",
+		sizes:  []uint32{16 | uncompressed, 0, 16 | uncompressed},
+		stored: join(seq(0, 16), seq(32, 16)),
+		want:   join(seq(0, 16), make([]byte, 16), seq(32, 16), seq(100, 5)),
+		frag:   seq(100, 5),
+		final:  5,
+	},
+	{
+		name:   "sparse full final block",
+		sizes:  []uint32{16 | uncompressed, 0},
+		stored: seq(0, 16),
+		want:   join(seq(0, 16), make([]byte, 16)),
+	},
+	{
+		name:   "sparse partial final block",
+		sizes:  []uint32{16 | uncompressed, 0},
+		stored: seq(0, 16),
+		want:   join(seq(0, 16), make([]byte, 7)),
+		final:  7,
+	},
+	{
+		name:  "fragment only",
+		want:  seq(50, 9),
+		frag:  seq(50, 9),
+		final: 9,
+	},
+	{
+		name: "empty",
+	},
+}
+
+func (test readerTest) reader(readahead int) *Reader {
+	// Offset the data to make sure the initial offset is respected.
+	stored := join(seq(200, 3), test.stored)
+	rdr := NewReader(bytes.NewReader(stored), 3, nil, test.sizes, test.final, testBlockSize)
+	rdr.SetReadahead(readahead)
+	if test.frag != nil {
+		rdr.AddFrag(func() (io.Reader, error) {
+			return bytes.NewReader(test.frag), nil
+		})
+	}
+	return rdr
+}
+
+func TestReader(t *testing.T) {
+	for _, test := range readerTests {
+		for _, readahead := range []int{0, 1, 3} {
+			if err := iotest.TestReader(test.reader(readahead), test.want); err != nil {
+				t.Errorf("%s (readahead %d): %v", test.name, readahead, err)
+			}
+			// Read in chunks that don't line up with block boundaries.
+			for _, chunk := range []int{1, 5, testBlockSize - 1, testBlockSize, testBlockSize + 1, 100} {
+				rdr := test.reader(readahead)
+				var got []byte
+				buf := make([]byte, chunk)
+				for {
+					n, err := rdr.Read(buf)
+					got = append(got, buf[:n]...)
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatalf("%s (chunk %d): %v", test.name, chunk, err)
+					}
+				}
+				if !bytes.Equal(got, test.want) {
+					t.Errorf("%s (chunk %d): got %v, want %v", test.name, chunk, got, test.want)
+				}
+			}
+		}
+	}
+}
+
+func TestFullReader(t *testing.T) {
+	for _, test := range readerTests {
+		for _, limit := range []uint16{0, 1, 2, 100} {
+			stored := join(seq(200, 3), test.stored)
+			rdr := NewFullReader(bytes.NewReader(stored), 3, nil, test.sizes, test.final, testBlockSize)
+			rdr.SetGoroutineLimit(limit)
+			if test.frag != nil {
+				rdr.AddFrag(func() (io.Reader, error) {
+					return bytes.NewReader(test.frag), nil
+				})
+			}
+			var buf bytes.Buffer
+			n, err := rdr.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("%s (limit %d): %v", test.name, limit, err)
+			}
+			if n != int64(len(test.want)) || !bytes.Equal(buf.Bytes(), test.want) {
+				t.Errorf("%s (limit %d): got %v, want %v", test.name, limit, buf.Bytes(), test.want)
+			}
+		}
+	}
+}
+
+func TestFullReaderReadAt(t *testing.T) {
+	for _, test := range readerTests {
+		stored := join(seq(200, 3), test.stored)
+		rdr := NewFullReader(bytes.NewReader(stored), 3, nil, test.sizes, test.final, testBlockSize)
+		if test.frag != nil {
+			rdr.AddFrag(func() (io.Reader, error) {
+				return bytes.NewReader(test.frag), nil
+			})
+		}
+		if rdr.Size() != int64(len(test.want)) {
+			t.Fatalf("%s: size is %d, want %d", test.name, rdr.Size(), len(test.want))
+		}
+		// iotest.TestReader also tests ReadAt when available.
+		if err := iotest.TestReader(io.NewSectionReader(rdr, 0, rdr.Size()), test.want); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		// Reads that run past the end of the data.
+		for off := range len(test.want) {
+			buf := make([]byte, testBlockSize*2)
+			n, err := rdr.ReadAt(buf, int64(off))
+			if err != io.EOF && n < len(buf) {
+				t.Errorf("%s (offset %d): short read without io.EOF: %v", test.name, off, err)
+			}
+			if !bytes.Equal(buf[:n], test.want[off:min(off+len(buf), len(test.want))]) {
+				t.Errorf("%s (offset %d): got %v, want %v", test.name, off, buf[:n], test.want[off:])
+			}
+		}
+	}
+}
+
+func TestFullReaderReadRanges(t *testing.T) {
+	for _, test := range readerTests {
+		stored := join(seq(200, 3), test.stored)
+		rdr := NewFullReader(bytes.NewReader(stored), 3, nil, test.sizes, test.final, testBlockSize)
+		if test.frag != nil {
+			rdr.AddFrag(func() (io.Reader, error) {
+				return bytes.NewReader(test.frag), nil
+			})
+		}
+		var ranges []Range
+		for off := range len(test.want) + 2 {
+			for _, length := range []int64{0, 1, 7, testBlockSize, testBlockSize + 3, 100} {
+				ranges = append(ranges, Range{Offset: int64(off), Length: length})
+			}
+		}
+		got, err := rdr.ReadRanges(ranges)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, rng := range ranges {
+			start := min(int(rng.Offset), len(test.want))
+			want := test.want[start:min(start+int(rng.Length), len(test.want))]
+			if !bytes.Equal(got[i], want) {
+				t.Fatalf("%s (%+v): got %v, want %v", test.name, rng, got[i], want)
+			}
+		}
+	}
+}
+
+// 8MiB of compressible data split into zlib compressed blocks.
+func benchmarkBlocks(b *testing.B, blockSize int) (stored []byte, sizes []uint32, want int) {
+	b.Helper()
+	for i := 0; i < 8*1024*1024/blockSize; i++ {
+		blk := bytes.Repeat([]byte{byte(i), byte(i * 3), 'a', 'b'}, blockSize/4)
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(blk)
+		w.Close()
+		stored = append(stored, buf.Bytes()...)
+		sizes = append(sizes, uint32(buf.Len()))
+		want += len(blk)
+	}
+	return
+}
+
+func BenchmarkFullReader(b *testing.B) {
+	stored, sizes, want := benchmarkBlocks(b, 128*1024)
+	b.SetBytes(int64(want))
+	b.ReportAllocs()
+	for range b.N {
+		rdr := NewFullReader(bytes.NewReader(stored), 0, decompress.Zlib{}, sizes, 0, 128*1024)
+		n, err := rdr.WriteTo(io.Discard)
+		if err != nil || n != int64(want) {
+			b.Fatal(n, err)
+		}
+	}
+}
+
+func BenchmarkFullReaderUncompressed(b *testing.B) {
+	const blockSize = 128 * 1024
+	stored := bytes.Repeat([]byte("uncompressed"), 64*blockSize/12)
+	sizes := make([]uint32, len(stored)/blockSize)
+	for i := range sizes {
+		sizes[i] = blockSize | uncompressed
+	}
+	stored = stored[:len(sizes)*blockSize]
+	b.SetBytes(int64(len(stored)))
+	b.ReportAllocs()
+	for range b.N {
+		rdr := NewFullReader(bytes.NewReader(stored), 0, nil, sizes, 0, blockSize)
+		n, err := rdr.WriteTo(io.Discard)
+		if err != nil || n != int64(len(stored)) {
+			b.Fatal(n, err)
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	stored, sizes, want := benchmarkBlocks(b, 128*1024)
+	b.SetBytes(int64(want))
+	b.ReportAllocs()
+	for range b.N {
+		rdr := NewReader(bytes.NewReader(stored), 0, decompress.Zlib{}, sizes, 0, 128*1024)
+		n, err := io.Copy(io.Discard, rdr)
+		if err != nil || n != int64(want) {
+			b.Fatal(n, err)
+		}
+	}
+}
+
+// Small sequential reads with 1MiB blocks, like a parser reading headers.
+func BenchmarkReadAt1MiB(b *testing.B) {
+	const blockSize = 1024 * 1024
+	stored, sizes, want := benchmarkBlocks(b, blockSize)
+	rdr := NewFullReader(bytes.NewReader(stored), 0, decompress.Zlib{}, sizes, 0, blockSize)
+	buf := make([]byte, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		off := int64(i*len(buf)) % int64(want-len(buf))
+		if _, err := rdr.ReadAt(buf, off); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package squashfslow
 
 import (
+	"bytes"
 	"errors"
 	"io/fs"
 	"path/filepath"
@@ -8,9 +9,7 @@ import (
 	"strings"
 
 	"github.com/CalebQ42/squashfs/internal/metadata"
-	"github.com/CalebQ42/squashfs/internal/toreader"
 	"github.com/CalebQ42/squashfs/low/directory"
-	"github.com/CalebQ42/squashfs/low/inode"
 )
 
 type Directory struct {
@@ -18,40 +17,13 @@ type Directory struct {
 	Entries []directory.Entry
 }
 
-func (r *Reader) directoryFromRef(ref uint64, name string) (Directory, error) {
+func (r *Reader) directoryFromRef(ref MetaRef, name string) (Directory, error) {
 	i, err := r.InodeFromRef(ref)
 	if err != nil {
 		return Directory{}, err
 	}
-	var blockStart uint32
-	var size uint32
-	var offset uint16
-	switch i.Type {
-	case inode.Dir:
-		blockStart = i.Data.(inode.Directory).BlockStart
-		size = uint32(i.Data.(inode.Directory).Size)
-		offset = i.Data.(inode.Directory).Offset
-	case inode.EDir:
-		blockStart = i.Data.(inode.EDirectory).BlockStart
-		size = i.Data.(inode.EDirectory).Size
-		offset = i.Data.(inode.EDirectory).Offset
-	default:
-		return Directory{}, errors.New("not a directory")
-	}
-	dirRdr := metadata.NewReader(toreader.NewReader(r.r, int64(r.Superblock.DirTableStart)+int64(blockStart)), r.d)
-	defer dirRdr.Close()
-	_, err = dirRdr.Read(make([]byte, offset))
-	if err != nil {
-		return Directory{}, err
-	}
-	entries, err := directory.ReadDirectory(dirRdr, size)
-	if err != nil {
-		return Directory{}, err
-	}
-	return Directory{
-		FileBase: r.BaseFromInode(i, name),
-		Entries:  entries,
-	}, nil
+	b := r.BaseFromInode(i, name)
+	return b.ToDir(r)
 }
 
 func (d *Directory) Open(r *Reader, path string) (FileBase, error) {
@@ -70,14 +42,55 @@ func (d *Directory) Open(r *Reader, path string) (FileBase, error) {
 	if err != nil {
 		return FileBase{}, err
 	}
-	if len(split) == 1 {
-		return b, nil
-	} else if !b.IsDir() {
-		return FileBase{}, fs.ErrNotExist
+	for _, name := range split[1:] {
+		if !b.IsDir() {
+			return FileBase{}, fs.ErrNotExist
+		}
+		e, err := b.Lookup(r, name)
+		if err != nil {
+			return FileBase{}, err
+		}
+		b, err = r.BaseFromEntry(e)
+		if err != nil {
+			return FileBase{}, err
+		}
 	}
-	dir, err := b.ToDir(r)
-	if err != nil {
-		return FileBase{}, err
+	return b, nil
+}
+
+// Calls fn with every entry in the directory table, in the order they're stored, along with the header the entry belongs to
+// and where the header is in the directory table (its metadata block is relative to the directory table's start, like a
+// directory inode's BlockStart). The table is read on its own, so unreadable inodes don't hide any entries. Listings are
+// stored back to back, so a directory's listing starts at the header its inode points to. Empty directories have no headers.
+// If fn returns an error, iteration stops and the error is returned, unless it's fs.SkipAll.
+// Uses the preloaded directory table if available, otherwise the whole table is decompressed first.
+func (r *Reader) DirectoryTable(fn func(header MetaRef, h directory.Header, e directory.Entry) error) error {
+	_, table := r.preloaded()
+	if table == nil {
+		end, err := r.dirTableEnd()
+		if err != nil {
+			return err
+		}
+		table, err = metadata.ReadTable(r.r, int64(r.Superblock.DirTableStart), end, r.d)
+		if err != nil {
+			return err
+		}
+	}
+	rdr := bytes.NewReader(table.Data())
+	for rdr.Len() > 0 {
+		block, offset := table.Location(table.Size() - rdr.Len())
+		h, ents, err := directory.ReadHeader(rdr)
+		if err != nil {
+			return errors.Join(errors.New("failed to read directory header at "+NewMetaRef(block, offset).String()), err)
+		}
+		for _, e := range ents {
+			err = fn(NewMetaRef(block, offset), h, e)
+			if err == fs.SkipAll {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
 	}
-	return dir.Open(r, strings.Join(split[1:], "/"))
+	return nil
 }
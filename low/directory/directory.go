@@ -3,12 +3,18 @@ package directory
 import (
 	"encoding/binary"
 	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 )
 
-type header struct {
+// A header in a directory listing. Each header is followed by Count+1 entries whose inodes are all in the same metadata block.
+type Header struct {
 	Count      uint32
-	BlockStart uint32
-	Num        uint32
+	BlockStart uint32 // Location of the entries' inodes' metadata block, relative to the inode table's start.
+	Num        uint32 // Entries' inode numbers are stored as an offset from Num.
 }
 
 type decEntry struct {
@@ -27,36 +33,134 @@ type Entry struct {
 	Num        uint32
 }
 
+// Reads a directory's entries. Entries are always returned sorted by name, even if they aren't sorted in the archive.
 func ReadDirectory(r io.Reader, size uint32) (out []Entry, err error) {
+	defer func() {
+		if !slices.IsSortedFunc(out, compareEntries) {
+			slices.SortFunc(out, compareEntries)
+		}
+	}()
+	err = readEntries(r, size, func(_ Header, e Entry) bool {
+		out = append(out, e)
+		return true
+	})
+	return
+}
+
+// Reads entries until the one with the given name is found. Assumes entries are sorted in the archive, as mksquashfs does,
+// so it stops as soon as it passes where name would be.
+// r can be positioned at any header in the listing (such as from a directory index) as long as size is adjusted accordingly.
+// Returns fs.ErrNotExist if it's not found.
+func FindEntry(r io.Reader, size uint32, name string) (out Entry, err error) {
+	found := false
+	err = readEntries(r, size, func(_ Header, e Entry) bool {
+		cmp := strings.Compare(e.Name, name)
+		if cmp == 0 {
+			out, found = e, true
+		}
+		return cmp < 0
+	})
+	if err == nil && !found {
+		err = fs.ErrNotExist
+	}
+	return
+}
+
+// Calls fn for every entry in the directory listing, in the order they're stored, along with the header the entry belongs to.
+// If fn returns an error, reading stops and the error is returned.
+func ReadListing(r io.Reader, size uint32, fn func(h Header, e Entry) error) (err error) {
+	readErr := readEntries(r, size, func(h Header, e Entry) bool {
+		err = fn(h, e)
+		return err == nil
+	})
+	if err == nil {
+		err = readErr
+	}
+	return
+}
+
+// Calls fn for every entry in the directory listing. Stops early if fn returns false.
+func readEntries(r io.Reader, size uint32, fn func(Header, Entry) bool) (err error) {
+	// A directory's size includes 3 bytes that aren't stored. An empty directory's size is 3.
+	if size <= 3 {
+		return nil
+	}
 	size -= 3
 	var curRead uint32
-	var h header
-	var de decEntry
+	var h Header
 	for curRead < size {
-		err = binary.Read(r, binary.LittleEndian, &h)
+		h, err = readHeader(r)
 		if err != nil {
 			return
 		}
 		curRead += 12
 		for i := uint32(0); i < h.Count+1 && curRead < size; i++ {
-			err = binary.Read(r, binary.LittleEndian, &de)
+			var e Entry
+			var n uint32
+			e, n, err = readEntry(r, h)
 			if err != nil {
 				return
 			}
-			nameTmp := make([]byte, de.NameSize+1)
-			err = binary.Read(r, binary.LittleEndian, &nameTmp)
-			if err != nil {
+			curRead += n
+			if !fn(h, e) {
 				return
 			}
-			curRead += 8 + uint32(de.NameSize) + 1
-			out = append(out, Entry{
-				BlockStart: h.BlockStart,
-				Offset:     de.Offset,
-				Name:       string(nameTmp),
-				InodeType:  de.InodeType,
-				Num:        h.Num + uint32(de.NumOffset),
-			})
 		}
 	}
 	return
 }
+
+// Reads a header and all of its entries. Unlike a directory's listing, the directory table can be read one header at a
+// time, since each header is followed by exactly Count+1 entries.
+func ReadHeader(r io.Reader) (h Header, entries []Entry, err error) {
+	h, err = readHeader(r)
+	if err != nil {
+		return
+	}
+	entries = make([]Entry, 0, h.Count+1)
+	for range h.Count + 1 {
+		var e Entry
+		e, _, err = readEntry(r, h)
+		if err != nil {
+			return
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+func readHeader(r io.Reader) (h Header, err error) {
+	err = binary.Read(r, binary.LittleEndian, &h)
+	if err == nil && h.Count >= 256 {
+		err = corrupt.Error("directory header has more than 256 entries")
+	}
+	return
+}
+
+// Reads the entry that follows h and returns how many bytes it took.
+func readEntry(r io.Reader, h Header) (Entry, uint32, error) {
+	var de decEntry
+	err := binary.Read(r, binary.LittleEndian, &de)
+	if err != nil {
+		return Entry{}, 0, err
+	}
+	if de.NameSize >= 256 {
+		return Entry{}, 0, corrupt.Error("directory entry name is longer than 256 bytes")
+	}
+	name := make([]byte, de.NameSize+1)
+	_, err = io.ReadFull(r, name)
+	if err != nil {
+		return Entry{}, 0, err
+	}
+	return Entry{
+		BlockStart: h.BlockStart,
+		Offset:     de.Offset,
+		Name:       string(name),
+		InodeType:  de.InodeType,
+		Num:        h.Num + uint32(de.NumOffset),
+	}, 8 + uint32(de.NameSize) + 1, nil
+}
+
+func compareEntries(a, b Entry) int {
+	return strings.Compare(a.Name, b.Name)
+}
@@ -3,9 +3,12 @@ package squashfslow
 import (
 	"errors"
 	"io"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
 
-	"github.com/CalebQ42/squashfs/internal/metadata"
-	"github.com/CalebQ42/squashfs/internal/toreader"
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 	"github.com/CalebQ42/squashfs/low/data"
 	"github.com/CalebQ42/squashfs/low/directory"
 	"github.com/CalebQ42/squashfs/low/inode"
@@ -28,7 +31,7 @@ func (r *Reader) BaseFromEntry(e directory.Entry) (FileBase, error) {
 	return FileBase{Inode: in, Name: e.Name}, nil
 }
 
-func (r *Reader) BaseFromRef(ref uint64, name string) (FileBase, error) {
+func (r *Reader) BaseFromRef(ref MetaRef, name string) (FileBase, error) {
 	in, err := r.InodeFromRef(ref)
 	if err != nil {
 		return FileBase{}, err
@@ -44,29 +47,34 @@ func (b *FileBase) Gid(r *Reader) (uint32, error) {
 	return r.Id(b.Inode.GidInd)
 }
 
+// Returns the file's xattrs. If it doesn't have any, returns nil.
+func (b *FileBase) Xattrs(r *Reader) ([]Xattr, error) {
+	return r.Xattrs(b.Inode.XattrInd())
+}
+
 func (b *FileBase) IsDir() bool {
 	return b.Inode.Type == inode.Dir || b.Inode.Type == inode.EDir
 }
 
-func (b *FileBase) ToDir(r *Reader) (Directory, error) {
-	var blockStart uint32
-	var size uint32
-	var offset uint16
+// Returns where the directory's listing is in the directory table and its size.
+func (b *FileBase) dirLocation() (blockStart uint32, size uint32, offset uint16, err error) {
 	switch b.Inode.Type {
 	case inode.Dir:
-		blockStart = b.Inode.Data.(inode.Directory).BlockStart
-		size = uint32(b.Inode.Data.(inode.Directory).Size)
-		offset = b.Inode.Data.(inode.Directory).Offset
+		d := b.Inode.Data.(inode.Directory)
+		return d.BlockStart, uint32(d.Size), d.Offset, nil
 	case inode.EDir:
-		blockStart = b.Inode.Data.(inode.EDirectory).BlockStart
-		size = b.Inode.Data.(inode.EDirectory).Size
-		offset = b.Inode.Data.(inode.EDirectory).Offset
-	default:
-		return Directory{}, errors.New("not a directory")
-	}
-	dirRdr := metadata.NewReader(toreader.NewReader(r.r, int64(r.Superblock.DirTableStart)+int64(blockStart)), r.d)
-	defer dirRdr.Close()
-	_, err := dirRdr.Read(make([]byte, offset))
+		d := b.Inode.Data.(inode.EDirectory)
+		return d.BlockStart, d.Size, d.Offset, nil
+	}
+	return 0, 0, 0, errors.New("not a directory")
+}
+
+func (b *FileBase) ToDir(r *Reader) (Directory, error) {
+	blockStart, size, offset, err := b.dirLocation()
+	if err != nil {
+		return Directory{}, err
+	}
+	dirRdr, err := r.dirReader(blockStart, offset)
 	if err != nil {
 		return Directory{}, err
 	}
@@ -80,124 +88,274 @@ func (b *FileBase) ToDir(r *Reader) (Directory, error) {
 	}, nil
 }
 
-func (b *FileBase) IsRegular() bool {
-	return b.Inode.Type == inode.Fil || b.Inode.Type == inode.EFil
+// Returns whether the directory has an index that Lookup can use.
+func (b *FileBase) HasDirIndex() bool {
+	return b.Inode.Type == inode.EDir && len(b.Inode.Data.(inode.EDirectory).Indexes) > 0
 }
 
-func (b *FileBase) GetRegFileReaders(r *Reader) (*data.Reader, *data.FullReader, error) {
-	if !b.IsRegular() {
-		return nil, nil, errors.New("not a regular file")
-	}
-	var blockStart uint64
-	var fragIndex uint32
-	var fragOffset uint32
-	var fragSize uint64
-	var sizes []uint32
-	if b.Inode.Type == inode.Fil {
-		blockStart = uint64(b.Inode.Data.(inode.File).BlockStart)
-		fragIndex = b.Inode.Data.(inode.File).FragInd
-		fragOffset = b.Inode.Data.(inode.File).FragOffset
-		sizes = b.Inode.Data.(inode.File).BlockSizes
-		fragSize = uint64(b.Inode.Data.(inode.File).Size % r.Superblock.BlockSize)
-	} else {
-		blockStart = b.Inode.Data.(inode.EFile).BlockStart
-		fragIndex = b.Inode.Data.(inode.EFile).FragInd
-		fragOffset = b.Inode.Data.(inode.EFile).FragOffset
-		sizes = b.Inode.Data.(inode.EFile).BlockSizes
-		fragSize = b.Inode.Data.(inode.EFile).Size % uint64(r.Superblock.BlockSize)
-	}
-	frag := func() (io.Reader, error) {
-		ent, err := r.fragEntry(fragIndex)
+// Returns the directory's entry with the given name, or fs.ErrNotExist.
+// If the directory has an index (large directories use extended inodes with an index), only the metadata block
+// that could contain name is read instead of the whole listing.
+func (b *FileBase) Lookup(r *Reader, name string) (directory.Entry, error) {
+	if !b.HasDirIndex() {
+		d, err := b.ToDir(r)
 		if err != nil {
-			return nil, err
+			return directory.Entry{}, err
+		}
+		i, found := slices.BinarySearchFunc(d.Entries, name, func(e directory.Entry, name string) int {
+			return strings.Compare(e.Name, name)
+		})
+		if !found {
+			return directory.Entry{}, fs.ErrNotExist
 		}
-		frag := data.NewReader(toreader.NewReader(r.r, int64(ent.Start)), r.d, []uint32{ent.Size}, uint64(r.Superblock.BlockSize), r.Superblock.BlockSize)
-		frag.Read(make([]byte, fragOffset))
-		return io.LimitReader(frag, int64(fragSize)), nil
+		return d.Entries[i], nil
 	}
-	outRdr := data.NewReader(toreader.NewReader(r.r, int64(blockStart)), r.d, sizes, fragSize, r.Superblock.BlockSize)
-	if fragIndex != 0xffffffff {
-		f, err := frag()
-		if err != nil {
-			return nil, nil, err
+	blockStart, size, offset, _ := b.dirLocation()
+	indexes := b.Inode.Data.(inode.EDirectory).Indexes
+	// Each index holds the name of the first entry in its metadata block. Use the last one that's not after name.
+	i := sort.Search(len(indexes), func(i int) bool {
+		return string(indexes[i].Name) > name
+	}) - 1
+	if i >= 0 {
+		if indexes[i].Ind >= size {
+			return directory.Entry{}, errors.New("directory index out of bounds. possible corrupted archive")
 		}
-		outRdr.AddFrag(f)
+		blockStart = indexes[i].Start
+		offset = uint16((uint32(offset) + indexes[i].Ind) % 8192)
+		size -= indexes[i].Ind
+	}
+	dirRdr, err := r.dirReader(blockStart, offset)
+	if err != nil {
+		return directory.Entry{}, err
+	}
+	return directory.FindEntry(dirRdr, size, name)
+}
+
+func (b *FileBase) IsRegular() bool {
+	return b.Inode.Type == inode.Fil || b.Inode.Type == inode.EFil
+}
+
+// The location of a regular file's data.
+type regFileData struct {
+	sizes      []uint32
+	blockStart uint64
+	fragSize   uint64
+	fragIndex  uint32
+	fragOffset uint32
+}
+
+func (b *FileBase) regFileData(r *Reader) (regFileData, error) {
+	switch b.Inode.Type {
+	case inode.Fil:
+		f := b.Inode.Data.(inode.File)
+		return regFileData{
+			sizes:      f.BlockSizes,
+			blockStart: uint64(f.BlockStart),
+			fragSize:   uint64(f.Size % r.Superblock.BlockSize),
+			fragIndex:  f.FragInd,
+			fragOffset: f.FragOffset,
+		}, nil
+	case inode.EFil:
+		f := b.Inode.Data.(inode.EFile)
+		return regFileData{
+			sizes:      f.BlockSizes,
+			blockStart: f.BlockStart,
+			fragSize:   f.Size % uint64(r.Superblock.BlockSize),
+			fragIndex:  f.FragInd,
+			fragOffset: f.FragOffset,
+		}, nil
+	}
+	return regFileData{}, errors.New("not a regular file")
+}
+
+func (d regFileData) hasFrag() bool {
+	return d.fragIndex != 0xffffffff
+}
+
+// Where the end of a regular file is stored in a fragment block.
+type FragmentPlacement struct {
+	Index  uint32 // The fragment block's index in the fragment table.
+	Offset uint32 // Offset of the file's data in the decompressed fragment block.
+	Size   uint32 // Size of the file's data in the fragment block.
+}
+
+// Returns where the end of the file is stored in a fragment block. If the file isn't a regular file, or its end isn't
+// stored in a fragment, returns false.
+func (b *FileBase) Fragment(r *Reader) (FragmentPlacement, bool) {
+	d, err := b.regFileData(r)
+	if err != nil || !d.hasFrag() {
+		return FragmentPlacement{}, false
+	}
+	return FragmentPlacement{
+		Index:  d.fragIndex,
+		Offset: d.fragOffset,
+		Size:   uint32(d.fragSize),
+	}, true
+}
+
+// Returns how many bytes the file's data takes up in the archive. Data blocks are counted at their stored (possibly compressed)
+// size, and sparse blocks aren't counted. Since a fragment block is shared, the file's end is counted as its share of the
+// fragment block's stored size, proportional to how much of the decompressed block it takes up.
+// Returns 0 for anything but regular files.
+func (b *FileBase) StoredSize(r *Reader) (uint64, error) {
+	d, err := b.regFileData(r)
+	if err != nil {
+		return 0, nil
 	}
-	outFull := data.NewFullReader(r.r, int64(blockStart), r.d, sizes, fragSize, r.Superblock.BlockSize)
-	if fragIndex != 0xffffffff {
-		outFull.AddFrag(frag)
+	var out uint64
+	for _, s := range d.sizes {
+		out += uint64(s &^ (1 << 24))
+	}
+	if !d.hasFrag() || d.fragSize == 0 {
+		return out, nil
+	}
+	ent, err := r.Fragment(d.fragIndex)
+	if err != nil {
+		return 0, err
+	}
+	blk, err := r.fragBlock(d.fragIndex)
+	if err != nil {
+		return 0, err
+	}
+	if len(blk) == 0 {
+		return out, nil
+	}
+	return out + d.fragSize*uint64(ent.StoredSize())/uint64(len(blk)), nil
+}
+
+// How a regular file's data is stored.
+type Storage uint8
+
+const (
+	StorageNone              Storage = iota // Empty files and anything but regular files.
+	StorageBlocks                           // Only in data blocks.
+	StorageBlocksAndFragment                // In data blocks, with its end in a fragment block.
+	StorageFragment                         // Entirely in a fragment block.
+)
+
+func (s Storage) String() string {
+	switch s {
+	case StorageNone:
+		return "none"
+	case StorageBlocks:
+		return "blocks"
+	case StorageBlocksAndFragment:
+		return "blocks and fragment"
+	case StorageFragment:
+		return "fragment"
+	}
+	return "unknown"
+}
+
+// Returns how the file's data is stored.
+func (b *FileBase) Storage(r *Reader) Storage {
+	d, err := b.regFileData(r)
+	switch {
+	case err != nil:
+		return StorageNone
+	case d.hasFrag() && len(d.sizes) > 0:
+		return StorageBlocksAndFragment
+	case d.hasFrag():
+		return StorageFragment
+	case len(d.sizes) > 0:
+		return StorageBlocks
+	}
+	return StorageNone
+}
+
+// A data block as stored in the archive, before decompression.
+type RawBlock struct {
+	Data       []byte // nil for sparse blocks.
+	Compressed bool
+	Size       uint32 // The block's decompressed size.
+}
+
+// Returns the number of data blocks the file is stored in, not including its fragment. Returns 0 for anything but regular files.
+func (b *FileBase) BlockCount(r *Reader) int {
+	d, _ := b.regFileData(r)
+	return len(d.sizes)
+}
+
+// Returns the file's data blocks from start up to (not including) end as stored in the archive, without decompressing them.
+func (b *FileBase) RawBlocks(r *Reader, start, end int) ([]RawBlock, error) {
+	d, err := b.regFileData(r)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || end > len(d.sizes) || start > end {
+		return nil, errors.New("block range out of bounds")
+	}
+	offset := d.blockStart
+	for _, s := range d.sizes[:start] {
+		offset += uint64(s &^ (1 << 24))
+	}
+	out := make([]RawBlock, 0, end-start)
+	for i := start; i < end; i++ {
+		blk := RawBlock{Size: r.Superblock.BlockSize}
+		if i == len(d.sizes)-1 && !d.hasFrag() && d.fragSize > 0 {
+			blk.Size = uint32(d.fragSize)
+		}
+		stored := d.sizes[i] &^ (1 << 24)
+		if stored > r.Superblock.BlockSize {
+			return nil, corrupt.Error("data block is larger than the block size")
+		}
+		if stored > 0 {
+			blk.Compressed = d.sizes[i]&(1<<24) == 0
+			blk.Data = make([]byte, stored)
+			n, err := r.r.ReadAt(blk.Data, int64(offset))
+			if err != nil && (err != io.EOF || n != len(blk.Data)) {
+				return nil, err
+			}
+		}
+		offset += uint64(stored)
+		out = append(out, blk)
+	}
+	return out, nil
+}
+
+func (b *FileBase) GetRegFileReaders(r *Reader) (*data.Reader, *data.FullReader, error) {
+	outRdr, err := b.GetReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	outFull, err := b.GetFullReader(r)
+	if err != nil {
+		return nil, nil, err
 	}
 	return outRdr, outFull, nil
 }
 
 func (b *FileBase) GetFullReader(r *Reader) (*data.FullReader, error) {
-	if !b.IsRegular() {
-		return nil, errors.New("not a regular file")
-	}
-	var blockStart uint64
-	var fragIndex uint32
-	var fragOffset uint32
-	var fragSize uint64
-	var sizes []uint32
-	if b.Inode.Type == inode.Fil {
-		blockStart = uint64(b.Inode.Data.(inode.File).BlockStart)
-		fragIndex = b.Inode.Data.(inode.File).FragInd
-		fragOffset = b.Inode.Data.(inode.File).FragOffset
-		sizes = b.Inode.Data.(inode.File).BlockSizes
-		fragSize = uint64(b.Inode.Data.(inode.File).Size % r.Superblock.BlockSize)
-	} else {
-		blockStart = b.Inode.Data.(inode.EFile).BlockStart
-		fragIndex = b.Inode.Data.(inode.EFile).FragInd
-		fragOffset = b.Inode.Data.(inode.EFile).FragOffset
-		sizes = b.Inode.Data.(inode.EFile).BlockSizes
-		fragSize = b.Inode.Data.(inode.EFile).Size % uint64(r.Superblock.BlockSize)
-	}
-	outFull := data.NewFullReader(r.r, int64(blockStart), r.d, sizes, fragSize, r.Superblock.BlockSize)
-	if fragIndex != 0xffffffff {
+	d, err := b.regFileData(r)
+	if err != nil {
+		return nil, err
+	}
+	outFull := data.NewFullReader(r.r, int64(d.blockStart), r.d, d.sizes, d.fragSize, r.Superblock.BlockSize)
+	outFull.SetGoroutineLimit(r.routines)
+	outFull.SetGoroutineCounter(&r.stats.goroutines)
+	if r.lowMemory {
+		outFull.DisableBlockCache()
+	}
+	if d.hasFrag() {
 		outFull.AddFrag(func() (io.Reader, error) {
-			ent, err := r.fragEntry(fragIndex)
-			if err != nil {
-				return nil, err
-			}
-			frag := data.NewReader(toreader.NewReader(r.r, int64(ent.Start)), r.d, []uint32{ent.Size}, uint64(r.Superblock.BlockSize), r.Superblock.BlockSize)
-			frag.Read(make([]byte, fragOffset))
-			return io.LimitReader(frag, int64(fragSize)), nil
+			return r.fragReader(d.fragIndex, d.fragOffset, d.fragSize)
 		})
 	}
 	return outFull, nil
 }
 
 func (b *FileBase) GetReader(r *Reader) (*data.Reader, error) {
-	if !b.IsRegular() {
-		return nil, errors.New("not a regular file")
-	}
-	var blockStart uint64
-	var fragIndex uint32
-	var fragOffset uint32
-	var fragSize uint64
-	var sizes []uint32
-	if b.Inode.Type == inode.Fil {
-		blockStart = uint64(b.Inode.Data.(inode.File).BlockStart)
-		fragIndex = b.Inode.Data.(inode.File).FragInd
-		fragOffset = b.Inode.Data.(inode.File).FragOffset
-		sizes = b.Inode.Data.(inode.File).BlockSizes
-		fragSize = uint64(b.Inode.Data.(inode.File).Size % r.Superblock.BlockSize)
-	} else {
-		blockStart = b.Inode.Data.(inode.EFile).BlockStart
-		fragIndex = b.Inode.Data.(inode.EFile).FragInd
-		fragOffset = b.Inode.Data.(inode.EFile).FragOffset
-		sizes = b.Inode.Data.(inode.EFile).BlockSizes
-		fragSize = b.Inode.Data.(inode.EFile).Size % uint64(r.Superblock.BlockSize)
-	}
-	outRdr := data.NewReader(toreader.NewReader(r.r, int64(blockStart)), r.d, sizes, fragSize, r.Superblock.BlockSize)
-	if fragIndex != 0xffffffff {
-		ent, err := r.fragEntry(fragIndex)
-		if err != nil {
-			return nil, err
-		}
-		frag := data.NewReader(toreader.NewReader(r.r, int64(ent.Start)), r.d, []uint32{ent.Size}, uint64(r.Superblock.BlockSize), r.Superblock.BlockSize)
-		frag.Read(make([]byte, fragOffset))
-		outRdr.AddFrag(io.LimitReader(frag, int64(fragSize)))
+	d, err := b.regFileData(r)
+	if err != nil {
+		return nil, err
+	}
+	outRdr := data.NewReader(r.r, int64(d.blockStart), r.d, d.sizes, d.fragSize, r.Superblock.BlockSize)
+	outRdr.SetReadahead(r.readahead)
+	outRdr.SetGoroutineCounter(&r.stats.goroutines)
+	if d.hasFrag() {
+		outRdr.AddFrag(func() (io.Reader, error) {
+			return r.fragReader(d.fragIndex, d.fragOffset, d.fragSize)
+		})
 	}
 	return outRdr, nil
 }
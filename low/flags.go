@@ -0,0 +1,102 @@
+package squashfslow
+
+import "strings"
+
+// The superblock's flags, describing how the archive was built.
+type Flags uint16
+
+const (
+	FlagUncompressedInodes Flags = 1 << iota
+	FlagUncompressedData
+	FlagCheck // Unused in squashfs 4.0.
+	FlagUncompressedFragments
+	FlagNoFragments
+	FlagAlwaysFragment
+	FlagDuplicates
+	FlagExportable
+	FlagUncompressedXattrs
+	FlagNoXattrs
+	FlagCompressionOptions
+	FlagUncompressedIDs
+)
+
+var flagNames = []string{
+	"uncompressed inodes",
+	"uncompressed data",
+	"check",
+	"uncompressed fragments",
+	"no fragments",
+	"always fragment",
+	"duplicates removed",
+	"exportable",
+	"uncompressed xattrs",
+	"no xattrs",
+	"compression options",
+	"uncompressed ids",
+}
+
+// Returns whether inodes and directories are stored uncompressed.
+func (f Flags) UncompressedInodes() bool {
+	return f&FlagUncompressedInodes != 0
+}
+
+// Returns whether data blocks are stored uncompressed.
+func (f Flags) UncompressedData() bool {
+	return f&FlagUncompressedData != 0
+}
+
+// Returns whether fragment blocks are stored uncompressed.
+func (f Flags) UncompressedFragments() bool {
+	return f&FlagUncompressedFragments != 0
+}
+
+// Returns whether the ends of files are stored in their own block instead of a fragment.
+func (f Flags) NoFragments() bool {
+	return f&FlagNoFragments != 0
+}
+
+// Returns whether the ends of files are always stored in fragments, even for files larger than a block.
+func (f Flags) AlwaysFragment() bool {
+	return f&FlagAlwaysFragment != 0
+}
+
+// Returns whether duplicate files were removed, with their inodes pointing to the same data.
+func (f Flags) DuplicatesRemoved() bool {
+	return f&FlagDuplicates != 0
+}
+
+// Returns whether the archive has an export table.
+func (f Flags) Exportable() bool {
+	return f&FlagExportable != 0
+}
+
+// Returns whether xattrs are stored uncompressed.
+func (f Flags) UncompressedXattrs() bool {
+	return f&FlagUncompressedXattrs != 0
+}
+
+// Returns whether the archive was built without xattrs.
+func (f Flags) NoXattrs() bool {
+	return f&FlagNoXattrs != 0
+}
+
+// Returns whether compression options follow the superblock.
+func (f Flags) CompressionOptions() bool {
+	return f&FlagCompressionOptions != 0
+}
+
+// Returns whether the id table is stored uncompressed.
+func (f Flags) UncompressedIDs() bool {
+	return f&FlagUncompressedIDs != 0
+}
+
+// Returns the names of the set flags, separated by commas. Unknown flags aren't included.
+func (f Flags) String() string {
+	var names []string
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -1,7 +1,80 @@
 package squashfslow
 
-type fragEntry struct {
-	Start uint64
-	Size  uint32
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+)
+
+// The default number of decompressed fragment blocks kept in memory.
+const DefaultFragCacheSize = 16
+
+// An entry in the fragment table, describing where a fragment block is stored.
+type FragmentEntry struct {
+	Start uint64 // Offset of the fragment block from the start of the archive.
+	Size  uint32 // The stored size of the block. If bit 24 is set, the block is uncompressed. See StoredSize and Compressed.
 	_     uint32
 }
+
+// Returns the size of the fragment block as stored in the archive.
+func (f FragmentEntry) StoredSize() uint32 {
+	return f.Size &^ (1 << 24)
+}
+
+// Returns whether the fragment block is compressed.
+func (f FragmentEntry) Compressed() bool {
+	return f.Size&(1<<24) == 0
+}
+
+// Returns the decompressed fragment block at the given index in the fragment table.
+func (r *Reader) FragmentBlock(i uint32) ([]byte, error) {
+	return r.fragBlock(i)
+}
+
+// Returns the decompressed fragment block at the given index.
+// Since many small files share a fragment block, recently used blocks are cached.
+func (r *Reader) fragBlock(i uint32) ([]byte, error) {
+	var loaded bool
+	defer func() {
+		if loaded {
+			r.stats.fragCacheMisses.Add(1)
+		} else {
+			r.stats.fragCacheHits.Add(1)
+		}
+	}()
+	return r.fragCache.Get(i, func() ([]byte, error) {
+		loaded = true
+		ent, err := r.Fragment(i)
+		if err != nil {
+			return nil, err
+		}
+		realSize := ent.StoredSize()
+		if realSize > r.Superblock.BlockSize {
+			return nil, corrupt.Error("fragment block " + strconv.FormatUint(uint64(i), 10) + " is larger than the block size")
+		}
+		dat := make([]byte, realSize)
+		n, err := r.r.ReadAt(dat, int64(ent.Start))
+		if err != nil && (err != io.EOF || n != len(dat)) {
+			return nil, err
+		}
+		if !ent.Compressed() {
+			return dat, nil
+		}
+		return r.d.Decompress(dat)
+	})
+}
+
+// Returns a reader of a file's data stored in the given fragment.
+func (r *Reader) fragReader(index uint32, offset uint32, size uint64) (io.Reader, error) {
+	blk, err := r.fragBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(offset)+size > uint64(len(blk)) {
+		return nil, errors.New("fragment data out of bounds. possible corrupted archive")
+	}
+	return bytes.NewReader(blk[offset : uint64(offset)+size]), nil
+}
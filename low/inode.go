@@ -1,17 +1,17 @@
 package squashfslow
 
 import (
+	"bytes"
+	"errors"
+	"io/fs"
+
 	"github.com/CalebQ42/squashfs/internal/metadata"
-	"github.com/CalebQ42/squashfs/internal/toreader"
 	"github.com/CalebQ42/squashfs/low/directory"
 	"github.com/CalebQ42/squashfs/low/inode"
 )
 
-func (r *Reader) InodeFromRef(ref uint64) (inode.Inode, error) {
-	offset, meta := (ref>>16)+r.Superblock.InodeTableStart, ref&0xFFFF
-	rdr := metadata.NewReader(toreader.NewReader(r.r, int64(offset)), r.d)
-	defer rdr.Close()
-	_, err := rdr.Read(make([]byte, meta))
+func (r *Reader) InodeFromRef(ref MetaRef) (inode.Inode, error) {
+	rdr, err := r.inodeReader(ref.Block(), ref.Offset())
 	if err != nil {
 		return inode.Inode{}, err
 	}
@@ -19,8 +19,39 @@ func (r *Reader) InodeFromRef(ref uint64) (inode.Inode, error) {
 }
 
 func (r *Reader) InodeFromEntry(e directory.Entry) (inode.Inode, error) {
-	rdr := metadata.NewReader(toreader.NewReader(r.r, int64(r.Superblock.InodeTableStart)+int64(e.BlockStart)), r.d)
-	defer rdr.Close()
-	rdr.Read(make([]byte, e.Offset))
+	rdr, err := r.inodeReader(uint64(e.BlockStart), e.Offset)
+	if err != nil {
+		return inode.Inode{}, err
+	}
 	return inode.Read(rdr, r.Superblock.BlockSize)
 }
+
+// Calls fn with every inode in the inode table, in the order they're stored, along with the inode's reference
+// (its metadata block is relative to the inode table's start).
+// If fn returns an error, iteration stops and the error is returned, unless it's fs.SkipAll.
+// Uses the preloaded inode table if available, otherwise the whole table is decompressed first.
+func (r *Reader) Inodes(fn func(ref MetaRef, i inode.Inode) error) error {
+	table, _ := r.preloaded()
+	if table == nil {
+		var err error
+		table, err = metadata.ReadTable(r.r, int64(r.Superblock.InodeTableStart), int64(r.Superblock.DirTableStart), r.d)
+		if err != nil {
+			return err
+		}
+	}
+	rdr := bytes.NewReader(table.Data())
+	for rdr.Len() > 0 {
+		block, offset := table.Location(table.Size() - rdr.Len())
+		i, err := inode.Read(rdr, r.Superblock.BlockSize)
+		if err != nil {
+			return errors.Join(errors.New("failed to read inode at "+NewMetaRef(block, offset).String()), err)
+		}
+		err = fn(NewMetaRef(block, offset), i)
+		if err == fs.SkipAll {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package inode
 import (
 	"encoding/binary"
 	"io"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 )
 
 type Directory struct {
@@ -55,6 +57,9 @@ func ReadEDir(r io.Reader) (d EDirectory, err error) {
 		if err != nil {
 			return
 		}
+		if d.Indexes[i].NameSize > 255 {
+			return d, corrupt.Error("directory index name is longer than 256 bytes")
+		}
 		d.Indexes[i].Name = make([]byte, d.Indexes[i].NameSize+1)
 		err = binary.Read(r, binary.LittleEndian, &d.Indexes[i].Name)
 		if err != nil {
@@ -4,6 +4,9 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"strconv"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 )
 
 type fileInit struct {
@@ -38,12 +41,7 @@ func ReadFile(r io.Reader, blockSize uint32) (f File, err error) {
 	if err != nil {
 		return
 	}
-	toRead := int(math.Floor(float64(f.Size) / float64(blockSize)))
-	if f.FragInd == 0xFFFFFFFF && f.Size%blockSize > 0 {
-		toRead++
-	}
-	f.BlockSizes = make([]uint32, toRead)
-	err = binary.Read(r, binary.LittleEndian, &f.BlockSizes)
+	f.BlockSizes, err = readBlockSizes(r, uint64(f.Size), blockSize, f.FragInd != 0xFFFFFFFF)
 	return
 }
 
@@ -52,11 +50,29 @@ func ReadEFile(r io.Reader, blockSize uint32) (f EFile, err error) {
 	if err != nil {
 		return
 	}
-	toRead := int(math.Floor(float64(f.Size) / float64(blockSize)))
-	if f.FragInd == 0xFFFFFFFF && f.Size%uint64(blockSize) > 0 {
-		toRead++
-	}
-	f.BlockSizes = make([]uint32, toRead)
-	err = binary.Read(r, binary.LittleEndian, &f.BlockSizes)
+	f.BlockSizes, err = readBlockSizes(r, f.Size, blockSize, f.FragInd != 0xFFFFFFFF)
 	return
 }
+
+// Reads the size of each of a file's data blocks. If the file ends in a fragment, its final partial block isn't stored as a block.
+func readBlockSizes(r io.Reader, size uint64, blockSize uint32, hasFrag bool) ([]uint32, error) {
+	if blockSize == 0 {
+		return nil, corrupt.Error("block size is 0")
+	}
+	count := size / uint64(blockSize)
+	if !hasFrag && size%uint64(blockSize) > 0 {
+		count++
+	}
+	if count > math.MaxInt32/4 {
+		return nil, corrupt.Error("file size of " + strconv.FormatUint(size, 10) + " bytes is too large")
+	}
+	dat, err := readBytes(r, count*4)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint32, count)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint32(dat[i*4:])
+	}
+	return out, nil
+}
@@ -5,11 +5,17 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"math"
 	"strconv"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 )
 
+// An inode's type. Extended types (starting with E) hold extra information, such as xattrs, that basic types don't.
+type Type uint16
+
 const (
-	Dir = uint16(iota + 1)
+	Dir Type = iota + 1
 	Fil
 	Sym
 	Block
@@ -25,8 +31,57 @@ const (
 	ESock
 )
 
+var typeNames = []string{
+	"directory",
+	"file",
+	"symlink",
+	"block device",
+	"char device",
+	"fifo",
+	"socket",
+}
+
+// Returns a readable name for the type, such as "directory" or "extended file". Unknown types are formatted as "unknown (N)".
+func (t Type) String() string {
+	switch {
+	case t >= Dir && t <= Sock:
+		return typeNames[t-Dir]
+	case t >= EDir && t <= ESock:
+		return "extended " + typeNames[t-EDir]
+	default:
+		return "unknown (" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// Returns the matching basic type, such as Fil for EFil. Basic and unknown types are returned as is.
+func (t Type) Basic() Type {
+	if t >= EDir && t <= ESock {
+		return t - (EDir - Dir)
+	}
+	return t
+}
+
+// Matches an UnknownTypeError with errors.Is.
+var ErrUnknownType = errors.New("unknown inode type")
+
+// Returned by Read when an inode's type isn't known. The inode's header is still read, but its data can't be.
+type UnknownTypeError struct {
+	Header Header
+}
+
+func (e UnknownTypeError) Error() string {
+	return "unknown inode type " + strconv.Itoa(int(e.Header.Type)) + " (inode " + strconv.FormatUint(uint64(e.Header.Num), 10) + ")"
+}
+
+func (e UnknownTypeError) Is(target error) bool {
+	return target == ErrUnknownType
+}
+
+// The xattr index of an inode without xattrs.
+const NoXattr = 0xFFFFFFFF
+
 type Header struct {
-	Type    uint16
+	Type    Type
 	Perm    uint16
 	UidInd  uint16
 	GidInd  uint16
@@ -74,36 +129,58 @@ func Read(r io.Reader, blockSize uint32) (i Inode, err error) {
 	case ESock:
 		i.Data, err = ReadEIPC(r)
 	default:
-		return i, errors.New("invalid inode type " + strconv.Itoa(int(i.Type)))
+		return i, UnknownTypeError{Header: i.Header}
 	}
 	return
 }
 
+// Returns the inode's permissions and type as an fs.FileMode.
 func (i Inode) Mode() (out fs.FileMode) {
-	out = fs.FileMode(i.Perm)
-	switch i.Data.(type) {
-	case Directory:
-		out |= fs.ModeDir
-	case EDirectory:
+	out = fs.FileMode(i.Perm) & fs.ModePerm
+	if i.Perm&0o4000 != 0 {
+		out |= fs.ModeSetuid
+	}
+	if i.Perm&0o2000 != 0 {
+		out |= fs.ModeSetgid
+	}
+	if i.Perm&0o1000 != 0 {
+		out |= fs.ModeSticky
+	}
+	switch i.Type {
+	case Dir, EDir:
 		out |= fs.ModeDir
-	case Symlink:
-		out |= fs.ModeSymlink
-	case ESymlink:
+	case Sym, ESym:
 		out |= fs.ModeSymlink
-	case Device:
-		out |= fs.ModeDevice
-	case EDevice:
+	case Block, EBlock:
 		out |= fs.ModeDevice
-	case IPC:
-		out |= fs.ModeNamedPipe
-	case EIPC:
+	case Char, EChar:
+		out |= fs.ModeDevice | fs.ModeCharDevice
+	case Fifo, EFifo:
 		out |= fs.ModeNamedPipe
+	case Sock, ESock:
+		out |= fs.ModeSocket
 	}
 	return
 }
 
+// Returns the device number of a block or char device. For other types, returns 0.
+func (i Inode) Dev() uint32 {
+	switch data := i.Data.(type) {
+	case Device:
+		return data.Dev
+	case EDevice:
+		return data.Dev
+	default:
+		return 0
+	}
+}
+
+// Returns the number of hard links to the inode. Basic files don't store a link count, since they can't have more than one link,
+// so they always return 1. For directories, this includes the "." and ".." entries, plus one for each subdirectory.
 func (i Inode) LinkCount() uint32 {
 	switch i.Data.(type) {
+	case File:
+		return 1
 	case EFile:
 		return i.Data.(EFile).LinkCount
 	case Directory:
@@ -127,17 +204,74 @@ func (i Inode) LinkCount() uint32 {
 	}
 }
 
+// Returns the inode's size as reported by stat on a mounted archive: the size of a regular file's contents, the size of a
+// directory's listing in the directory table (including 3 bytes for the "." and ".." entries), or the length of a symlink's target.
+// For other types, returns 0.
 func (i Inode) Size() uint64 {
 	switch i.Data.(type) {
+	case Symlink:
+		return uint64(i.Data.(Symlink).TargetSize)
+	case ESymlink:
+		return uint64(i.Data.(ESymlink).TargetSize)
 	case File:
 		return uint64(i.Data.(File).Size)
 	case EFile:
 		return i.Data.(EFile).Size
-	// case Directory:
-	// 	return uint64(i.Data.(Directory).Size)
-	// case EDirectory:
-	// 	return uint64(i.Data.(EDirectory).Size)
+	case Directory:
+		return uint64(i.Data.(Directory).Size)
+	case EDirectory:
+		return uint64(i.Data.(EDirectory).Size)
 	default:
 		return 0
 	}
 }
+
+// Returns the inode number of a directory's parent. The root directory's parent is one more than the number of inodes.
+// Returns false if the inode isn't a directory.
+func (i Inode) ParentNum() (uint32, bool) {
+	switch data := i.Data.(type) {
+	case Directory:
+		return data.ParentNum, true
+	case EDirectory:
+		return data.ParentNum, true
+	default:
+		return 0, false
+	}
+}
+
+// Returns the inode's index into the xattr id table. Basic inode types can't have xattrs.
+// Returns NoXattr if the inode doesn't have any xattrs.
+func (i Inode) XattrInd() uint32 {
+	switch data := i.Data.(type) {
+	case EFile:
+		return data.XattrInd
+	case EDirectory:
+		return data.XattrInd
+	case ESymlink:
+		return data.XattrInd
+	case EDevice:
+		return data.XattrInd
+	case EIPC:
+		return data.XattrInd
+	default:
+		return NoXattr
+	}
+}
+
+// Reads n bytes from r. Sizes too large for an inode, which only come from corrupted archives, are read in chunks
+// so a bogus size fails once r runs out instead of allocating all of it up front.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	if n <= 1<<16 {
+		out := make([]byte, n)
+		_, err := io.ReadFull(r, out)
+		return out, err
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(min(n, math.MaxInt64))))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if uint64(len(out)) != n {
+		return nil, corrupt.Error("inode size of " + strconv.FormatUint(n, 10) + " bytes extends past the end of the inode table")
+	}
+	return out, nil
+}
@@ -26,8 +26,7 @@ func ReadSym(r io.Reader) (s Symlink, err error) {
 	if err != nil {
 		return
 	}
-	s.Target = make([]byte, s.TargetSize)
-	err = binary.Read(r, binary.LittleEndian, &s.Target)
+	s.Target, err = readBytes(r, uint64(s.TargetSize))
 	return
 }
 
@@ -36,8 +35,7 @@ func ReadESym(r io.Reader) (s ESymlink, err error) {
 	if err != nil {
 		return
 	}
-	s.Target = make([]byte, s.TargetSize)
-	err = binary.Read(r, binary.LittleEndian, &s.Target)
+	s.Target, err = readBytes(r, uint64(s.TargetSize))
 	if err != nil {
 		return
 	}
@@ -0,0 +1,89 @@
+package squashfslow
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+// A region of the archive, as reported by Layout.
+type Region struct {
+	Name       string // Such as "superblock", "data", or "inode table".
+	Start      int64  // Offset from the start of the archive.
+	Size       int64
+	Compressed bool // Whether the region's blocks can be compressed, according to the superblock's flags. Individual blocks might still be stored uncompressed.
+}
+
+// Returns where each part of the archive is stored, sorted by Start. Regions that aren't present, such as the export table
+// of a non-exportable archive, aren't included. For lookup tables, such as the fragment table, the region covers both the
+// table's metadata blocks and the index of their locations that follows them.
+func (r *Reader) Layout() ([]Region, error) {
+	sb := r.Superblock
+	out := []Region{{Name: "superblock", Start: 0, Size: 96}}
+	dataStart := int64(96)
+	if sb.CompressionOptions() {
+		var hdr uint16
+		err := binary.Read(toreader.NewReader(r.r, 96), binary.LittleEndian, &hdr)
+		if err != nil {
+			return nil, err
+		}
+		size := 2 + int64(hdr&^0x8000)
+		out = append(out, Region{Name: "compression options", Start: 96, Size: size, Compressed: hdr&0x8000 == 0})
+		dataStart += size
+	}
+	out = append(out,
+		Region{Name: "data", Start: dataStart, Size: int64(sb.InodeTableStart) - dataStart, Compressed: !sb.UncompressedData()},
+		Region{Name: "inode table", Start: int64(sb.InodeTableStart), Size: int64(sb.DirTableStart - sb.InodeTableStart), Compressed: !sb.UncompressedInodes()},
+	)
+	dirEnd, err := r.dirTableEnd()
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, Region{Name: "directory table", Start: int64(sb.DirTableStart), Size: dirEnd - int64(sb.DirTableStart), Compressed: !sb.UncompressedInodes()})
+	// Lookup tables are metadata blocks followed by an index of their locations.
+	table := func(name string, indexStart uint64, entries, entrySize int, compressed bool) error {
+		if entries == 0 {
+			return nil
+		}
+		var first uint64
+		err := binary.Read(toreader.NewReader(r.r, int64(indexStart)), binary.LittleEndian, &first)
+		if err != nil {
+			return err
+		}
+		blocks := (entries*entrySize + 8191) / 8192
+		end := int64(indexStart) + 8*int64(blocks)
+		out = append(out, Region{Name: name, Start: int64(first), Size: end - int64(first), Compressed: compressed})
+		return nil
+	}
+	if err = table("fragment table", sb.FragTableStart, int(sb.FragCount), 16, !sb.UncompressedFragments()); err != nil {
+		return nil, err
+	}
+	if sb.Exportable() {
+		if err = table("export table", sb.ExportTableStart, int(sb.InodeCount), 8, !sb.UncompressedInodes()); err != nil {
+			return nil, err
+		}
+	}
+	if err = table("id table", sb.IdTableStart, int(sb.IdCount), 4, !sb.UncompressedIDs()); err != nil {
+		return nil, err
+	}
+	if sb.XattrTableStart != 0xFFFFFFFFFFFFFFFF {
+		var hdr struct {
+			KVStart uint64
+			Count   uint32
+			_       uint32
+		}
+		err = binary.Read(toreader.NewReader(r.r, int64(sb.XattrTableStart)), binary.LittleEndian, &hdr)
+		if err != nil {
+			return nil, err
+		}
+		blocks := (int64(hdr.Count)*16 + 8191) / 8192
+		end := int64(sb.XattrTableStart) + 16 + 8*blocks
+		out = append(out, Region{Name: "xattr table", Start: int64(hdr.KVStart), Size: end - int64(hdr.KVStart), Compressed: !sb.UncompressedXattrs()})
+	}
+	slices.SortStableFunc(out, func(a, b Region) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return out, nil
+}
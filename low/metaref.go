@@ -0,0 +1,49 @@
+package squashfslow
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A reference to data in metadata blocks, such as an inode. The upper 48 bits are the location of a metadata block,
+// usually relative to the start of a table (such as the inode table), and the lower 16 bits are the offset into the
+// decompressed block.
+type MetaRef uint64
+
+// Returns a MetaRef to offset in the metadata block at block.
+func NewMetaRef(block uint64, offset uint16) MetaRef {
+	return MetaRef(block<<16 | uint64(offset))
+}
+
+// Parses a MetaRef formatted by MetaRef.String ("block:offset").
+func ParseMetaRef(s string) (MetaRef, error) {
+	blockStr, offsetStr, found := strings.Cut(s, ":")
+	if !found {
+		return 0, errors.New("invalid metadata reference " + strconv.Quote(s) + ". expected block:offset")
+	}
+	block, err := strconv.ParseUint(blockStr, 10, 48)
+	if err != nil {
+		return 0, errors.Join(errors.New("invalid metadata block in "+strconv.Quote(s)), err)
+	}
+	offset, err := strconv.ParseUint(offsetStr, 10, 16)
+	if err != nil {
+		return 0, errors.Join(errors.New("invalid metadata offset in "+strconv.Quote(s)), err)
+	}
+	return NewMetaRef(block, uint16(offset)), nil
+}
+
+// Returns the location of the metadata block.
+func (m MetaRef) Block() uint64 {
+	return uint64(m) >> 16
+}
+
+// Returns the offset into the decompressed metadata block.
+func (m MetaRef) Offset() uint16 {
+	return uint16(m)
+}
+
+// Formats the reference as "block:offset".
+func (m MetaRef) String() string {
+	return strconv.FormatUint(m.Block(), 10) + ":" + strconv.Itoa(int(m.Offset()))
+}
@@ -0,0 +1,130 @@
+package squashfslow
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/CalebQ42/squashfs/internal/metadata"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+// Decompresses the entire inode, directory, fragment, id, export, and xattr id tables and keeps them in memory,
+// so lookups no longer need to read or decompress anything. Trades memory for consistent lookup speed.
+// Should be called before the Reader is used concurrently.
+func (r *Reader) PreloadMetadata() error {
+	inodes, err := metadata.ReadTable(r.r, int64(r.Superblock.InodeTableStart), int64(r.Superblock.DirTableStart), r.d)
+	if err != nil {
+		return err
+	}
+	end, err := r.dirTableEnd()
+	if err != nil {
+		return err
+	}
+	dirs, err := metadata.ReadTable(r.r, int64(r.Superblock.DirTableStart), end, r.d)
+	if err != nil {
+		return err
+	}
+	r.tableMut.Lock()
+	r.inodeTable, r.dirTable = inodes, dirs
+	r.tableMut.Unlock()
+	// The other tables are kept once read, so reading the last entry populates them.
+	if r.Superblock.FragCount > 0 {
+		if _, err = r.Fragment(r.Superblock.FragCount - 1); err != nil {
+			return err
+		}
+	}
+	if r.Superblock.IdCount > 0 {
+		if _, err = r.Id(r.Superblock.IdCount - 1); err != nil {
+			return err
+		}
+	}
+	r.tableMut.Lock()
+	err = r.readXattrTable()
+	r.tableMut.Unlock()
+	if err != nil {
+		return err
+	}
+	if r.Superblock.Exportable() && r.Superblock.InodeCount > 0 {
+		if _, err = r.InodeRef(r.Superblock.InodeCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns where the directory table ends. The directory table is followed by the metadata blocks
+// of the fragment, export, id, or xattr tables, so the earliest of those is used.
+func (r *Reader) dirTableEnd() (int64, error) {
+	end := int64(r.Superblock.Size)
+	firstBlock := func(indexStart uint64) error {
+		var loc uint64
+		err := binary.Read(toreader.NewReader(r.r, int64(indexStart)), binary.LittleEndian, &loc)
+		if err == nil && loc > r.Superblock.DirTableStart {
+			end = min(end, int64(loc))
+		}
+		return err
+	}
+	if r.Superblock.FragCount > 0 {
+		if err := firstBlock(r.Superblock.FragTableStart); err != nil {
+			return 0, err
+		}
+	}
+	if r.Superblock.Exportable() {
+		if err := firstBlock(r.Superblock.ExportTableStart); err != nil {
+			return 0, err
+		}
+	}
+	if r.Superblock.IdCount > 0 {
+		if err := firstBlock(r.Superblock.IdTableStart); err != nil {
+			return 0, err
+		}
+	}
+	if r.Superblock.XattrTableStart != 0xFFFFFFFFFFFFFFFF {
+		// The xattr id table starts with the location of the xattr key/value table.
+		if err := firstBlock(r.Superblock.XattrTableStart); err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// Returns the preloaded inode and directory tables, which are nil unless PreloadMetadata has been called.
+func (r *Reader) preloaded() (inodes, dirs *metadata.Table) {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	return r.inodeTable, r.dirTable
+}
+
+// Returns a reader for the inode table starting offset bytes into the block at the given location.
+func (r *Reader) inodeReader(block uint64, offset uint16) (io.Reader, error) {
+	if table, _ := r.preloaded(); table != nil {
+		return table.Reader(block, offset)
+	}
+	return r.metadataReader(int64(r.Superblock.InodeTableStart)+int64(block), offset)
+}
+
+// Returns a reader for the directory table starting offset bytes into the block at the given location.
+func (r *Reader) dirReader(block uint32, offset uint16) (io.Reader, error) {
+	if _, table := r.preloaded(); table != nil {
+		return table.Reader(uint64(block), offset)
+	}
+	return r.metadataReader(int64(r.Superblock.DirTableStart)+int64(block), offset)
+}
+
+// Returns a reader of decompressed metadata starting at ref. Unlike inode and directory references, ref's block location
+// is relative to the start of the archive, so it can point into any table. For example, the root inode is at
+// NewMetaRef(Superblock.InodeTableStart+Superblock.RootInodeRef.Block(), Superblock.RootInodeRef.Offset()).
+// Reading continues into the following metadata blocks.
+func (r *Reader) MetadataReader(ref MetaRef) (io.Reader, error) {
+	return r.metadataReader(int64(ref.Block()), ref.Offset())
+}
+
+// Returns a reader of the metadata blocks starting at the given location, skipping offset bytes.
+func (r *Reader) metadataReader(loc int64, offset uint16) (io.Reader, error) {
+	rdr := metadata.NewReader(toreader.NewReader(r.r, loc), r.d)
+	_, err := rdr.Read(make([]byte, offset))
+	if err != nil {
+		return nil, err
+	}
+	return rdr, nil
+}
@@ -5,10 +5,17 @@ import (
 	"errors"
 	"io"
 	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
+	"github.com/CalebQ42/squashfs/internal/cache"
+	"github.com/CalebQ42/squashfs/internal/corrupt"
 	"github.com/CalebQ42/squashfs/internal/decompress"
 	"github.com/CalebQ42/squashfs/internal/metadata"
 	"github.com/CalebQ42/squashfs/internal/toreader"
+	"github.com/CalebQ42/squashfs/low/data"
 	"github.com/CalebQ42/squashfs/low/inode"
 )
 
@@ -26,51 +33,110 @@ var (
 	ErrorMagic         = errors.New("magic incorrect. probably not reading squashfs archive or archive is corrupted")
 	ErrorLog           = errors.New("block log is incorrect. possible corrupted archive")
 	ErrorVersion       = errors.New("squashfs version of archive is not 4.0. may be corrupted")
+	ErrorBigEndian     = errors.New("archive is big-endian (magic \"sqsh\"). big-endian archives are squashfs 3.x or older, usually from MIPS or PowerPC devices, and aren't supported")
 	ErrorNotExportable = errors.New("archive does not have an export table")
+	ErrorReaderClosed  = errors.New("reader is closed")
+	ErrorSuperblock    = errors.New("invalid superblock. possible corrupted archive")
+	// Matched by errors returned when the archive's tables or inodes are corrupted, such as sizes or offsets that are out of range.
+	// Also matched by SuperblockError.
+	ErrorCorrupt = corrupt.Err
 )
 
+// Returned when the archive isn't squashfs 4.0, such as the 2.x and 3.x archives found on older embedded devices,
+// which aren't supported. Matches ErrorVersion with errors.Is.
+type VersionError struct {
+	Major, Minor uint16
+}
+
+func (e VersionError) Error() string {
+	return "unsupported squashfs version " + strconv.Itoa(int(e.Major)) + "." + strconv.Itoa(int(e.Minor)) + ". only 4.0 is supported"
+}
+
+func (e VersionError) Is(target error) bool {
+	return target == ErrorVersion
+}
+
+// A copy of a Reader shares its caches, lock, and counters with the original. Use Clone for an independent Reader.
 type Reader struct {
-	r           io.ReaderAt
-	d           decompress.Decompressor
+	r           io.ReaderAt             // src, wrapped to count reads.
+	d           decompress.Decompressor // dec, wrapped to count decompressed blocks.
+	src         io.ReaderAt
+	dec         decompress.Decompressor
+	tableMut    *sync.Mutex // Guards lazy population of the fragment, id, and export tables, and the preloaded tables.
+	active      *sync.RWMutex
+	fragCache   *cache.Cache[uint32, []byte]
+	readahead   int
+	routines    uint16
+	lowMemory   bool
+	saved       savedSettings // The settings to restore when low memory mode is disabled.
+	stats       *stats
+	closed      *atomic.Bool
 	Root        Directory
-	fragTable   []fragEntry
+	fragTable   []FragmentEntry
 	idTable     []uint32
-	exportTable []uint64
-	Superblock  superblock
+	exportTable []MetaRef
+	xattrTable  []XattrID
+	xattrStart  uint64          // Start of the xattr key/value table. Set when the xattr id table is read.
+	inodeTable  *metadata.Table // Only set if the metadata has been preloaded.
+	dirTable    *metadata.Table
+	Superblock  Superblock
+}
+
+// The settings low memory mode replaces.
+type savedSettings struct {
+	fragCacheSize int
+	readahead     int
+	routines      uint16
 }
 
 func NewReader(r io.ReaderAt) (rdr *Reader, err error) {
 	rdr = new(Reader)
-	rdr.r = r
-	err = binary.Read(toreader.NewReader(r, 0), binary.LittleEndian, &rdr.Superblock)
+	rdr.stats = new(stats)
+	rdr.closed = new(atomic.Bool)
+	rdr.active = new(sync.RWMutex)
+	rdr.src = r
+	rdr.r = countingReaderAt{r: r, stats: rdr.stats, closed: rdr.closed, active: rdr.active}
+	rdr.tableMut = &sync.Mutex{}
+	rdr.fragCache = cache.New[uint32, []byte](DefaultFragCacheSize)
+	rdr.readahead = data.DefaultReadahead
+	err = binary.Read(toreader.NewReader(rdr.r, 0), binary.LittleEndian, &rdr.Superblock)
 	if err != nil {
 		return nil, errors.Join(errors.New("failed to read superblock"), err)
 	}
 	if !rdr.Superblock.ValidMagic() {
+		if rdr.Superblock.BigEndianMagic() {
+			return nil, ErrorBigEndian
+		}
 		return nil, ErrorMagic
 	}
+	// Checked before anything else as other versions have a different superblock layout, though the version is at the same offset.
+	if !rdr.Superblock.ValidVersion() {
+		return nil, VersionError{Major: rdr.Superblock.VerMaj, Minor: rdr.Superblock.VerMin}
+	}
+	err = rdr.Superblock.Validate()
+	if err != nil {
+		return nil, err
+	}
 	if !rdr.Superblock.ValidBlockLog() {
 		return nil, ErrorLog
 	}
-	if !rdr.Superblock.ValidVersion() {
-		return nil, ErrorVersion
-	}
 	switch rdr.Superblock.CompType {
 	case ZlibCompression:
-		rdr.d = decompress.Zlib{}
+		rdr.dec = decompress.Zlib{}
 	case LZMACompression:
-		rdr.d = decompress.Lzma{}
+		rdr.dec = decompress.Lzma{}
 	case LZOCompression:
-		rdr.d = decompress.Lzo{}
+		rdr.dec = decompress.Lzo{}
 	case XZCompression:
-		rdr.d = decompress.Xz{}
+		rdr.dec = decompress.Xz{}
 	case LZ4Compression:
-		rdr.d = decompress.Lz4{}
+		rdr.dec = decompress.Lz4{}
 	case ZSTDCompression:
-		rdr.d = &decompress.Zstd{}
+		rdr.dec = &decompress.Zstd{}
 	default:
 		return nil, errors.New("invalid compression type. possible corrupted archive")
 	}
+	rdr.d = newCountingDecompressor(rdr.dec, rdr.stats)
 	rdr.Root, err = rdr.directoryFromRef(rdr.Superblock.RootInodeRef, "")
 	if err != nil {
 		return nil, errors.Join(errors.New("failed to read root directory"), err)
@@ -78,8 +144,138 @@ func NewReader(r io.ReaderAt) (rdr *Reader, err error) {
 	return
 }
 
+// Set how many data blocks are decompressed in the background ahead of the current one when reading a file sequentially.
+// Only applies to readers created afterwards. In low memory mode, the setting is applied once low memory mode is disabled.
+func (r *Reader) SetReadahead(blocks int) {
+	if r.lowMemory {
+		r.saved.readahead = max(blocks, 0)
+		return
+	}
+	r.readahead = max(blocks, 0)
+}
+
+// Set the maximum number of goroutines used by FullReaders created afterwards.
+// If limit is 0, runtime.NumCPU() is used. In low memory mode, the limit is applied once low memory mode is disabled.
+func (r *Reader) SetGoroutineLimit(limit uint16) {
+	if r.lowMemory {
+		r.saved.routines = limit
+		return
+	}
+	r.routines = limit
+}
+
+// Returns a Reader for the same archive that doesn't share any mutable state with r.
+// The clone has its own caches, tables, and Stats, and copies r's settings. The underlying io.ReaderAt is shared.
+// Preloaded metadata is shared, as it's never modified.
+func (r *Reader) Clone() *Reader {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	out := *r
+	out.stats = new(stats)
+	out.closed = new(atomic.Bool)
+	out.closed.Store(r.closed.Load())
+	out.active = new(sync.RWMutex)
+	out.r = countingReaderAt{r: r.src, stats: out.stats, closed: out.closed, active: out.active}
+	out.d = newCountingDecompressor(r.dec, out.stats)
+	out.tableMut = &sync.Mutex{}
+	out.fragCache = cache.New[uint32, []byte](r.fragCache.Size())
+	// Clipped so appending to the lazily populated tables copies them instead of writing to the shared array.
+	out.fragTable = slices.Clip(r.fragTable)
+	out.idTable = slices.Clip(r.idTable)
+	out.exportTable = slices.Clip(r.exportTable)
+	return &out
+}
+
+// Releases the Reader's caches and tables. Afterwards, anything that reads from the archive returns ErrorReaderClosed.
+// Waits for reads from the underlying io.ReaderAt that are in progress, such as from readahead, so it can be closed
+// once Close returns. Doesn't close the underlying io.ReaderAt. Never returns an error.
+func (r *Reader) Close() error {
+	r.active.Lock()
+	r.closed.Store(true)
+	r.active.Unlock()
+	r.fragCache.Clear()
+	r.tableMut.Lock()
+	r.fragTable, r.idTable, r.exportTable, r.xattrTable = nil, nil, nil, nil
+	r.inodeTable, r.dirTable = nil, nil
+	r.tableMut.Unlock()
+	return nil
+}
+
+// Returns whether Close has been called.
+func (r *Reader) Closed() bool {
+	return r.closed.Load()
+}
+
+// Set how many decompressed fragment blocks are cached. If size is 0, DefaultFragCacheSize is used. If size is negative, the cache is disabled.
+// In low memory mode, the size is applied once low memory mode is disabled. Should be called before the Reader is used.
+func (r *Reader) SetFragCacheSize(size int) {
+	if size == 0 {
+		size = DefaultFragCacheSize
+	}
+	if r.lowMemory {
+		r.saved.fragCacheSize = max(size, 0)
+		return
+	}
+	r.fragCache = cache.New[uint32, []byte](max(size, 0))
+}
+
+// Disables all caches and background work to keep memory use small and predictable.
+// The fragment cache and FullReaders' block cache are disabled, readahead is set to 0, readers decompress one block at a time,
+// and any preloaded metadata is released. Disabling low memory mode restores the settings from before it was enabled, including
+// any changed while it was enabled. Should be called before the Reader is used.
+func (r *Reader) SetLowMemory(enabled bool) {
+	if enabled == r.lowMemory {
+		return
+	}
+	r.lowMemory = enabled
+	if enabled {
+		r.saved = savedSettings{fragCacheSize: r.fragCache.Size(), readahead: r.readahead, routines: r.routines}
+		r.fragCache = cache.New[uint32, []byte](0)
+		r.readahead = 0
+		r.routines = 1
+		r.tableMut.Lock()
+		r.inodeTable, r.dirTable = nil, nil
+		r.tableMut.Unlock()
+	} else {
+		r.fragCache = cache.New[uint32, []byte](r.saved.fragCacheSize)
+		r.readahead, r.routines = r.saved.readahead, r.saved.routines
+		r.saved = savedSettings{}
+	}
+}
+
+// Returns whether low memory mode is enabled.
+func (r *Reader) LowMemory() bool {
+	return r.lowMemory
+}
+
+// Returns how many blocks are read ahead, as set by SetReadahead. Always 0 in low memory mode.
+func (r *Reader) Readahead() int {
+	return r.readahead
+}
+
+// Returns the limit set by SetGoroutineLimit.
+func (r *Reader) GoroutineLimit() uint16 {
+	return r.routines
+}
+
+// Returns the id table, the list of uids and gids used by the archive. Inodes store indexes into it (inode.Header.UidInd and GidInd).
+func (r *Reader) IDs() ([]uint32, error) {
+	if r.Superblock.IdCount == 0 {
+		return nil, nil
+	}
+	// Reading the last entry populates the whole table.
+	if _, err := r.Id(r.Superblock.IdCount - 1); err != nil {
+		return nil, err
+	}
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	return slices.Clone(r.idTable), nil
+}
+
 // Get a uid/gid at the given index. Lazily populates the reader's Id table as necessary.
 func (r *Reader) Id(i uint16) (uint32, error) {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
 	if len(r.idTable) > int(i) {
 		return r.idTable[i], nil
 	} else if i >= r.Superblock.IdCount {
@@ -121,12 +317,28 @@ func (r *Reader) Id(i uint16) (uint32, error) {
 	return r.idTable[i], nil
 }
 
+// Returns all entries in the fragment table.
+func (r *Reader) Fragments() ([]FragmentEntry, error) {
+	if r.Superblock.FragCount == 0 {
+		return nil, nil
+	}
+	// Reading the last entry populates the whole table.
+	if _, err := r.Fragment(r.Superblock.FragCount - 1); err != nil {
+		return nil, err
+	}
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	return slices.Clone(r.fragTable), nil
+}
+
 // Get a fragment entry at the given index. Lazily populates the reader's fragment table as necessary.
-func (r *Reader) fragEntry(i uint32) (fragEntry, error) {
+func (r *Reader) Fragment(i uint32) (FragmentEntry, error) {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
 	if len(r.fragTable) > int(i) {
 		return r.fragTable[i], nil
 	} else if i >= r.Superblock.FragCount {
-		return fragEntry{}, errors.New("fragment out of bounds")
+		return FragmentEntry{}, errors.New("fragment out of bounds")
 	}
 	// Populate the fragment table as needed
 	var blockNum uint32
@@ -140,78 +352,80 @@ func (r *Reader) fragEntry(i uint32) (fragEntry, error) {
 
 	var offset uint64
 	var fragsToRead uint32
-	var fragsTmp []fragEntry
+	var fragsTmp []FragmentEntry
 	var err error
 	var rdr *metadata.Reader
 	for i := blocksRead; i < int(blocksRead)+blocksToRead; i++ {
 		err = binary.Read(toreader.NewReader(r.r, int64(r.Superblock.FragTableStart)+int64(8*i)), binary.LittleEndian, &offset)
 		if err != nil {
-			return fragEntry{}, err
+			return FragmentEntry{}, err
 		}
 		fragsToRead = r.Superblock.FragCount - uint32(len(r.fragTable))
 		if fragsToRead > 512 {
 			fragsToRead = 512
 		}
-		fragsTmp = make([]fragEntry, fragsToRead)
+		fragsTmp = make([]FragmentEntry, fragsToRead)
 		rdr = metadata.NewReader(toreader.NewReader(r.r, int64(offset)), r.d)
 		err = binary.Read(rdr, binary.LittleEndian, &fragsTmp)
 		rdr.Close()
 		if err != nil {
-			return fragEntry{}, err
+			return FragmentEntry{}, err
 		}
 		r.fragTable = append(r.fragTable, fragsTmp...)
 	}
 	return r.fragTable[i], nil
 }
 
-// Get an inode reference at the given index. Lazily populates the reader's export table as necessary.
-func (r *Reader) inodeRef(i uint32) (uint64, error) {
+// Returns the export table, which holds the inode reference of each inode. The reference of inode number n is at index n-1.
+// Returns ErrorNotExportable if the archive doesn't have one.
+func (r *Reader) ExportTable() ([]MetaRef, error) {
+	if r.Superblock.InodeCount == 0 && r.Superblock.Exportable() {
+		return nil, nil
+	}
+	// Reading the last entry populates the whole table.
+	if _, err := r.InodeRef(r.Superblock.InodeCount); err != nil {
+		return nil, err
+	}
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	return slices.Clone(r.exportTable), nil
+}
+
+// Returns the inode reference of inode number n using the export table.
+// Inode numbers start at 1. The export table is read as needed.
+func (r *Reader) InodeRef(n uint32) (MetaRef, error) {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
 	if !r.Superblock.Exportable() {
 		return 0, ErrorNotExportable
 	}
-	if len(r.exportTable) > int(i) {
-		return r.exportTable[i], nil
-	} else if i >= r.Superblock.InodeCount {
-		return 0, errors.New("inode out of bounds")
-	}
-	// Populate the export table as needed
-	var blockNum uint32
-	if i != 0 { // If i == 0, we go negatives causing issues with uint32s
-		blockNum = uint32(math.Ceil(float64(i+1)/1024)) - 1
-	} else {
-		blockNum = 0
+	if n == 0 || n > r.Superblock.InodeCount {
+		return 0, errors.New("inode number out of bounds")
 	}
-	blocksRead := len(r.exportTable) / 1024
-	blocksToRead := int(blockNum) - blocksRead + 1
-
-	var offset uint64
-	var refsToRead uint32
-	var refsTmp []uint64
-	var err error
-	var rdr *metadata.Reader
-	for i := blocksRead; i < int(blocksRead)+blocksToRead; i++ {
-		err = binary.Read(toreader.NewReader(r.r, int64(r.Superblock.ExportTableStart)+int64(8*i)), binary.LittleEndian, &offset)
+	i := n - 1
+	// Each metadata block holds 1024 references.
+	for len(r.exportTable) <= int(i) {
+		block := len(r.exportTable) / 1024
+		var offset uint64
+		err := binary.Read(toreader.NewReader(r.r, int64(r.Superblock.ExportTableStart)+int64(8*block)), binary.LittleEndian, &offset)
 		if err != nil {
 			return 0, err
 		}
-		refsToRead = r.Superblock.InodeCount - uint32(len(r.exportTable))
-		if refsToRead > 1024 {
-			refsToRead = 1024
-		}
-		refsTmp = make([]uint64, refsToRead)
-		rdr = metadata.NewReader(toreader.NewReader(r.r, int64(offset)), r.d)
-		err = binary.Read(rdr, binary.LittleEndian, &refsTmp)
+		refs := make([]MetaRef, min(r.Superblock.InodeCount-uint32(len(r.exportTable)), 1024))
+		rdr := metadata.NewReader(toreader.NewReader(r.r, int64(offset)), r.d)
+		err = binary.Read(rdr, binary.LittleEndian, &refs)
 		rdr.Close()
 		if err != nil {
 			return 0, err
 		}
-		r.exportTable = append(r.exportTable, refsTmp...)
+		r.exportTable = append(r.exportTable, refs...)
 	}
 	return r.exportTable[i], nil
 }
 
-func (r *Reader) Inode(i uint32) (inode.Inode, error) {
-	ref, err := r.inodeRef(i)
+// Returns the inode with the given inode number. Requires the archive to be exportable.
+func (r *Reader) Inode(n uint32) (inode.Inode, error) {
+	ref, err := r.InodeRef(n)
 	if err != nil {
 		return inode.Inode{}, err
 	}
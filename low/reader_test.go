@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"testing"
+
+	"github.com/CalebQ42/squashfs/internal/decompress"
 )
 
 const (
@@ -60,7 +62,7 @@ func TestMisc(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Log(rdr.Superblock.FragCount)
-	t.Fatal(rdr.fragEntry(1233))
+	t.Fatal(rdr.Fragment(1233))
 }
 
 func TestReader(t *testing.T) {
@@ -144,3 +146,12 @@ func extractToDir(rdr *Reader, b *FileBase, folder string) error {
 	}
 	return nil
 }
+
+func TestCountingDecompressor(t *testing.T) {
+	if _, ok := newCountingDecompressor(decompress.Zlib{}, new(stats)).(decompress.ToDecompressor); !ok {
+		t.Fatal("zlib supports DecompressTo, but the wrapper hides it")
+	}
+	if _, ok := newCountingDecompressor(decompress.Xz{}, new(stats)).(decompress.ToDecompressor); ok {
+		t.Fatal("xz doesn't support DecompressTo, but the wrapper claims it does")
+	}
+}
@@ -0,0 +1,103 @@
+package squashfslow
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CalebQ42/squashfs/internal/decompress"
+)
+
+// A snapshot of a Reader's counters.
+type Stats struct {
+	BytesRead          uint64 // Bytes read from the underlying io.ReaderAt.
+	Reads              uint64 // Calls to the underlying io.ReaderAt's ReadAt.
+	BlocksDecompressed uint64 // Data, fragment, and metadata blocks decompressed.
+	BytesDecompressed  uint64 // Size of all decompressed blocks.
+	FragCacheHits      uint64 // Fragment blocks found in the fragment cache.
+	FragCacheMisses    uint64 // Fragment blocks that had to be read and decompressed.
+	Goroutines         uint64 // Goroutines started to decompress data blocks.
+}
+
+type stats struct {
+	bytesRead          atomic.Uint64
+	reads              atomic.Uint64
+	blocksDecompressed atomic.Uint64
+	bytesDecompressed  atomic.Uint64
+	fragCacheHits      atomic.Uint64
+	fragCacheMisses    atomic.Uint64
+	goroutines         atomic.Uint64
+}
+
+// Returns a snapshot of the Reader's counters. Counters are kept since the Reader was created.
+func (r *Reader) Stats() Stats {
+	return Stats{
+		BytesRead:          r.stats.bytesRead.Load(),
+		Reads:              r.stats.reads.Load(),
+		BlocksDecompressed: r.stats.blocksDecompressed.Load(),
+		BytesDecompressed:  r.stats.bytesDecompressed.Load(),
+		FragCacheHits:      r.stats.fragCacheHits.Load(),
+		FragCacheMisses:    r.stats.fragCacheMisses.Load(),
+		Goroutines:         r.stats.goroutines.Load(),
+	}
+}
+
+// Counts reads from the underlying io.ReaderAt. Also fails reads once the Reader is closed.
+type countingReaderAt struct {
+	r      io.ReaderAt
+	stats  *stats
+	closed *atomic.Bool
+	active *sync.RWMutex // Held for reading during reads, so Close can wait for them to finish.
+}
+
+func (c countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.active.RLock()
+	defer c.active.RUnlock()
+	if c.closed.Load() {
+		return 0, ErrorReaderClosed
+	}
+	n, err := c.r.ReadAt(p, off)
+	c.stats.reads.Add(1)
+	c.stats.bytesRead.Add(uint64(n))
+	return n, err
+}
+
+// Counts decompressed blocks.
+type countingDecompressor struct {
+	d     decompress.Decompressor
+	stats *stats
+}
+
+// Wraps d to count decompressed blocks. The result only implements decompress.ToDecompressor if d does,
+// so callers checking for it can tell when decompressing into a buffer isn't supported.
+func newCountingDecompressor(d decompress.Decompressor, s *stats) decompress.Decompressor {
+	c := countingDecompressor{d: d, stats: s}
+	if to, ok := d.(decompress.ToDecompressor); ok {
+		return countingToDecompressor{countingDecompressor: c, to: to}
+	}
+	return c
+}
+
+func (c countingDecompressor) Decompress(b []byte) ([]byte, error) {
+	out, err := c.d.Decompress(b)
+	if err == nil {
+		c.stats.blocksDecompressed.Add(1)
+		c.stats.bytesDecompressed.Add(uint64(len(out)))
+	}
+	return out, err
+}
+
+// A countingDecompressor for decompressors that implement decompress.ToDecompressor.
+type countingToDecompressor struct {
+	countingDecompressor
+	to decompress.ToDecompressor
+}
+
+func (c countingToDecompressor) DecompressTo(dst, src []byte) (int, error) {
+	n, err := c.to.DecompressTo(dst, src)
+	if err == nil {
+		c.stats.blocksDecompressed.Add(1)
+		c.stats.bytesDecompressed.Add(uint64(n))
+	}
+	return n, err
+}
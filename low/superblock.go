@@ -1,8 +1,13 @@
 package squashfslow
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
-type superblock struct {
+// The archive's superblock, as stored at the start of the archive. Table starts are offsets from the start of the archive,
+// with unused tables set to 0xFFFFFFFFFFFFFFFF. Size is the archive's size in bytes (bytes_used).
+type Superblock struct {
 	Magic            uint32
 	InodeCount       uint32
 	ModTime          uint32
@@ -10,11 +15,11 @@ type superblock struct {
 	FragCount        uint32
 	CompType         uint16
 	BlockLog         uint16
-	Flags            uint16
+	Flags            Flags
 	IdCount          uint16
 	VerMaj           uint16
 	VerMin           uint16
-	RootInodeRef     uint64
+	RootInodeRef     MetaRef
 	Size             uint64
 	IdTableStart     uint64
 	XattrTableStart  uint64
@@ -24,57 +29,150 @@ type superblock struct {
 	ExportTableStart uint64
 }
 
-func (s superblock) ValidMagic() bool {
+func (s Superblock) ValidMagic() bool {
 	return s.Magic == 0x73717368
 }
 
-func (s superblock) ValidBlockLog() bool {
+// Returns whether the magic is byte-swapped, as in archives written on big-endian systems.
+func (s Superblock) BigEndianMagic() bool {
+	return s.Magic == 0x68737173
+}
+
+func (s Superblock) ValidBlockLog() bool {
 	return s.BlockLog == uint16(math.Log2(float64(s.BlockSize)))
 }
 
-func (s superblock) ValidVersion() bool {
+func (s Superblock) ValidVersion() bool {
 	return s.VerMaj == 4 && s.VerMin == 0
 }
 
-func (s superblock) UncompressedInodes() bool {
-	return s.Flags&0x1 == 0x1
+func (s Superblock) UncompressedInodes() bool {
+	return s.Flags.UncompressedInodes()
+}
+
+func (s Superblock) UncompressedData() bool {
+	return s.Flags.UncompressedData()
+}
+
+func (s Superblock) UncompressedFragments() bool {
+	return s.Flags.UncompressedFragments()
 }
 
-func (s superblock) UncompressedData() bool {
-	return s.Flags&0x2 == 0x2
+func (s Superblock) NoFragments() bool {
+	return s.Flags.NoFragments()
 }
-func (s superblock) UncompressedFragments() bool {
-	return s.Flags&0x8 == 0x8
+
+func (s Superblock) AlwaysFragment() bool {
+	return s.Flags.AlwaysFragment()
+}
+
+func (s Superblock) Duplicates() bool {
+	return s.Flags.DuplicatesRemoved()
 }
 
-func (s superblock) NoFragments() bool {
-	return s.Flags&0x10 == 0x10
+func (s Superblock) Exportable() bool {
+	return s.Flags.Exportable()
 }
 
-func (s superblock) AlwaysFragment() bool {
-	return s.Flags&0x20 == 0x20
+func (s Superblock) UncompressedXattrs() bool {
+	return s.Flags.UncompressedXattrs()
 }
 
-func (s superblock) Duplicates() bool {
-	return s.Flags&0x40 == 0x40
+func (s Superblock) NoXattrs() bool {
+	return s.Flags.NoXattrs()
 }
 
-func (s superblock) Exportable() bool {
-	return s.Flags&0x80 == 0x80
+func (s Superblock) CompressionOptions() bool {
+	return s.Flags.CompressionOptions()
 }
 
-func (s superblock) UncompressedXattrs() bool {
-	return s.Flags&0x100 == 0x100
+func (s Superblock) UncompressedIDs() bool {
+	return s.Flags.UncompressedIDs()
+}
+
+// The size of the superblock as stored in the archive.
+const SuperblockSize = 96
+
+// Returned when the superblock's values don't make sense, such as a table that starts past the end of the archive.
+// Matches ErrorSuperblock and ErrorCorrupt with errors.Is.
+type SuperblockError struct {
+	Reason string // Such as "directory table offset 0x1000 beyond archive end (0x800)".
 }
 
-func (s superblock) NoXattrs() bool {
-	return s.Flags&0x200 == 0x200
+func (e SuperblockError) Error() string {
+	return "invalid superblock: " + e.Reason
 }
 
-func (s superblock) CompressionOptions() bool {
-	return s.Flags&0x400 == 0x400
+func (e SuperblockError) Is(target error) bool {
+	return target == ErrorSuperblock || target == ErrorCorrupt
 }
 
-func (s superblock) UncompressedIDs() bool {
-	return s.Flags&0x800 == 0x800
+// Checks that the superblock's values are sane, such as the block size being a power of two and every table starting
+// within the archive, so a corrupted superblock is reported clearly instead of causing confusing errors later.
+// Doesn't check the magic or version. Returns a SuperblockError describing the first problem found.
+func (s Superblock) Validate() error {
+	bad := func(format string, args ...any) error {
+		return SuperblockError{Reason: fmt.Sprintf(format, args...)}
+	}
+	if s.BlockSize < 4096 || s.BlockSize > 1024*1024 || s.BlockSize&(s.BlockSize-1) != 0 {
+		return bad("block size %d isn't a power of two between 4KiB and 1MiB", s.BlockSize)
+	}
+	if s.Size < SuperblockSize {
+		return bad("bytes used (%d) is smaller than the superblock", s.Size)
+	}
+	if s.InodeCount == 0 {
+		return bad("inode count is 0")
+	}
+	if s.IdCount == 0 {
+		return bad("id count is 0")
+	}
+	tables := []struct {
+		name  string
+		start uint64
+		used  bool
+	}{
+		{"inode table", s.InodeTableStart, true},
+		{"directory table", s.DirTableStart, true},
+		{"fragment table", s.FragTableStart, s.FragCount > 0},
+		{"export table", s.ExportTableStart, s.Exportable()},
+		{"id table", s.IdTableStart, true},
+		{"xattr table", s.XattrTableStart, s.XattrTableStart != 0xFFFFFFFFFFFFFFFF},
+	}
+	for _, t := range tables {
+		if !t.used {
+			continue
+		}
+		if t.start < SuperblockSize {
+			return bad("%s offset %#x overlaps the superblock", t.name, t.start)
+		}
+		if t.start >= s.Size {
+			return bad("%s offset %#x beyond archive end (%#x)", t.name, t.start, s.Size)
+		}
+	}
+	// The lookup tables' indexes, which hold the location of each of the table's metadata blocks, must fit in the archive.
+	lookups := []struct {
+		name            string
+		start, count    uint64
+		entriesPerBlock uint64
+		used            bool
+	}{
+		{"fragment table", s.FragTableStart, uint64(s.FragCount), 512, s.FragCount > 0},
+		{"export table", s.ExportTableStart, uint64(s.InodeCount), 1024, s.Exportable()},
+		{"id table", s.IdTableStart, uint64(s.IdCount), 2048, true},
+	}
+	for _, t := range lookups {
+		if t.used && t.start+8*((t.count+t.entriesPerBlock-1)/t.entriesPerBlock) > s.Size {
+			return bad("%s's %d entries don't fit in the archive", t.name, t.count)
+		}
+	}
+	if s.InodeTableStart > s.DirTableStart {
+		return bad("inode table offset %#x is after the directory table offset %#x", s.InodeTableStart, s.DirTableStart)
+	}
+	if s.RootInodeRef.Block() >= s.DirTableStart-s.InodeTableStart {
+		return bad("root inode %v is outside the inode table", s.RootInodeRef)
+	}
+	if s.NoFragments() && s.FragCount > 0 {
+		return bad("fragments are disabled, but there are %d fragments", s.FragCount)
+	}
+	return nil
 }
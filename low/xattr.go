@@ -0,0 +1,161 @@
+package squashfslow
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+	"github.com/CalebQ42/squashfs/internal/metadata"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+	"github.com/CalebQ42/squashfs/low/inode"
+)
+
+// The name prefixes of xattrs, indexed by their type.
+var xattrPrefixes = []string{"user.", "trusted.", "security."}
+
+// Set on an xattr's type if its value is stored elsewhere in the key/value table.
+const xattrOutOfLine = 0x100
+
+// An entry in the xattr id table, describing a set of xattrs in the xattr key/value table. Inodes refer to an entry by its index.
+type XattrID struct {
+	Ref   MetaRef // Location of the first key, relative to the start of the key/value table.
+	Count uint32  // The number of xattrs.
+	Size  uint32  // The total size of the xattrs' keys and values.
+}
+
+// An extended attribute. Name includes its prefix, such as "user." or "security.".
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// Returns the xattr id table. If the archive doesn't have xattrs, returns nil.
+func (r *Reader) XattrTable() ([]XattrID, error) {
+	r.tableMut.Lock()
+	defer r.tableMut.Unlock()
+	if err := r.readXattrTable(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(r.xattrTable), nil
+}
+
+// Reads the xattr id table if it hasn't been read yet. r.tableMut must be held.
+func (r *Reader) readXattrTable() error {
+	if r.xattrTable != nil || r.Superblock.XattrTableStart == 0xFFFFFFFFFFFFFFFF {
+		return nil
+	}
+	var hdr struct {
+		KVStart uint64
+		Count   uint32
+		_       uint32
+	}
+	err := binary.Read(toreader.NewReader(r.r, int64(r.Superblock.XattrTableStart)), binary.LittleEndian, &hdr)
+	if err != nil {
+		return errors.Join(errors.New("failed to read xattr table header"), err)
+	}
+	if blocks := (uint64(hdr.Count) + 511) / 512; r.Superblock.XattrTableStart+16+8*blocks > r.Superblock.Size {
+		return corrupt.Error("xattr id table's " + strconv.FormatUint(uint64(hdr.Count), 10) + " entries don't fit in the archive")
+	}
+	table := make([]XattrID, 0, min(hdr.Count, 512))
+	// Each metadata block holds 512 entries.
+	for block := 0; len(table) < int(hdr.Count); block++ {
+		var loc uint64
+		err = binary.Read(toreader.NewReader(r.r, int64(r.Superblock.XattrTableStart)+16+int64(8*block)), binary.LittleEndian, &loc)
+		if err != nil {
+			return err
+		}
+		ids := make([]XattrID, min(int(hdr.Count)-len(table), 512))
+		rdr := metadata.NewReader(toreader.NewReader(r.r, int64(loc)), r.d)
+		err = binary.Read(rdr, binary.LittleEndian, &ids)
+		rdr.Close()
+		if err != nil {
+			return errors.Join(errors.New("failed to read xattr id table"), err)
+		}
+		table = append(table, ids...)
+	}
+	r.xattrStart = hdr.KVStart
+	r.xattrTable = table
+	return nil
+}
+
+// Returns the xattrs at index i of the xattr id table, such as from inode.Inode.XattrInd.
+// If i is inode.NoXattr, returns nil.
+func (r *Reader) Xattrs(i uint32) ([]Xattr, error) {
+	if i == inode.NoXattr {
+		return nil, nil
+	}
+	r.tableMut.Lock()
+	err := r.readXattrTable()
+	table, kvStart := r.xattrTable, r.xattrStart
+	r.tableMut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if int(i) >= len(table) {
+		return nil, errors.New("xattr index " + strconv.FormatUint(uint64(i), 10) + " out of bounds")
+	}
+	id := table[i]
+	rdr, err := r.metadataReader(int64(kvStart+id.Ref.Block()), id.Ref.Offset())
+	if err != nil {
+		return nil, err
+	}
+	// Count isn't trusted for the allocation, since a corrupted count would otherwise allocate far more than the table holds.
+	out := make([]Xattr, 0, min(id.Count, 64))
+	for range id.Count {
+		var x Xattr
+		var key struct {
+			Type uint16
+			Size uint16
+		}
+		if err = binary.Read(rdr, binary.LittleEndian, &key); err != nil {
+			return nil, err
+		}
+		name := make([]byte, key.Size)
+		if err = binary.Read(rdr, binary.LittleEndian, &name); err != nil {
+			return nil, err
+		}
+		prefix := int(key.Type &^ xattrOutOfLine)
+		if prefix >= len(xattrPrefixes) {
+			return nil, corrupt.Error("unknown xattr type " + strconv.Itoa(prefix))
+		}
+		x.Name = xattrPrefixes[prefix] + string(name)
+		x.Value, err = readXattrValue(rdr)
+		if err != nil {
+			return nil, err
+		}
+		if key.Type&xattrOutOfLine != 0 {
+			if len(x.Value) != 8 {
+				return nil, corrupt.Error("invalid out of line xattr value")
+			}
+			ref := MetaRef(binary.LittleEndian.Uint64(x.Value))
+			valRdr, err := r.metadataReader(int64(kvStart+ref.Block()), ref.Offset())
+			if err != nil {
+				return nil, err
+			}
+			x.Value, err = readXattrValue(valRdr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, x)
+	}
+	return out, nil
+}
+
+// Reads a size prefixed xattr value.
+func readXattrValue(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	// Linux limits values to 64KiB.
+	if size > 1<<16 {
+		return nil, corrupt.Error("xattr value is larger than 64KiB")
+	}
+	val := make([]byte, size)
+	_, err := io.ReadFull(r, val)
+	return val, err
+}
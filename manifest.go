@@ -0,0 +1,41 @@
+package squashfs
+
+import (
+	"hash"
+	"io/fs"
+)
+
+// Returns the digest of every regular file in the archive, keyed by path, such as to check the archive against a
+// published SHA256SUMS file (use sha256.New) or for supply chain attestations. Each file's data is decompressed with
+// multiple goroutines, like WriteTo. Hard links are only read once. Symlinks aren't followed.
+func (r *Reader) Manifest(h func() hash.Hash) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	// Digests of files with hard links, by inode number.
+	linked := make(map[uint32][]byte)
+	err := r.walkFiles(func(path string, f *File, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		defer f.Close()
+		if sum, ok := linked[f.InodeNum()]; ok {
+			out[path] = sum
+			return nil
+		}
+		dig := h()
+		if _, err = f.WriteTo(dig); err != nil {
+			return err
+		}
+		out[path] = dig.Sum(nil)
+		if f.b.Inode.LinkCount() > 1 {
+			linked[f.InodeNum()] = out[path]
+		}
+		return nil
+	}, &WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
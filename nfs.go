@@ -0,0 +1,59 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// Returned by FromHandle when the handle isn't from this archive, or is malformed. NFS servers should report it as a stale handle.
+var ErrStaleHandle = errors.New("file handle is not from this archive")
+
+// The size of handles returned by Handle.
+const HandleSize = 8
+
+// Returns a file handle for f, such as for an NFS server, that can be turned back into the file with FromHandle.
+// Handles stay valid for as long as the archive is served, including across restarts, and include an id of the archive
+// so handles from a different archive are rejected. Requires an exportable archive (mksquashfs's default) to use with FromHandle.
+func (r *Reader) Handle(f *File) []byte {
+	out := make([]byte, HandleSize)
+	binary.LittleEndian.PutUint32(out, f.InodeNum())
+	binary.LittleEndian.PutUint32(out[4:], r.ArchiveID())
+	return out
+}
+
+// Opens the file from a handle returned by Handle, using the archive's export table.
+// Returns ErrStaleHandle if h isn't from this archive, or squashfslow.ErrorNotExportable if the archive doesn't have an export table.
+// Like OpenInode, the File is named after its inode number (unless it's the root) and relative symlinks can't be resolved.
+func (r *Reader) FromHandle(h []byte) (*File, error) {
+	if len(h) != HandleSize || binary.LittleEndian.Uint32(h[4:]) != r.ArchiveID() {
+		return nil, ErrStaleHandle
+	}
+	n := binary.LittleEndian.Uint32(h)
+	if n == 0 || n > r.Low.Superblock.InodeCount {
+		return nil, ErrStaleHandle
+	}
+	return r.OpenInode(n)
+}
+
+// Returns the inode number of the directory's parent, such as to answer an NFS lookup of "..". The root directory is its own parent.
+// Returns false if f isn't a directory.
+func (r *Reader) ParentInode(f *File) (uint32, bool) {
+	n, ok := f.b.Inode.ParentNum()
+	if !ok {
+		return 0, false
+	}
+	// The root's parent is past the last inode.
+	if n == 0 || n > r.Low.Superblock.InodeCount {
+		return r.FS.d.Inode.Num, true
+	}
+	return n, true
+}
+
+// Returns an id for the archive from its superblock, such as to tell handles or cached data from other archives apart.
+func (r *Reader) ArchiveID() uint32 {
+	sb := r.Low.Superblock
+	h := fnv.New32a()
+	binary.Write(h, binary.LittleEndian, []uint64{uint64(sb.ModTime), sb.Size, uint64(sb.InodeCount), uint64(sb.RootInodeRef)})
+	return h.Sum32()
+}
@@ -0,0 +1,465 @@
+// Package ninep serves a squashfs archive read-only over 9P2000.L, such as to share it into a VM guest over virtio-9p without extracting it.
+package ninep
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+// 9P2000.L message types.
+const (
+	p9Rlerror    = 7
+	p9Tstatfs    = 8
+	p9Tlopen     = 12
+	p9Treadlink  = 22
+	p9Tgetattr   = 24
+	p9Txattrwalk = 30
+	p9Treaddir   = 40
+	p9Tversion   = 100
+	p9Tattach    = 104
+	p9Tflush     = 108
+	p9Twalk      = 110
+	p9Tread      = 116
+	p9Tclunk     = 120
+)
+
+// Linux errno values returned in Rlerror.
+const (
+	p9ENOENT     = 2
+	p9EIO        = 5
+	p9EBADF      = 9
+	p9ENOTDIR    = 20
+	p9EINVAL     = 22
+	p9EROFS      = 30
+	p9ENODATA    = 61
+	p9EPROTO     = 71
+	p9EOPNOTSUPP = 95
+)
+
+// The largest message size Serve agrees to.
+const p9MaxMsize = 1 << 20
+
+// The smallest message size Serve agrees to. Smaller messages can't fit a reply's header.
+const p9MinMsize = 64
+
+// Serves the archive read-only over 9P2000.L on rw, such as a virtio-9p channel or a net.Conn, until rw returns an error
+// (io.EOF when the client disconnects, which returns nil). Requests are answered in order, one at a time.
+// Symlinks are reported as symlinks, xattrs can be read with Txattrwalk, and anything that would modify the archive returns EROFS.
+func Serve(r *squashfs.Reader, rw io.ReadWriter) error {
+	s := p9Server{r: r, rw: rw, msize: p9MaxMsize, fids: make(map[uint32]*p9Fid)}
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(rw, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n < 7 || n > s.msize {
+			return errors.New("invalid 9P message size")
+		}
+		msg := make([]byte, n-4)
+		if _, err := io.ReadFull(rw, msg); err != nil {
+			return err
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+type p9Server struct {
+	r     *squashfs.Reader
+	rw    io.ReadWriter
+	fids  map[uint32]*p9Fid
+	msize uint32
+}
+
+type p9Fid struct {
+	path  string
+	file  *squashfs.File
+	ents  []fs.DirEntry // A directory's entries, read by the first Treaddir.
+	xattr []byte        // Set for fids from Txattrwalk, which are read instead of file.
+	isX   bool
+}
+
+// Decodes 9P messages. Reading past the end sets bad instead of panicking.
+type p9Dec struct {
+	b   []byte
+	bad bool
+}
+
+func (d *p9Dec) next(n int) []byte {
+	if len(d.b) < n {
+		d.bad = true
+		return make([]byte, n)
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *p9Dec) u16() uint16 { return binary.LittleEndian.Uint16(d.next(2)) }
+func (d *p9Dec) u32() uint32 { return binary.LittleEndian.Uint32(d.next(4)) }
+func (d *p9Dec) u64() uint64 { return binary.LittleEndian.Uint64(d.next(8)) }
+func (d *p9Dec) str() string { return string(d.next(int(d.u16()))) }
+
+type p9Enc []byte
+
+func (e *p9Enc) u8(v uint8)   { *e = append(*e, v) }
+func (e *p9Enc) u16(v uint16) { *e = binary.LittleEndian.AppendUint16(*e, v) }
+func (e *p9Enc) u32(v uint32) { *e = binary.LittleEndian.AppendUint32(*e, v) }
+func (e *p9Enc) u64(v uint64) { *e = binary.LittleEndian.AppendUint64(*e, v) }
+func (e *p9Enc) str(s string) {
+	e.u16(uint16(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *p9Enc) qid(f *squashfs.File) {
+	var typ uint8
+	switch {
+	case f.IsDir():
+		typ = 0x80
+	case f.IsSymlink():
+		typ = 0x02
+	}
+	e.u8(typ)
+	e.u32(0)
+	e.u64(uint64(f.InodeNum()))
+}
+
+// A 9P error, sent as Rlerror.
+type p9Errno uint32
+
+func (e p9Errno) Error() string {
+	return "9P error " + strconv.Itoa(int(e))
+}
+
+func (s *p9Server) handle(msg []byte) error {
+	d := &p9Dec{b: msg[3:]}
+	typ, tag := msg[0], binary.LittleEndian.Uint16(msg[1:])
+	var out p9Enc
+	err := s.dispatch(typ, d, &out)
+	// Replies' types are one more than the request's.
+	typ++
+	if err == nil && d.bad {
+		err = p9Errno(p9EPROTO)
+	}
+	if err != nil {
+		errno := p9Errno(p9EIO)
+		switch {
+		case errors.As(err, &errno):
+		case errors.Is(err, fs.ErrNotExist):
+			errno = p9ENOENT
+		case errors.Is(err, fs.ErrInvalid):
+			errno = p9EINVAL
+		}
+		typ, out = p9Rlerror, nil
+		out.u32(uint32(errno))
+	}
+	reply := make(p9Enc, 0, 7+len(out))
+	reply.u32(uint32(7 + len(out)))
+	reply.u8(typ)
+	reply.u16(tag)
+	reply = append(reply, out...)
+	_, err = s.rw.Write(reply)
+	return err
+}
+
+// Handles the message, writing the reply's body (after its tag) to out.
+func (s *p9Server) dispatch(typ uint8, d *p9Dec, out *p9Enc) error {
+	switch typ {
+	case p9Tversion:
+		msize, version := d.u32(), d.str()
+		if msize < p9MinMsize {
+			return p9Errno(p9EINVAL)
+		}
+		// The client's msize can be lowered, but never raised.
+		s.msize = min(msize, p9MaxMsize)
+		clear(s.fids)
+		if version != "9P2000.L" {
+			version = "unknown"
+		}
+		out.u32(s.msize)
+		out.str(version)
+		return nil
+	case p9Tflush:
+		// Requests are answered before the next is read, so there's never anything to flush.
+		d.u16()
+		return nil
+	case p9Tattach:
+		fid := d.u32()
+		d.u32()
+		d.str()
+		d.str()
+		d.u32()
+		root := s.r.File()
+		s.fids[fid] = &p9Fid{path: ".", file: root}
+		out.qid(root)
+		return nil
+	}
+	fidNum := d.u32()
+	fid, ok := s.fids[fidNum]
+	if !ok {
+		return p9Errno(p9EBADF)
+	}
+	switch typ {
+	case p9Twalk:
+		return s.walk(fid, d, out)
+	case p9Tclunk:
+		delete(s.fids, fidNum)
+		return nil
+	case p9Tlopen:
+		// O_WRONLY, O_RDWR, and O_TRUNC.
+		if d.u32()&(0x3|0x200) != 0 {
+			return p9Errno(p9EROFS)
+		}
+		out.qid(fid.file)
+		out.u32(0)
+		return nil
+	case p9Tread:
+		return s.read(fid, d.u64(), d.u32(), out)
+	case p9Treaddir:
+		return s.readdir(fid, d.u64(), d.u32(), out)
+	case p9Tgetattr:
+		d.u64()
+		return s.getattr(fid.file, out)
+	case p9Treadlink:
+		if !fid.file.IsSymlink() {
+			return p9Errno(p9EINVAL)
+		}
+		out.str(fid.file.SymlinkPath())
+		return nil
+	case p9Txattrwalk:
+		return s.xattrwalk(fid, d.u32(), d.str(), out)
+	case p9Tstatfs:
+		sb := s.r.Superblock()
+		out.u32(sb.Magic)
+		out.u32(sb.BlockSize)
+		out.u64((sb.Size + uint64(sb.BlockSize) - 1) / uint64(sb.BlockSize))
+		out.u64(0)
+		out.u64(0)
+		out.u64(uint64(sb.InodeCount))
+		out.u64(0)
+		out.u64(uint64(s.r.ArchiveID()))
+		out.u32(256)
+		return nil
+	}
+	// Everything else modifies the FS or isn't supported.
+	switch typ {
+	case 14, 16, 18, 26, 32, 52, 54, 70, 72, 74, 76:
+		return p9Errno(p9EROFS)
+	}
+	return p9Errno(p9EOPNOTSUPP)
+}
+
+func (s *p9Server) walk(fid *p9Fid, d *p9Dec, out *p9Enc) error {
+	newFid := d.u32()
+	names := make([]string, d.u16())
+	for i := range names {
+		names[i] = d.str()
+	}
+	if fid.isX {
+		return p9Errno(p9EBADF)
+	}
+	cur, file := fid.path, fid.file
+	var qids p9Enc
+	for i, name := range names {
+		if !file.IsDir() {
+			if i == 0 {
+				return p9Errno(p9ENOTDIR)
+			}
+			break
+		}
+		if name == "" || name == "." || strings.Contains(name, "/") {
+			return p9Errno(p9EINVAL)
+		}
+		next := path.Join(cur, name)
+		if next == ".." {
+			// The root's parent is itself.
+			next = "."
+		}
+		f, err := s.r.OpenFile(next)
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			break
+		}
+		cur, file = next, f
+		qids.qid(f)
+	}
+	n := len(qids) / 13
+	if n == len(names) {
+		s.fids[newFid] = &p9Fid{path: cur, file: file}
+	}
+	out.u16(uint16(n))
+	*out = append(*out, qids...)
+	return nil
+}
+
+func (s *p9Server) read(fid *p9Fid, off uint64, count uint32, out *p9Enc) error {
+	count = min(count, s.msize-11)
+	var buf []byte
+	switch {
+	case fid.isX:
+		if off < uint64(len(fid.xattr)) {
+			buf = fid.xattr[off:min(off+uint64(count), uint64(len(fid.xattr)))]
+		}
+	case fid.file.IsRegular():
+		buf = make([]byte, count)
+		n, err := fid.file.ReadAt(buf, int64(off))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		buf = buf[:n]
+	default:
+		return p9Errno(p9EINVAL)
+	}
+	out.u32(uint32(len(buf)))
+	*out = append(*out, buf...)
+	return nil
+}
+
+// Entries are numbered from 1, with "." and ".." first. Each entry's offset is the offset of the next entry.
+func (s *p9Server) readdir(fid *p9Fid, off uint64, count uint32, out *p9Enc) error {
+	if fid.isX || !fid.file.IsDir() {
+		return p9Errno(p9ENOTDIR)
+	}
+	if fid.ents == nil || off == 0 {
+		dir, err := fid.file.FS()
+		if err != nil {
+			return err
+		}
+		fid.ents, err = dir.ReadDir(".")
+		if err != nil {
+			return err
+		}
+	}
+	count = min(count, s.msize-11)
+	var ents p9Enc
+	for i := off; i < uint64(len(fid.ents))+2; i++ {
+		var name string
+		var f *squashfs.File
+		var err error
+		switch i {
+		case 0:
+			name, f = ".", fid.file
+		case 1:
+			name = ".."
+			f, err = s.r.OpenFile(path.Dir(fid.path))
+		default:
+			name = fid.ents[i-2].Name()
+			f, err = s.r.OpenFile(path.Join(fid.path, name))
+		}
+		if err != nil {
+			return err
+		}
+		var ent p9Enc
+		ent.qid(f)
+		ent.u64(i + 1)
+		ent.u8(uint8(unixMode(f) >> 12))
+		ent.str(name)
+		if len(ents)+len(ent) > int(count) {
+			break
+		}
+		ents = append(ents, ent...)
+	}
+	out.u32(uint32(len(ents)))
+	*out = append(*out, ents...)
+	return nil
+}
+
+func (s *p9Server) getattr(f *squashfs.File, out *p9Enc) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	sys := info.Sys().(*squashfs.Stat)
+	size := uint64(info.Size())
+	mtime := uint64(info.ModTime().Unix())
+	// All of the basic fields are valid.
+	out.u64(0x7FF)
+	out.qid(f)
+	out.u32(unixMode(f))
+	out.u32(sys.Uid)
+	out.u32(sys.Gid)
+	out.u64(uint64(sys.Nlink))
+	// squashfs and 9P use the same encoding as Linux's new_encode_dev.
+	out.u64(uint64(sys.Rdev))
+	out.u64(size)
+	out.u64(uint64(s.r.Superblock().BlockSize))
+	out.u64((size + 511) / 512)
+	// atime, mtime, ctime, and btime, all set to the modification time.
+	for range 4 {
+		out.u64(mtime)
+		out.u64(0)
+	}
+	out.u64(0)
+	out.u64(0)
+	return nil
+}
+
+// With an empty name, newFid reads the names of the file's xattrs, each followed by a NUL. Otherwise it reads the xattr's value.
+func (s *p9Server) xattrwalk(fid *p9Fid, newFid uint32, name string, out *p9Enc) error {
+	xattrs, err := fid.file.Xattrs()
+	if err != nil {
+		return err
+	}
+	var val []byte
+	if name == "" {
+		for n := range xattrs {
+			val = append(append(val, n...), 0)
+		}
+	} else {
+		var ok bool
+		val, ok = xattrs[name]
+		if !ok {
+			return p9Errno(p9ENODATA)
+		}
+	}
+	s.fids[newFid] = &p9Fid{path: fid.path, file: fid.file, xattr: val, isX: true}
+	out.u64(uint64(len(val)))
+	return nil
+}
+
+// Returns the file's mode as used by stat on Linux, such as 0o100644 for a regular file.
+func unixMode(f *squashfs.File) uint32 {
+	m := f.Mode()
+	var typ uint32
+	switch m.Type() {
+	case fs.ModeDir:
+		typ = 0o040000
+	case 0:
+		typ = 0o100000
+	case fs.ModeSymlink:
+		typ = 0o120000
+	case fs.ModeDevice | fs.ModeCharDevice:
+		typ = 0o020000
+	case fs.ModeDevice:
+		typ = 0o060000
+	case fs.ModeNamedPipe:
+		typ = 0o010000
+	case fs.ModeSocket:
+		typ = 0o140000
+	}
+	out := typ | uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		out |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		out |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		out |= 0o1000
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package ninep_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/CalebQ42/squashfs"
+	"github.com/CalebQ42/squashfs/internal/testimage"
+	"github.com/CalebQ42/squashfs/ninep"
+)
+
+func Test9P(t *testing.T) {
+	rdr, err := squashfs.NewReaderFromBytes(testimage.Build(testimage.Dir("",
+		testimage.Dir("dir", testimage.WithXattrs(testimage.File("file", []byte("hello 9p")), "user.a", "1")),
+		testimage.Symlink("link", "dir/file"),
+		testimage.File("..data", []byte("dots")),
+	), testimage.Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go ninep.Serve(rdr, server)
+	// Sends a message and returns the reply's type and body.
+	call := func(typ uint8, body ...any) (uint8, []byte) {
+		var msg []byte
+		for _, v := range body {
+			switch v := v.(type) {
+			case uint16:
+				msg = binary.LittleEndian.AppendUint16(msg, v)
+			case uint32:
+				msg = binary.LittleEndian.AppendUint32(msg, v)
+			case uint64:
+				msg = binary.LittleEndian.AppendUint64(msg, v)
+			case string:
+				msg = binary.LittleEndian.AppendUint16(msg, uint16(len(v)))
+				msg = append(msg, v...)
+			}
+		}
+		hdr := binary.LittleEndian.AppendUint32(nil, uint32(7+len(msg)))
+		hdr = append(hdr, typ, 1, 0)
+		if _, err := client.Write(append(hdr, msg...)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, hdr); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, binary.LittleEndian.Uint32(hdr)-7)
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatal(err)
+		}
+		return hdr[4], reply
+	}
+	// The client's msize is never raised.
+	if typ, body := call(100, uint32(2048), "9P2000.L"); typ != 101 || binary.LittleEndian.Uint32(body) != 2048 ||
+		!strings.HasSuffix(string(body), "9P2000.L") {
+		t.Fatal("Tversion got", typ, body)
+	}
+	if typ, _ := call(104, uint32(0), ^uint32(0), "user", "", ^uint32(0)); typ != 105 {
+		t.Fatal("Tattach got", typ)
+	}
+	if typ, body := call(110, uint32(0), uint32(1), uint16(2), "dir", "file"); typ != 111 || binary.LittleEndian.Uint16(body) != 2 {
+		t.Fatal("Twalk got", typ, body)
+	}
+	if typ, _ := call(12, uint32(1), uint32(0)); typ != 13 {
+		t.Fatal("Tlopen got", typ)
+	}
+	if typ, body := call(116, uint32(1), uint64(6), uint32(100)); typ != 117 || string(body[4:]) != "9p" {
+		t.Fatalf("Tread got %d %q", typ, body)
+	}
+	if typ, body := call(24, uint32(1), uint64(0x7FF)); typ != 25 || binary.LittleEndian.Uint32(body[21:]) != 0o100644 ||
+		binary.LittleEndian.Uint64(body[49:]) != 8 {
+		t.Fatal("Tgetattr got", typ, body)
+	}
+	if typ, body := call(30, uint32(1), uint32(2), "user.a"); typ != 31 || binary.LittleEndian.Uint64(body) != 1 {
+		t.Fatal("Txattrwalk got", typ, body)
+	}
+	if typ, body := call(116, uint32(2), uint64(0), uint32(100)); typ != 117 || string(body[4:]) != "1" {
+		t.Fatalf("Tread of xattr got %d %q", typ, body)
+	}
+	if typ, body := call(40, uint32(0), uint64(0), uint32(1000)); typ != 41 ||
+		!strings.Contains(string(body), "dir") || !strings.Contains(string(body), "link") {
+		t.Fatalf("Treaddir got %d %q", typ, body)
+	}
+	if typ, _ := call(110, uint32(0), uint32(3), uint16(1), "link"); typ != 111 {
+		t.Fatal("Twalk got", typ)
+	}
+	if typ, body := call(22, uint32(3)); typ != 23 || string(body[2:]) != "dir/file" {
+		t.Fatalf("Treadlink got %d %q", typ, body)
+	}
+	if typ, body := call(110, uint32(0), uint32(4), uint16(1), "..data"); typ != 111 || binary.LittleEndian.Uint16(body) != 1 {
+		t.Fatal("Twalk got", typ, body)
+	}
+	if typ, body := call(116, uint32(4), uint64(0), uint32(100)); typ != 117 || string(body[4:]) != "dots" {
+		t.Fatalf("Tread got %d %q", typ, body)
+	}
+	if typ, body := call(110, uint32(0), uint32(5), uint16(1), "missing"); typ != 7 || binary.LittleEndian.Uint32(body) != 2 {
+		t.Fatal("expected ENOENT, got", typ, body)
+	}
+	// Tmkdir
+	if typ, body := call(72, uint32(0), "new", uint32(0o755), uint32(0)); typ != 7 || binary.LittleEndian.Uint32(body) != 30 {
+		t.Fatal("expected EROFS, got", typ, body)
+	}
+	if typ, _ := call(120, uint32(1)); typ != 121 {
+		t.Fatal("Tclunk got", typ)
+	}
+	if typ, body := call(116, uint32(1), uint64(0), uint32(1)); typ != 7 || binary.LittleEndian.Uint32(body) != 9 {
+		t.Fatal("expected EBADF, got", typ, body)
+	}
+}
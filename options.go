@@ -0,0 +1,81 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"log"
+)
+
+// Returned by NewReaderWithOptions when the archive's block size is larger than Options.MaxBlockSize.
+var ErrBlockSizeLimit = errors.New("archive's block size is larger than the allowed maximum")
+
+// Options used to open an archive with NewReaderWithOptions. The zero value uses the same defaults as NewReader.
+type Options struct {
+	// Where problems that don't prevent reading the archive are logged, such as the archive being truncated (ErrTruncated)
+	// or the superblock having unknown flags set.
+	// If nil, problems are ignored. Not used if StrictMode is set.
+	Logger *log.Logger
+	// How many decompressed fragment blocks are cached. If 0, squashfslow.DefaultFragCacheSize is used. If negative, the cache is disabled.
+	CacheSize int
+	// The maximum number of goroutines used to decompress a single file with File.WriteTo. If 0, runtime.NumCPU() is used.
+	Workers uint16
+	// The largest block size allowed. Archives with larger blocks return ErrBlockSizeLimit.
+	// Useful with untrusted archives, as each goroutine decompressing a file holds at least one block. If 0, any valid block size is allowed.
+	MaxBlockSize uint32
+	// Return an error for problems that would otherwise be logged.
+	StrictMode bool
+	// How many data blocks are decompressed in the background ahead of the current one when using File.Read.
+	// If 0, one block is read ahead. If negative, readahead is disabled. See Reader.SetReadahead.
+	Readahead int
+	// Open the archive in low memory mode. See Reader.SetLowMemory.
+	LowMemory bool
+	// Decompress the archive's metadata and keep it in memory. Can't be used with LowMemory. See Reader.PreloadMetadata.
+	PreloadMetadata bool
+}
+
+// Creates a Reader from r, configured using op. The archive must start at the beginning of r.
+func NewReaderWithOptions(r io.ReaderAt, op Options) (*Reader, error) {
+	if op.LowMemory && op.PreloadMetadata {
+		return nil, errors.New("LowMemory and PreloadMetadata can't both be set")
+	}
+	out, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if op.MaxBlockSize != 0 && out.Low.Superblock.BlockSize > op.MaxBlockSize {
+		return nil, ErrBlockSizeLimit
+	}
+	for _, problem := range out.problems() {
+		if op.StrictMode {
+			return nil, problem
+		}
+		if op.Logger != nil {
+			op.Logger.Println(problem)
+		}
+	}
+	out.Low.SetFragCacheSize(op.CacheSize)
+	out.Low.SetGoroutineLimit(op.Workers)
+	if op.Readahead != 0 {
+		out.Low.SetReadahead(op.Readahead)
+	}
+	// After the other settings, so they're restored if low memory mode is disabled.
+	out.Low.SetLowMemory(op.LowMemory)
+	if op.PreloadMetadata {
+		if err = out.Low.PreloadMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Returns problems with the archive that don't prevent it from being opened, but might cause errors later.
+func (r *Reader) problems() (out []error) {
+	sb := r.Low.Superblock
+	if size, ok := r.ContainerSize(); ok && size < int64(sb.Size) {
+		out = append(out, ErrTruncated)
+	}
+	if sb.Flags&^0xFFF != 0 {
+		out = append(out, errors.New("superblock has unknown flags set"))
+	}
+	return out
+}
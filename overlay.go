@@ -0,0 +1,255 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueName     = ".wh..wh..opq"
+)
+
+// Overlay is a fs.FS that stacks multiple archives, such as a live distro's base image and its updates.
+// Paths are resolved from the top-most layer down, with the first match used. Directories are merged.
+//
+// Both overlayfs and aufs style whiteouts are supported: a character device with device number 0/0, or a ".wh.<name>" file,
+// hides name in lower layers, and a directory containing ".wh..wh..opq" hides the contents of the directory in lower layers.
+// Whiteouts aren't shown in directory listings. overlayfs' opaque directory xattr isn't supported.
+//
+// Implements fs.ReadDirFS, fs.ReadFileFS, and fs.StatFS.
+type Overlay struct {
+	layers []*FS
+}
+
+// Creates an Overlay with upper on top of lower. Lower layers are given from top to bottom.
+func OverlayFS(upper *Reader, lower ...*Reader) *Overlay {
+	out := &Overlay{
+		layers: []*FS{upper.Root()},
+	}
+	for _, l := range lower {
+		out.layers = append(out.layers, l.Root())
+	}
+	return out
+}
+
+// Opens the file at name from the top-most layer it's in. If name is a directory, ReadDir returns the merged contents of all layers.
+func (o *Overlay) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("open", name, fs.ErrInvalid)
+	}
+	files, err := o.resolve(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if !files[0].IsDir() {
+		return files[0], nil
+	}
+	entries, err := o.readDir(files)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayDir{File: files[0], entries: entries}, nil
+}
+
+// Returns the merged contents of the directory at name.
+func (o *Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("readdir", name, fs.ErrInvalid)
+	}
+	files, err := o.resolve(name)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if !files[0].IsDir() {
+		return nil, pathError("readdir", name, ErrNotDir)
+	}
+	return o.readDir(files)
+}
+
+// Returns the contents of the file at name from the top-most layer it's in.
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("readfile", name, fs.ErrInvalid)
+	}
+	files, err := o.resolve(name)
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	if !files[0].IsRegular() {
+		return nil, fs.ErrInvalid
+	}
+	return io.ReadAll(files[0])
+}
+
+// Returns the fs.FileInfo of the file at name from the top-most layer it's in.
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, pathError("stat", name, fs.ErrInvalid)
+	}
+	files, err := o.resolve(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return files[0].Stat()
+}
+
+// Returns the file at name from each layer where it's visible, top-most first.
+// Lower layers are only included while the files are directories that aren't opaque.
+func (o *Overlay) resolve(name string) ([]*File, error) {
+	if name == "." {
+		out := make([]*File, 0, len(o.layers))
+		for _, l := range o.layers {
+			out = append(out, l.File())
+			if isOpaque(out[len(out)-1]) {
+				break
+			}
+		}
+		return out, nil
+	}
+	base := path.Base(name)
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		return nil, fs.ErrNotExist
+	}
+	parents, err := o.resolve(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	var out []*File
+	for _, p := range parents {
+		if !p.IsDir() {
+			break
+		}
+		dir, err := p.FS()
+		if err != nil {
+			return nil, err
+		}
+		fil, err := dir.open(base)
+		if err == nil {
+			if isWhiteout(fil) || (len(out) > 0 && !fil.IsDir()) {
+				break
+			}
+			out = append(out, fil)
+			if !fil.IsDir() || isOpaque(fil) {
+				break
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if _, err = dir.entryIndex(whiteoutPrefix + base); err == nil {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return out, nil
+}
+
+// Returns the merged entries of the given directories, sorted by name.
+func (o *Overlay) readDir(dirs []*File) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var out []fs.DirEntry
+	for _, d := range dirs {
+		dir, err := d.FS()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := d.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		var hidden []string
+		for _, e := range entries {
+			name := e.Name()
+			if seen[name] {
+				continue
+			}
+			if strings.HasPrefix(name, whiteoutPrefix) {
+				if name != opaqueName {
+					hidden = append(hidden, strings.TrimPrefix(name, whiteoutPrefix))
+				}
+				continue
+			}
+			seen[name] = true
+			if e.Type()&fs.ModeCharDevice != 0 {
+				fil, err := dir.open(name)
+				if err != nil {
+					return nil, err
+				}
+				if isWhiteout(fil) {
+					continue
+				}
+			}
+			out = append(out, e)
+		}
+		// Whiteouts only hide entries in lower layers.
+		for _, h := range hidden {
+			seen[h] = true
+		}
+	}
+	slices.SortFunc(out, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return out, nil
+}
+
+// Returns whether f is an overlayfs whiteout (a character device with device number 0/0).
+func isWhiteout(f *File) bool {
+	if f.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	maj, min := f.deviceDevices()
+	return maj == 0 && min == 0
+}
+
+// Returns whether the directory f hides the contents of lower layers.
+func isOpaque(f *File) bool {
+	if !f.IsDir() {
+		return false
+	}
+	dir, err := f.FS()
+	if err != nil {
+		return false
+	}
+	_, err = dir.entryIndex(opaqueName)
+	return err == nil
+}
+
+func pathError(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	return &fs.PathError{
+		Op:   op,
+		Path: name,
+		Err:  err,
+	}
+}
+
+// A directory in an Overlay. ReadDir returns the merged contents of all layers.
+type overlayDir struct {
+	*File
+	entries []fs.DirEntry
+	read    int
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		out := d.entries[d.read:]
+		d.read = len(d.entries)
+		return out, nil
+	}
+	if d.read >= len(d.entries) {
+		return nil, io.EOF
+	}
+	end := min(d.read+n, len(d.entries))
+	out := d.entries[d.read:end]
+	d.read = end
+	return out, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package squashfs
+
+import "io/fs"
+
+func diskOwner(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package squashfs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Returns the owner of a file on disk from its os.Lstat info.
+func diskOwner(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
@@ -1,24 +1,53 @@
 package squashfs
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/CalebQ42/squashfs/internal/mmap"
 	"github.com/CalebQ42/squashfs/internal/toreader"
 	squashfslow "github.com/CalebQ42/squashfs/low"
+	"github.com/CalebQ42/squashfs/low/directory"
+	"github.com/CalebQ42/squashfs/low/inode"
 )
 
+// Reader is an opened squashfs archive. Its embedded FS is the archive's root directory.
+// A Reader is safe for concurrent use, but must not be copied. Use Clone to get an independent Reader.
 type Reader struct {
 	*FS
-	Low squashfslow.Reader
+	closer io.Closer
+	src    io.ReaderAt
+	Low    squashfslow.Reader
 }
 
+// Returned when using a Reader after it's closed.
+var ErrReaderClosed = squashfslow.ErrorReaderClosed
+
+// Returned when the archive's superblock doesn't make sense, such as a table starting past the end of the archive.
+// Use errors.As with a squashfslow.SuperblockError for the reason.
+var ErrInvalidSuperblock = squashfslow.ErrorSuperblock
+
+// Matched, with errors.Is, by errors caused by a corrupted archive, such as an inode or table with an impossible size or offset.
+// Invalid superblocks match it too.
+var ErrCorrupt = squashfslow.ErrorCorrupt
+
+// Returned by NewReaderSize when the archive is larger than the available data.
+var ErrTruncated = errors.New("archive is larger than the available data. possibly truncated")
+
+// Creates a Reader from any io.ReaderAt, such as an *os.File, a memory mapped region, a block device, or custom storage.
+// The archive must start at the beginning of r.
 func NewReader(r io.ReaderAt) (*Reader, error) {
 	rdr, err := squashfslow.NewReader(r)
 	if err != nil {
 		return nil, err
 	}
 	out := &Reader{
+		src: r,
 		Low: *rdr,
 	}
 	out.FS = &FS{
@@ -28,10 +57,330 @@ func NewReader(r io.ReaderAt) (*Reader, error) {
 	return out, nil
 }
 
-func NewReaderAtOffset(r io.ReaderAt, offset int64) (*Reader, error) {
+// Creates a Reader that memory maps f and reads from the mapping, avoiding a syscall for every read.
+// Useful for metadata heavy workloads, such as serving many small files.
+// f can be closed once the Reader is created. Call Close to release the mapping.
+// Returns an error on platforms that don't support memory mapping.
+func NewMmapReader(f *os.File) (*Reader, error) {
+	m, err := mmap.Map(f)
+	if err != nil {
+		return nil, err
+	}
+	out, err := NewReader(m)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	out.closer = m
+	return out, nil
+}
+
+// Same as NewReader, but reads from r are limited to size bytes. Useful when r has more data after the archive,
+// or might not have the whole archive. Returns ErrTruncated if the archive is larger than size.
+func NewReaderSize(r io.ReaderAt, size int64) (*Reader, error) {
+	out, err := NewReader(io.NewSectionReader(r, 0, size))
+	if out != nil && int64(out.Low.Superblock.Size) > size {
+		return nil, ErrTruncated
+	} else if err != nil {
+		// A truncated archive might fail before the size can be checked.
+		var used [8]byte
+		if _, sbErr := r.ReadAt(used[:], 40); sbErr == nil && int64(binary.LittleEndian.Uint64(used[:])) > size {
+			return nil, ErrTruncated
+		}
+	}
+	return out, err
+}
+
+// Creates a Reader from an archive held in memory, such as one from go:embed. b must not be modified while the Reader is in use.
+func NewReaderFromBytes(b []byte) (*Reader, error) {
+	return NewReader(bytes.NewReader(b))
+}
+
+// Opens the archive at the given path. Close closes the underlying file.
+func OpenFile(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	out.closer = f
+	return out, nil
+}
+
+// Creates a Reader from an archive that starts at offset in r, such as an AppImage or a firmware image with leading headers.
+// Use FindSuperblock to find the offset.
+func NewReaderAt(r io.ReaderAt, offset int64) (*Reader, error) {
 	return NewReader(toreader.NewOffsetReader(r, offset))
 }
 
+// Deprecated: Use NewReaderAt.
+func NewReaderAtOffset(r io.ReaderAt, offset int64) (*Reader, error) {
+	return NewReaderAt(r, offset)
+}
+
+// Returned by FindSuperblock when no superblock is found.
+var ErrNoSuperblock = errors.New("no squashfs superblock found")
+
+// Returns the offset of the first squashfs superblock in r, scanning until r returns an error (such as io.EOF).
+// Candidates must have the "hsqs" magic, version 4.0, a valid block size and block log, and a known compression type.
+// Returns ErrNoSuperblock if none are found. The result can be passed to NewReaderAt.
+func FindSuperblock(r io.ReaderAt) (int64, error) {
+	const chunk = 64 * 1024
+	magic := []byte("hsqs")
+	buf := make([]byte, chunk+superblockSize)
+	var pos int64
+	for {
+		n, err := r.ReadAt(buf, pos)
+		dat := buf[:n]
+		for i := 0; ; i++ {
+			ind := bytes.Index(dat[i:], magic)
+			if ind == -1 {
+				break
+			}
+			i += ind
+			if i+superblockSize > len(dat) {
+				// Not enough data to check. If there's more data it's checked with the next chunk.
+				break
+			}
+			if validSuperblock(dat[i : i+superblockSize]) {
+				return pos + int64(i), nil
+			}
+		}
+		if err != nil || n < len(buf) {
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			return 0, ErrNoSuperblock
+		}
+		pos += chunk
+	}
+}
+
+const superblockSize = 96
+
+func validSuperblock(sb []byte) bool {
+	blockSize := binary.LittleEndian.Uint32(sb[12:])
+	comp := binary.LittleEndian.Uint16(sb[20:])
+	blockLog := binary.LittleEndian.Uint16(sb[22:])
+	verMaj := binary.LittleEndian.Uint16(sb[28:])
+	verMin := binary.LittleEndian.Uint16(sb[30:])
+	return verMaj == 4 && verMin == 0 &&
+		blockSize >= 4096 && blockSize <= 1024*1024 && blockSize&(blockSize-1) == 0 &&
+		1<<blockLog == blockSize &&
+		comp >= 1 && comp <= 6
+}
+
+// Returns a copy of the archive's parsed superblock, including the block size, flags, table offsets, and inode count.
+func (r *Reader) Superblock() squashfslow.Superblock {
+	return r.Low.Superblock
+}
+
+// Returns the superblock's flags, describing how the archive was built, such as whether duplicates were removed.
+func (r *Reader) Flags() squashfslow.Flags {
+	return r.Low.Superblock.Flags
+}
+
+// Returns the size of the archive (the superblock's bytes_used). Any data after this, such as padding or an appended
+// signature, is ignored.
+func (r *Reader) ArchiveSize() int64 {
+	return int64(r.Low.Superblock.Size)
+}
+
+// Returns the size of the data the archive is read from, starting at the archive, if it's known (such as from an *os.File
+// or bytes.Reader). If it's larger than ArchiveSize, the archive is padded or has data appended.
+func (r *Reader) ContainerSize() (int64, bool) {
+	return toreader.Size(r.src)
+}
+
+// Returns a new Reader for the same archive with its own caches, tables, and Stats, sharing only the underlying io.ReaderAt.
+// Settings, such as the goroutine limit and case mode, are copied. Files opened from the clone are independent of r's.
+// The clone doesn't own any resources, so r must not be closed while the clone is in use.
+func (r *Reader) Clone() *Reader {
+	out := &Reader{
+		src: r.src,
+		Low: *r.Low.Clone(),
+	}
+	out.FS = &FS{
+		d:        out.Low.Root,
+		r:        out,
+		caseMode: r.FS.caseMode,
+	}
+	return out
+}
+
+// Returns the root directory of the archive.
+func (r *Reader) Root() *FS {
+	return r.FS
+}
+
+// Returns whether a and b are the same file (share an inode), such as when they are hard links. a and b must both be from this archive.
+// Shadows FS.SameFile, which compares fs.FileInfo values. Use r.Root().SameFile for those.
+func (r *Reader) SameFile(a, b *File) bool {
+	return a.InodeNum() == b.InodeNum()
+}
+
+// Returns the canonical path of every file with more than one hard link, keyed by inode number. The canonical path is the
+// first one found when walking the archive, so converters can write the file's data there and link every other path to it.
+func (r *Reader) CanonicalPaths() (map[uint32]string, error) {
+	links, err := r.HardLinks()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32]string, len(links))
+	for num, paths := range links {
+		out[num] = paths[0]
+	}
+	return out, nil
+}
+
+// Returns the archive's export table, mapping inode numbers to inode references. The reference of inode number n is at index n-1.
+// Returns squashfslow.ErrorNotExportable if the archive doesn't have one.
+func (r *Reader) ExportTable() ([]squashfslow.MetaRef, error) {
+	return r.Low.ExportTable()
+}
+
+// Returns the inode reference (for use with squashfslow.Reader.InodeFromRef) of the inode with the given number using the archive's export table.
+// Returns squashfslow.ErrorNotExportable if the archive doesn't have one.
+func (r *Reader) InodeRef(n uint32) (squashfslow.MetaRef, error) {
+	return r.Low.InodeRef(n)
+}
+
+// Opens the file with the given inode number using the archive's export table. Useful for resolving NFS style file handles.
+// Returns squashfslow.ErrorNotExportable if the archive doesn't have one.
+// Inodes don't store their name or location, so the returned File is named after its inode number (unless it's the root)
+// and relative symlinks can't be resolved.
+func (r *Reader) OpenInode(n uint32) (*File, error) {
+	if n == r.FS.d.Inode.Num {
+		return r.FS.File(), nil
+	}
+	ref, err := r.Low.InodeRef(n)
+	if err != nil {
+		return nil, err
+	}
+	return r.FileFromRef(ref)
+}
+
+// Opens the file with the given inode reference, such as from Inodes or InodeRef, without needing its path.
+// ref's block is the location of the inode's metadata block relative to the inode table.
+// Like OpenInode, the File is named after its inode number (unless it's the root) and relative symlinks can't be resolved.
+func (r *Reader) FileFromRef(ref squashfslow.MetaRef) (*File, error) {
+	if ref == r.Low.Superblock.RootInodeRef {
+		return r.FS.File(), nil
+	}
+	i, err := r.Low.InodeFromRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	return r.FileFromBase(r.Low.BaseFromInode(i, strconv.FormatUint(uint64(i.Num), 10)), nil), nil
+}
+
+// Calls fn with every inode in the archive, in the order they're stored, without going through the directory tree.
+// ref can be passed to squashfslow.Reader.InodeFromRef. If fn returns an error, iteration stops and the error is returned, unless it's fs.SkipAll.
+func (r *Reader) Inodes(fn func(ref squashfslow.MetaRef, i inode.Inode) error) error {
+	return r.Low.Inodes(fn)
+}
+
+// Calls fn with every entry in the directory table, in the order they're stored, along with the header the entry belongs to
+// and the header's location in the directory table. The table is read without the inodes, so entries can be checked against
+// the inodes they point to even if some inodes are unreadable. If fn returns an error, iteration stops and the error is
+// returned, unless it's fs.SkipAll.
+func (r *Reader) DirectoryTable(fn func(header squashfslow.MetaRef, h directory.Header, e directory.Entry) error) error {
+	return r.Low.DirectoryTable(fn)
+}
+
+// Returns the archive's fragment table. Each entry is a fragment block holding the ends of files, and small files, packed together.
+func (r *Reader) Fragments() ([]squashfslow.FragmentEntry, error) {
+	return r.Low.Fragments()
+}
+
+// Returns the archive's id table, the list of uids and gids used by its files. See File.Uid and File.Gid.
+func (r *Reader) IDs() ([]uint32, error) {
+	return r.Low.IDs()
+}
+
+// Returns the archive's xattr id table. Each entry is a set of xattrs shared by one or more files.
+// If the archive doesn't have xattrs, returns nil.
+func (r *Reader) XattrTable() ([]squashfslow.XattrID, error) {
+	return r.Low.XattrTable()
+}
+
+// Returns a reader of decompressed metadata starting at ref, for inspecting tables the rest of the API doesn't expose.
+// ref's block is the location of a metadata block relative to the start of the archive.
+func (r *Reader) MetadataReader(ref squashfslow.MetaRef) (io.Reader, error) {
+	return r.Low.MetadataReader(ref)
+}
+
+// Returns where each part of the archive (superblock, data, inode table, directory table, and lookup tables) is stored, sorted by location.
+// Useful for analyzing an archive's size and for triaging corruption.
+func (r *Reader) Layout() ([]squashfslow.Region, error) {
+	return r.Low.Layout()
+}
+
+// Returns the compression options stored after the superblock, as raw bytes. If the archive doesn't have compression options, returns nil.
+func (r *Reader) CompressionOptionsRaw() ([]byte, error) {
+	return r.Low.CompressionOptionsRaw()
+}
+
+// Returns the parsed compression options, such as a squashfslow.ZlibOptions for a zlib archive. If the archive doesn't have
+// compression options, or their format isn't known, returns nil.
+func (r *Reader) CompressionOptions() (any, error) {
+	return r.Low.CompressionOptions()
+}
+
+// Returns a snapshot of the Reader's counters, such as bytes read from the archive and blocks decompressed.
+// Useful for monitoring. Counters start when the Reader is created.
+func (r *Reader) Stats() squashfslow.Stats {
+	return r.Low.Stats()
+}
+
+// Enables or disables low memory mode. In low memory mode nothing is cached, there's no readahead, and files are
+// decompressed one block at a time, including with WriteTo and during extraction (ExtractionOptions.ExtractionRoutines is ignored).
+//
+// In low memory mode, an open File uses at most about two data blocks (the compressed and decompressed block, see
+// squashfslow.Reader.Superblock.BlockSize) plus an 8KiB metadata block while opening files.
+// Disabling low memory mode restores the settings from before it was enabled. Should be called before the Reader is used.
+func (r *Reader) SetLowMemory(enabled bool) {
+	r.Low.SetLowMemory(enabled)
+}
+
+// Decompresses the archive's metadata (inodes, directories, and the fragment, id, and export tables) and keeps it in memory.
+// Afterwards, opening and stating files doesn't need to read from the archive, at the cost of holding the metadata in memory.
+// Should be called before the Reader is used.
+func (r *Reader) PreloadMetadata() error {
+	return r.Low.PreloadMetadata()
+}
+
+// Set how many data blocks are decompressed in the background ahead of the current one when using File.Read.
+// Defaults to 1. Set to 0 to disable readahead. Only applies to files opened afterwards.
+func (r *Reader) SetReadahead(blocks int) {
+	r.Low.SetReadahead(blocks)
+}
+
+// Set the maximum number of goroutines used to decompress a single file with File.WriteTo.
+// Lower values reduce memory spikes, as each goroutine holds a decompressed block.
+// If limit is 0, runtime.NumCPU() is used. Only applies to files opened afterwards.
+func (r *Reader) SetGoroutineLimit(limit uint16) {
+	r.Low.SetGoroutineLimit(limit)
+}
+
 func (r *Reader) ModTime() time.Time {
-	return time.Unix(int64(r.Low.Superblock.ModTime), 0)
+	return time.Unix(int64(r.Low.Superblock.ModTime), 0).UTC()
+}
+
+// Releases resources held by the Reader, such as its caches, a memory mapping created by NewMmapReader, or the file opened by OpenFile.
+// Afterwards, anything that needs to read from the archive, including opening files, returns ErrReaderClosed.
+// Clones aren't closed, but their underlying data might be.
+func (r *Reader) Close() error {
+	// Waits for in progress reads, such as readahead, so the mapping or file isn't closed while it's being read.
+	r.Low.Close()
+	if r.closer == nil {
+		return nil
+	}
+	err := r.closer.Close()
+	r.closer = nil
+	return err
 }
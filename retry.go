@@ -0,0 +1,87 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+// How failed reads are retried by WithRetries.
+type RetryPolicy struct {
+	// Returns whether a read that failed with err should be retried. If nil, all errors except io.EOF are retried.
+	Retryable func(err error) bool
+	// The maximum number of attempts for a read, including the first. Values less than 1 are treated as 1.
+	Attempts int
+	// How long to wait after the first failed attempt. Doubled after each failure, up to MaxDelay.
+	Delay time.Duration
+	// The maximum time to wait between attempts. If 0, there's no maximum.
+	MaxDelay time.Duration
+}
+
+// A RetryPolicy with 5 attempts, starting with a 100ms delay and waiting at most 2s between attempts.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 5,
+	Delay:    100 * time.Millisecond,
+	MaxDelay: 2 * time.Second,
+}
+
+// Wraps r so failed reads are retried according to policy. Useful when r is backed by object storage or a network block device,
+// where reads can fail temporarily. Partial reads are resumed from where they stopped.
+// Pass the result to NewReader (or similar) so all reads from the archive are retried.
+func WithRetries(r io.ReaderAt, policy RetryPolicy) io.ReaderAt {
+	return &retryReaderAt{r: r, policy: policy}
+}
+
+type retryReaderAt struct {
+	r      io.ReaderAt
+	policy RetryPolicy
+}
+
+func (r *retryReaderAt) retryable(err error) bool {
+	if r.policy.Retryable != nil {
+		return r.policy.Retryable(err)
+	}
+	return !errors.Is(err, io.EOF)
+}
+
+func (r *retryReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	delay := r.policy.Delay
+	for attempt := 1; ; attempt++ {
+		var read int
+		read, err = r.r.ReadAt(p[n:], off+int64(n))
+		n += read
+		if n == len(p) {
+			if errors.Is(err, io.EOF) {
+				// The read finished exactly at the end of the data.
+				return n, err
+			}
+			return n, nil
+		}
+		if err == nil {
+			// A short read without an error isn't allowed by io.ReaderAt, so resume it.
+			if attempt >= r.policy.Attempts {
+				return n, io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		if attempt >= r.policy.Attempts || !r.retryable(err) {
+			return n, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if r.policy.MaxDelay > 0 {
+			delay = min(delay, r.policy.MaxDelay)
+		}
+	}
+}
+
+// Returns the size of the underlying io.ReaderAt, or -1 if it's unknown.
+func (r *retryReaderAt) Size() int64 {
+	size, ok := toreader.Size(r.r)
+	if !ok {
+		return -1
+	}
+	return size
+}
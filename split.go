@@ -0,0 +1,111 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+// Returns an io.ReaderAt that reads parts, in order, as if they were a single file. Useful for archives split into multiple files.
+// The size of each part must be known, either with a Size method (such as bytes.Reader and io.SectionReader) or a Stat method (such as os.File).
+func ConcatReaderAt(parts ...io.ReaderAt) (io.ReaderAt, error) {
+	out := &concatReaderAt{
+		parts: parts,
+		ends:  make([]int64, len(parts)),
+	}
+	var end int64
+	for i, p := range parts {
+		size, ok := toreader.Size(p)
+		if !ok {
+			return nil, errors.New("size of part " + strconv.Itoa(i) + " is unknown")
+		}
+		end += size
+		out.ends[i] = end
+	}
+	return out, nil
+}
+
+// Opens an archive split across multiple files, such as "image.sfs.000", "image.sfs.001", and so on. paths must be in order.
+// Close closes all the files.
+func OpenSplitFiles(paths ...string) (*Reader, error) {
+	files := make(multiCloser, 0, len(paths))
+	parts := make([]io.ReaderAt, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files = append(files, f)
+		parts = append(parts, f)
+	}
+	r, err := ConcatReaderAt(parts...)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	out, err := NewReader(r)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	out.closer = files
+	return out, nil
+}
+
+type concatReaderAt struct {
+	parts []io.ReaderAt
+	ends  []int64 // The offset each part ends at.
+}
+
+func (c *concatReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	i := sort.Search(len(c.ends), func(i int) bool { return c.ends[i] > off })
+	for n < len(p) && i < len(c.parts) {
+		pos := off + int64(n)
+		start := pos
+		if i > 0 {
+			start -= c.ends[i-1]
+		}
+		toRead := min(int64(len(p)-n), c.ends[i]-pos)
+		var read int
+		read, err = c.parts[i].ReadAt(p[n:n+int(toRead)], start)
+		n += read
+		if read < int(toRead) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns the combined size of all parts.
+func (c *concatReaderAt) Size() int64 {
+	if len(c.ends) == 0 {
+		return 0
+	}
+	return c.ends[len(c.ends)-1]
+}
+
+// Closes all closers, returning any errors joined together.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var errs []error
+	for _, c := range m {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package squashfs_test
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func TestExtractXattrs(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		withXattrs(testFile("file", []byte("data")), "user.a", "1", "trusted.b", "2"),
+	), testImageOptions{})
+	dir := t.TempDir()
+	probe := filepath.Join(dir, "probe")
+	if err := syscall.Mkdir(probe, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(probe, "user.probe", []byte("1"), 0); err != nil {
+		t.Skip("user xattrs aren't supported here:", err)
+	}
+	op := squashfs.DefaultOptions()
+	op.Xattrs = squashfs.XattrUser
+	err := rdr.ExtractWithOptions(filepath.Join(dir, "out"), op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "out", "file")
+	buf := make([]byte, 16)
+	n, err := syscall.Getxattr(path, "user.a", buf)
+	if err != nil || string(buf[:n]) != "1" {
+		t.Fatalf("got user.a %q: %v", buf[:n], err)
+	}
+	if _, err = syscall.Getxattr(path, "trusted.b", buf); !errors.Is(err, syscall.ENODATA) {
+		t.Fatal("trusted.b shouldn't be applied:", err)
+	}
+}
@@ -3,18 +3,39 @@ package squashfs_test
 //Actually proper tests go here.
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"log"
+	"maps"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 
 	"github.com/CalebQ42/squashfs"
+	"github.com/CalebQ42/squashfs/internal/mmap"
+	squashfslow "github.com/CalebQ42/squashfs/low"
+	"github.com/CalebQ42/squashfs/low/directory"
+	"github.com/CalebQ42/squashfs/low/inode"
 )
 
 const (
@@ -192,3 +213,2845 @@ func TestSingleFile(t *testing.T) {
 	}
 	t.Fatal("HI")
 }
+
+func TestCaseInsensitive(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("Docs", testFile("ReadMe.txt", []byte("hello"))),
+		testFile("a.txt", []byte("lower")),
+		testFile("A.txt", []byte("upper")),
+	), testImageOptions{})
+	if _, err := rdr.Open("docs/readme.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected fs.ErrNotExist, got", err)
+	}
+	rdr.SetCaseMode(squashfs.CaseInsensitive)
+	dat, err := rdr.ReadFile("docs/README.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "hello" {
+		t.Fatal("wrong contents:", string(dat))
+	}
+	dat, err = rdr.ReadFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "lower" {
+		t.Fatal("exact match not preferred, got:", string(dat))
+	}
+	rdr.SetCaseMode(squashfs.CaseInsensitiveStrict)
+	if _, err = rdr.Open("a.TXT"); !errors.Is(err, squashfs.ErrAmbiguousName) {
+		t.Fatal("expected ErrAmbiguousName, got", err)
+	}
+}
+
+func TestPathNormalization(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("sub", testSymlink("up", "../a.txt")),
+		testFile("a.txt", []byte("a")),
+	), testImageOptions{})
+	for _, name := range []string{".", "", "./", "a.txt", "./a.txt", "sub/", "./sub/./", "sub/../a.txt"} {
+		if _, err := rdr.Root().Open(name); err != nil {
+			t.Errorf("open %q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"/a.txt", "../a.txt", "sub/../../a.txt"} {
+		if _, err := rdr.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("open %q: expected fs.ErrInvalid, got %v", name, err)
+		}
+	}
+	stat, err := rdr.Stat(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name() != "." || !stat.IsDir() {
+		t.Fatal("bad root stat:", stat.Name(), stat.Mode())
+	}
+	sub, err := rdr.OpenDir("sub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := sub.OpenFile("up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.GetSymlinkFile() == nil {
+		t.Fatal("failed to resolve symlink to parent directory")
+	}
+	if _, err = rdr.OpenDir("a.txt"); !errors.Is(err, squashfs.ErrNotDir) {
+		t.Fatal("expected ErrNotDir, got", err)
+	}
+	_, err = rdr.Sub("a.txt")
+	if pathErr, ok := err.(*fs.PathError); !ok || pathErr.Op != "sub" || !errors.Is(err, squashfs.ErrNotDir) {
+		t.Fatal("expected a sub PathError with ErrNotDir, got", err)
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	target := testFile("target", []byte("linked"))
+	rdr := openTestImage(t, testDir("",
+		target,
+		testDir("sub", testLink("link", target)),
+		testFile("other", []byte("other")),
+	), testImageOptions{})
+	targetStat, err := rdr.Stat("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkStat, err := rdr.Stat("sub/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherStat, err := rdr.Stat("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rdr.Root().SameFile(targetStat, linkStat) {
+		t.Fatal("hard links not reported as the same file")
+	}
+	if rdr.Root().SameFile(targetStat, otherStat) {
+		t.Fatal("different files reported as the same file")
+	}
+	f, err := rdr.OpenFile("sub/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := rdr.OpenFile("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.InodeNum() != g.InodeNum() {
+		t.Fatal("hard links have different inode numbers")
+	}
+	if !rdr.SameFile(f, g) {
+		t.Fatal("hard link files not reported as the same file")
+	}
+	sys, ok := linkStat.Sys().(*squashfs.Stat)
+	if !ok || sys.Inode != g.InodeNum() || sys.Nlink != 2 {
+		t.Fatalf("unexpected Sys: %+v", linkStat.Sys())
+	}
+	other, err := rdr.OpenFile("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdr.SameFile(g, other) {
+		t.Fatal("different files reported as the same file")
+	}
+	// Basic file inodes don't store a link count.
+	if g.NLink() != 2 || other.NLink() != 1 || otherStat.Sys().(*squashfs.Stat).Nlink != 1 {
+		t.Fatal("wrong link counts:", g.NLink(), other.NLink())
+	}
+	// "." and "..", plus sub's "..".
+	if rdr.File().NLink() != 3 {
+		t.Fatal("root has", rdr.File().NLink(), "links")
+	}
+	links, err := rdr.HardLinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || !slices.Equal(links[g.InodeNum()], []string{"sub/link", "target"}) {
+		t.Fatal("unexpected hard links:", links)
+	}
+	canon, err := rdr.CanonicalPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(canon) != 1 || canon[g.InodeNum()] != "sub/link" {
+		t.Fatal("unexpected canonical paths:", canon)
+	}
+}
+
+func TestSpecialModes(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		&testNode{name: "block", mode: fs.ModeDevice | 0660, rdev: 8<<8 | 1},
+		&testNode{name: "char", mode: fs.ModeDevice | fs.ModeCharDevice | 0620},
+		// Major 259, minor 300, which needs the extended minor bits.
+		&testNode{name: "nvme", mode: fs.ModeDevice | 0660, rdev: 300&0xff | 259<<8 | (300&^0xff)<<12},
+		&testNode{name: "fifo", mode: fs.ModeNamedPipe | 0644},
+		&testNode{name: "sock", mode: fs.ModeSocket | 0755},
+		&testNode{name: "suid", mode: fs.ModeSetuid | fs.ModeSetgid | 0755},
+		&testNode{name: "sticky", mode: fs.ModeDir | fs.ModeSticky | 0777},
+	), testImageOptions{})
+	want := map[string]fs.FileMode{
+		"block":  fs.ModeDevice | 0660,
+		"char":   fs.ModeDevice | fs.ModeCharDevice | 0620,
+		"nvme":   fs.ModeDevice | 0660,
+		"fifo":   fs.ModeNamedPipe | 0644,
+		"sock":   fs.ModeSocket | 0755,
+		"suid":   fs.ModeSetuid | fs.ModeSetgid | 0755,
+		"sticky": fs.ModeDir | fs.ModeSticky | 0777,
+	}
+	ents, err := rdr.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want[e.Name()] {
+			t.Errorf("%s: got mode %v, want %v", e.Name(), info.Mode(), want[e.Name()])
+		}
+		if e.Type() != want[e.Name()].Type() {
+			t.Errorf("%s: got type %v, want %v", e.Name(), e.Type(), want[e.Name()].Type())
+		}
+		sys := info.Sys().(*squashfs.Stat)
+		if e.Name() == "block" && sys.Rdev != 8<<8|1 {
+			t.Errorf("block: got rdev %d", sys.Rdev)
+		}
+		if maj, min := sys.Device(); e.Name() == "nvme" && (maj != 259 || min != 300) {
+			t.Errorf("nvme: got device %d, %d", maj, min)
+		}
+	}
+}
+
+func TestInodeType(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testFile("file", nil),
+		withXattrs(testFile("xattr", nil), "user.a", "b"),
+		&testNode{name: "char", mode: fs.ModeDevice | fs.ModeCharDevice | 0620},
+	), testImageOptions{})
+	want := map[string]inode.Type{
+		"file":  inode.Fil,
+		"xattr": inode.EFil,
+		"char":  inode.Char,
+	}
+	for name, typ := range want {
+		f, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.InodeType() != typ || f.InodeType().Basic() != typ.Basic() {
+			t.Errorf("%s: got type %v, want %v", name, f.InodeType(), typ)
+		}
+	}
+	if rdr.File().InodeType().Basic() != inode.Dir {
+		t.Fatal("root isn't a directory:", rdr.File().InodeType())
+	}
+	if inode.EFil.String() != "extended file" || inode.Type(99).String() != "unknown (99)" {
+		t.Fatal("unexpected type names:", inode.EFil, inode.Type(99))
+	}
+}
+
+func TestSizes(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testFile("file", []byte("12345")),
+		testSymlink("link", "file"),
+		testDir("empty"),
+		testDir("dir", testFile("a", nil)),
+	), testImageOptions{})
+	ents, err := rdr.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := make(map[string]int64)
+	for _, e := range ents {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[e.Name()] = info.Size()
+	}
+	// A directory's size is the size of its listing plus 3, like on a mounted archive.
+	if sizes["file"] != 5 || sizes["link"] != 4 || sizes["empty"] != 3 || sizes["dir"] <= 3 {
+		t.Fatal("unexpected sizes:", sizes)
+	}
+}
+
+func TestList(t *testing.T) {
+	suid := &testNode{name: "suid", mode: fs.ModeSetuid | 0755, data: []byte("abc"), uid: 1000, gid: 100, mtime: 1700000000}
+	rdr := openTestImage(t, testDir("",
+		suid,
+		&testNode{name: "tmp", mode: fs.ModeDir | fs.ModeSticky | 0776, mtime: 1700000000},
+		&testNode{name: "null", mode: fs.ModeDevice | fs.ModeCharDevice | 0666, rdev: 1<<8 | 3, mtime: 1700000000},
+		&testNode{name: "link", mode: fs.ModeSymlink | 0777, target: "suid", mtime: 1700000000},
+	), testImageOptions{})
+	var buf bytes.Buffer
+	if err := rdr.List(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "squashfs-root\nsquashfs-root/link\nsquashfs-root/null\nsquashfs-root/suid\nsquashfs-root/tmp\n" {
+		t.Fatal("got", buf.String())
+	}
+	buf.Reset()
+	if err := rdr.List(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	root, err := rdr.Stat(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Unix(1700000000, 0).Format("2006-01-02 15:04")
+	want := fmt.Sprintf("drwxr-xr-x 0/0 %23d %s squashfs-root\n", root.Size(), root.ModTime().Local().Format("2006-01-02 15:04")) +
+		"lrwxrwxrwx 0/0                       4 " + date + " squashfs-root/link -> suid\n" +
+		"crw-rw-rw- 0/0 " + strings.Repeat(" ", 20) + "  1,  3 " + date + " squashfs-root/null\n" +
+		"-rwsr-xr-x 1000/100                  3 " + date + " squashfs-root/suid\n" +
+		"drwxrwxrwT 0/0                       3 " + date + " squashfs-root/tmp\n"
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestListDir(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("a", testFile("b", nil), testDir("c", testFile("d", nil))),
+	), testImageOptions{})
+	dir, err := rdr.OpenDir("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = dir.ListWithOptions(&buf, &squashfs.ListOptions{NoRoot: true}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "b\nc\nc/d\n" {
+		t.Fatal("got", buf.String())
+	}
+	buf.Reset()
+	if err = dir.ListWithOptions(&buf, &squashfs.ListOptions{NoRoot: true, NoRecurse: true}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "b\nc\n" {
+		t.Fatal("got", buf.String())
+	}
+}
+
+func TestOwnerNames(t *testing.T) {
+	passwd := "root:x:0:0:root:/root:/bin/sh\n# comment\nalice:x:1000:1000::/home/alice:/bin/sh\nbad line\n"
+	group := "root:x:0:\nusers:x:100:alice\n"
+	f := testFile("f", []byte("f"))
+	f.uid, f.gid = 1000, 100
+	unknown := testFile("unknown", nil)
+	unknown.uid, unknown.gid = 1234, 4321
+	rdr := openTestImage(t, testDir("",
+		testDir("etc", testFile("passwd", []byte(passwd)), testFile("group", []byte(group))),
+		f, unknown,
+	), testImageOptions{})
+	names := func(path string) squashfs.IDNames {
+		fil, err := rdr.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fil.Close()
+		out, err := squashfs.ParseIDNames(fil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	users, groups := names("etc/passwd"), names("etc/group")
+	if len(users) != 2 || users.Name(1000) != "alice" || groups.Name(100) != "users" {
+		t.Fatal("got", users, groups)
+	}
+	var buf bytes.Buffer
+	err := rdr.ListWithOptions(&buf, &squashfs.ListOptions{LongFormat: true, UserName: users.Name, GroupName: groups.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "-rw-r--r-- alice/users ") || !strings.Contains(out, "-rw-r--r-- 1234/4321 ") || !strings.Contains(out, "drwxr-xr-x root/root ") {
+		t.Fatal("got", out)
+	}
+	var mapped atomic.Int32
+	op := squashfs.DefaultOptions()
+	op.MapOwner = func(uid, gid uint32) (int, int) {
+		mapped.Add(1)
+		return os.Getuid(), os.Getgid()
+	}
+	if err = rdr.ExtractWithOptions(t.TempDir(), op); err != nil {
+		t.Fatal(err)
+	}
+	// The root, etc, its two files, f, and unknown.
+	if mapped.Load() != 6 {
+		t.Fatal("MapOwner called", mapped.Load(), "times")
+	}
+}
+
+func TestCountRecursive(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testFile("a", []byte("12345")),
+		testDir("sub",
+			testFile("b", make([]byte, 10000)),
+			testSymlink("link", "../a"),
+			testDir("empty"),
+		),
+	), testImageOptions{})
+	entries, size, err := rdr.File().CountRecursive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 5 || size != 10005 {
+		t.Fatal("got", entries, "entries and", size, "bytes")
+	}
+	sub, err := rdr.OpenFile("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, size, err = sub.CountRecursive(); err != nil || entries != 3 || size != 10000 {
+		t.Fatal("got", entries, "entries and", size, "bytes:", err)
+	}
+	a, err := rdr.OpenFile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = a.CountRecursive(); err == nil {
+		t.Fatal("counted a regular file")
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("a", testFile("f.txt", []byte("f")), testSymlink("loop", "..")),
+		testSymlink("b", "a"),
+		testSymlink("broken", "nope"),
+	), testImageOptions{})
+	walk := func(op *squashfs.WalkOptions) (out []string) {
+		err := rdr.WalkWithOptions(func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			out = append(out, path+":"+d.Type().String())
+			return nil
+		}, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	got := walk(&squashfs.WalkOptions{})
+	want := []string{".:d---------", "a:d---------", "a/f.txt:----------", "a/loop:L---------", "b:L---------", "broken:L---------"}
+	if !slices.Equal(got, want) {
+		t.Fatal("got", got, "want", want)
+	}
+	got = walk(&squashfs.WalkOptions{FollowSymlinks: true})
+	want = []string{".:d---------", "a:d---------", "a/f.txt:----------", "a/loop:L---------", "b:d---------", "b/f.txt:----------", "b/loop:L---------", "broken:L---------"}
+	if !slices.Equal(got, want) {
+		t.Fatal("got", got, "want", want)
+	}
+}
+
+func TestOpenResolved(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("a", testFile("f.txt", []byte("f")), testSymlink("up", "../c")),
+		testSymlink("b", "a/up"),
+		testSymlink("c", "a/f.txt"),
+		testSymlink("loop1", "loop2"),
+		testSymlink("loop2", "loop1"),
+		testSymlink("broken", "nope"),
+	), testImageOptions{})
+	for _, name := range []string{"a/f.txt", "c", "b", "a/up"} {
+		f, err := rdr.OpenResolved(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		data, err := io.ReadAll(f)
+		if err != nil || string(data) != "f" {
+			t.Error(name, "got", string(data), err)
+		}
+		f.Close()
+	}
+	if f, err := rdr.OpenResolved("a"); err != nil || !f.IsDir() {
+		t.Error("a:", err)
+	}
+	for _, name := range []string{"loop1", "broken", "missing"} {
+		if _, err := rdr.OpenResolved(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Error(name, "got", err)
+		}
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	var dirs []*testNode
+	for i := range 6 {
+		var subs []*testNode
+		for j := range 3 {
+			var files []*testNode
+			for k := range 8 {
+				files = append(files, testFile("file"+strconv.Itoa(k), nil))
+			}
+			subs = append(subs, testDir("sub"+strconv.Itoa(j), files...))
+		}
+		dirs = append(dirs, testDir("dir"+strconv.Itoa(i), subs...))
+	}
+	rdr := openTestImage(t, testDir("", dirs...), testImageOptions{})
+	var want []string
+	err := rdr.Walk(func(p string, d fs.DirEntry, err error) error {
+		want = append(want, p)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 4, 64} {
+		var mut sync.Mutex
+		var got []string
+		err = rdr.WalkParallel(workers, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			mut.Lock()
+			defer mut.Unlock()
+			if p != "." && !slices.Contains(got, path.Dir(p)) {
+				t.Errorf("%s reported before its directory", p)
+			}
+			got = append(got, p)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("workers %d: walked %d files, want %d", workers, len(got), len(want))
+		}
+	}
+	var count atomic.Int32
+	err = rdr.WalkParallel(4, func(p string, d fs.DirEntry, err error) error {
+		if strings.HasPrefix(p, "dir3/") {
+			t.Error("walked into skipped directory:", p)
+		}
+		count.Add(1)
+		if p == "dir3" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(count.Load()) != len(want)-3*9 {
+		t.Fatal("walked", count.Load(), "files after skipping a directory")
+	}
+	errStop := errors.New("stop")
+	err = rdr.WalkParallel(4, func(p string, d fs.DirEntry, err error) error {
+		if p == "dir5/sub2/file7" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatal("expected errStop, got", err)
+	}
+	err = rdr.WalkParallel(4, func(p string, d fs.DirEntry, err error) error {
+		return fs.SkipAll
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsortedDirectory(t *testing.T) {
+	names := []string{"zeta", "alpha", "Mid", "beta", "_under"}
+	var kids []*testNode
+	for _, n := range names {
+		kids = append(kids, testFile(n, []byte(n)))
+	}
+	rdr := openTestImage(t, testDir("", testDir("dir", kids...)), testImageOptions{unsorted: true})
+	ents, err := rdr.ReadDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range ents {
+		got = append(got, e.Name())
+	}
+	want := slices.Clone(names)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatal("got", got, "want", want)
+	}
+	// Lookups rely on sorted entries.
+	for _, n := range names {
+		dat, err := rdr.ReadFile("dir/" + n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != n {
+			t.Fatal("wrong contents for", n)
+		}
+	}
+}
+
+func TestDirectorySize(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("dir", testFile("a", nil), testFile("bb", nil), testFile("ccc", nil)),
+		testDir("empty"),
+	), testImageOptions{})
+	dir, err := rdr.OpenFile("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := dir.NumChildren()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatal("expected 3 children, got", n)
+	}
+	stat, _ := dir.Stat()
+	// One 12 byte header, three 8 byte entries + names, + 3.
+	if stat.Size() != 12+3*8+6+3 {
+		t.Fatal("wrong directory size:", stat.Size())
+	}
+	stat, err = rdr.Stat("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 3 {
+		t.Fatal("wrong empty directory size:", stat.Size())
+	}
+}
+
+func TestModTime(t *testing.T) {
+	nodes := []*testNode{
+		testDir("dir"),
+		testFile("file", []byte("data")),
+		testSymlink("sym", "file"),
+		{name: "char", mode: fs.ModeDevice | fs.ModeCharDevice | 0600},
+		{name: "fifo", mode: fs.ModeNamedPipe | 0600},
+	}
+	for i, n := range nodes {
+		n.mtime = 1000000 + uint32(i)
+	}
+	rdr := openTestImage(t, testDir("", nodes...), testImageOptions{})
+	ents, err := rdr.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		want := slices.IndexFunc(nodes, func(n *testNode) bool { return n.name == e.Name() }) + 1000000
+		info, _ := e.Info()
+		if info.ModTime().Unix() != int64(want) {
+			t.Errorf("%s: DirEntry mtime %v, want %v", e.Name(), info.ModTime().Unix(), want)
+		}
+		f, err := rdr.OpenFile(e.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.ModTime().Unix() != int64(want) {
+			t.Errorf("%s: File mtime %v, want %v", e.Name(), f.ModTime().Unix(), want)
+		}
+	}
+}
+
+func TestModTime2038(t *testing.T) {
+	// Times are unsigned, so they don't wrap around in 2038.
+	times := map[string]uint32{"before": 0x7FFFFFFF, "after": 0x80000000, "max": 0xFFFFFFFF}
+	var nodes []*testNode
+	for name, mtime := range times {
+		n := testFile(name, []byte(name))
+		n.mtime = mtime
+		nodes = append(nodes, n)
+	}
+	rdr := openTestImage(t, testDir("", nodes...), testImageOptions{})
+	for name, mtime := range times {
+		f, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := time.Unix(int64(mtime), 0).UTC()
+		if !f.ModTime().Equal(want) || !info.ModTime().Equal(want) || f.ModTime().Location() != time.UTC {
+			t.Errorf("%s: got %v and %v, want %v", name, f.ModTime(), info.ModTime(), want)
+		}
+	}
+	if got := time.Unix(0x80000000, 0).UTC(); got.Year() != 2038 {
+		t.Fatal("unexpected year", got)
+	}
+	op := squashfs.DefaultOptions()
+	op.PreserveModTime = true
+	dir := t.TempDir()
+	if err := rdr.ExtractWithOptions(dir, op); err != nil {
+		t.Fatal(err)
+	}
+	for name, mtime := range times {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ModTime().Unix() != int64(mtime) {
+			t.Errorf("%s: extracted with mtime %v, want %v", name, info.ModTime().Unix(), mtime)
+		}
+	}
+}
+
+func TestExtractFragments(t *testing.T) {
+	var kids []*testNode
+	for i := range 200 {
+		kids = append(kids, testFile("file"+strconv.Itoa(i), []byte(strings.Repeat(strconv.Itoa(i), i))))
+	}
+	rdr := openTestImage(t, testDir("", testDir("small", kids...)), testImageOptions{compress: true})
+	dir := t.TempDir()
+	op := squashfs.FastOptions()
+	op.IgnorePerm = true
+	err := rdr.ExtractWithOptions(dir, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 200 {
+		dat, err := os.ReadFile(filepath.Join(dir, "small", "file"+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != strings.Repeat(strconv.Itoa(i), i) {
+			t.Fatal("wrong contents for file", i)
+		}
+	}
+}
+
+func TestExtractModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("special mode bits aren't supported on windows")
+	}
+	rdr := openTestImage(t, testDir("",
+		&testNode{name: "suid", mode: fs.ModeSetuid | fs.ModeSetgid | 0755, data: []byte("suid")},
+		&testNode{name: "sticky", mode: fs.ModeDir | fs.ModeSticky | 0777},
+		testFile("target", []byte("target")),
+		testSymlink("link", "target"),
+	), testImageOptions{})
+	dir := t.TempDir()
+	if err := rdr.ExtractWithOptions(dir, squashfs.DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]fs.FileMode{
+		"suid":   fs.ModeSetuid | fs.ModeSetgid | 0755,
+		"sticky": fs.ModeDir | fs.ModeSticky | 0777,
+		// Not changed to the symlink's mode.
+		"target": 0644,
+	}
+	for name, mode := range want {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s: got mode %v, want %v", name, info.Mode(), mode)
+		}
+	}
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testDir("a", testFile("t.txt", []byte("target")), testDir("sub", testFile("s.txt", []byte("sub")))),
+		testDir("b",
+			testSymlink("l1", "../a/t.txt"),
+			testSymlink("l2", "../a/t.txt"),
+			testSymlink("d", "../a"),
+			testSymlink("self", "f"),
+			testFile("f", []byte("f")),
+		),
+	), testImageOptions{})
+	b, err := rdr.OpenDir("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The target is resolved once, even when asked for concurrently.
+	l1, err := b.OpenFile("l1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l1.GetSymlinkFile() == nil {
+				t.Error("failed to get l1's target")
+			}
+		}()
+	}
+	wg.Wait()
+	check := func(dir string, files map[string]string) {
+		t.Helper()
+		for name, want := range files {
+			dat, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(dat) != want {
+				t.Fatalf("%s: got %q, want %q", name, dat, want)
+			}
+		}
+	}
+	op := squashfs.FastOptions()
+	op.IgnorePerm = true
+	op.UnbreakSymlink = true
+	// The same options are used multiple times to make sure they aren't changed by an extraction.
+	for range 2 {
+		dir := t.TempDir()
+		if err = rdr.ExtractWithOptions(dir, op); err != nil {
+			t.Fatal(err)
+		}
+		check(dir, map[string]string{
+			"b/l1":          "target",
+			"b/l2":          "target",
+			"b/d/t.txt":     "target",
+			"b/d/sub/s.txt": "sub",
+			"b/self":        "f",
+			"a/t.txt":       "target",
+			"a/sub/s.txt":   "sub",
+		})
+		if fi, err := os.Lstat(filepath.Join(dir, "b/l1")); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			t.Fatal("l1 should be a symlink:", err)
+		}
+	}
+	// Targets outside of the extraction folder are never written.
+	dir := t.TempDir()
+	if err = b.ExtractWithOptions(filepath.Join(dir, "b"), op); err != nil {
+		t.Fatal(err)
+	}
+	check(dir, map[string]string{"b/self": "f"})
+	if _, err = os.Lstat(filepath.Join(dir, "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("a was extracted outside of the extraction folder:", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "b/l1")); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		t.Fatal("l1 should be a symlink:", err)
+	}
+	op.UnbreakSymlink = false
+	op.DereferenceSymlink = true
+	dir = t.TempDir()
+	if err = b.ExtractWithOptions(dir, op); err != nil {
+		t.Fatal(err)
+	}
+	check(dir, map[string]string{
+		"l1":          "target",
+		"d/t.txt":     "target",
+		"d/sub/s.txt": "sub",
+		"self":        "f",
+	})
+	if fi, err := os.Lstat(filepath.Join(dir, "d")); err != nil || !fi.IsDir() {
+		t.Fatal("d should be a directory:", err)
+	}
+}
+
+func TestReadahead(t *testing.T) {
+	dat := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(dat[:30000])
+	rdr := openTestImage(t, testDir("", testFile("file", dat)), testImageOptions{compress: true})
+	for _, ahead := range []int{0, 1, 4, 100} {
+		rdr.SetReadahead(ahead)
+		f, err := rdr.OpenFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, dat) {
+			t.Fatal("wrong data with readahead", ahead)
+		}
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	dat := make([]byte, 4096*5+1000)
+	rand.New(rand.NewSource(2)).Read(dat)
+	rdr := openTestImage(t, testDir("", testFile("file", dat)), testImageOptions{compress: true})
+	f, err := rdr.OpenFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for range 50 {
+				off := rng.Intn(len(dat))
+				buf := make([]byte, rng.Intn(9000))
+				n, err := f.ReadAt(buf, int64(off))
+				if n < len(buf) && err != io.EOF {
+					t.Error("short read without io.EOF:", err)
+					return
+				}
+				if !bytes.Equal(buf[:n], dat[off:min(off+len(buf), len(dat))]) {
+					t.Error("wrong data at offset", off)
+					return
+				}
+			}
+		}(rand.New(rand.NewSource(int64(i))))
+	}
+	wg.Wait()
+}
+
+func TestDirectoryIndex(t *testing.T) {
+	var children []*testNode
+	var names []string
+	for i := range 3000 {
+		name := "entry-" + strconv.Itoa(i*2)
+		names = append(names, name)
+		if i%500 == 0 {
+			children = append(children, testDir(name, testFile("file", []byte(name))))
+		} else {
+			children = append(children, testFile(name, []byte(name)))
+		}
+	}
+	rdr := openTestImage(t, testDir("", testDir("big", children...)), testImageOptions{compress: true})
+	b, err := rdr.Low.Root.Open(&rdr.Low, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.HasDirIndex() {
+		t.Fatal("expected big to have a directory index")
+	}
+	for i, name := range names {
+		if i%37 != 0 && i%500 != 0 && i != len(names)-1 {
+			continue
+		}
+		p := "big/" + name
+		if i%500 == 0 {
+			p += "/file"
+		}
+		dat, err := rdr.ReadFile(p)
+		if err != nil {
+			t.Fatal(p, err)
+		}
+		if string(dat) != name {
+			t.Fatalf("%s: got %q", p, dat)
+		}
+		// Odd numbers are never present.
+		missing := "big/entry-" + strconv.Itoa(i*2+1)
+		if _, err = rdr.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(missing, "should not exist:", err)
+		}
+	}
+	for _, missing := range []string{"big/a", "big/zzz", "big/entry-0/missing"} {
+		if _, err = rdr.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(missing, "should not exist:", err)
+		}
+	}
+	entries, err := rdr.ReadDir("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(names))
+	}
+}
+
+func TestOpenInode(t *testing.T) {
+	// More than 1024 inodes so the export table spans multiple metadata blocks.
+	var children []*testNode
+	for i := range 1500 {
+		children = append(children, testFile("file"+strconv.Itoa(i), []byte(strconv.Itoa(i))))
+	}
+	children = append(children, testLink("link", children[0]), testSymlink("symlink", "file1"))
+	root := testDir("", testDir("sub", children...))
+	rdr := openTestImage(t, root, testImageOptions{exportable: true})
+	for _, name := range []string{"sub/file0", "sub/file1023", "sub/file1024", "sub/file1499", "sub/link", "sub", "."} {
+		want, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rdr.OpenInode(want.InodeNum())
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if got.InodeNum() != want.InodeNum() || got.Mode() != want.Mode() {
+			t.Fatalf("%s: got inode %d (%v), want %d (%v)", name, got.InodeNum(), got.Mode(), want.InodeNum(), want.Mode())
+		}
+		if want.IsRegular() {
+			wantDat, _ := io.ReadAll(want)
+			gotDat, err := io.ReadAll(got)
+			if err != nil || !bytes.Equal(gotDat, wantDat) {
+				t.Fatalf("%s: got %q (%v), want %q", name, gotDat, err, wantDat)
+			}
+		}
+	}
+	table, err := rdr.ExportTable()
+	if err != nil || len(table) != int(rdr.Superblock().InodeCount) {
+		t.Fatal("export table has", len(table), "entries:", err)
+	}
+	for n := range uint32(len(table)) {
+		ref, err := rdr.InodeRef(n + 1)
+		if err != nil || ref != table[n] {
+			t.Fatal("wrong ref for inode", n+1, err)
+		}
+		fil, err := rdr.FileFromRef(ref)
+		if err != nil || fil.InodeNum() != n+1 {
+			t.Fatal("ref for inode", n+1, "points to", fil.InodeNum(), err)
+		}
+	}
+	sym, err := rdr.OpenFile("sub/symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym, err = rdr.OpenInode(sym.InodeNum())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sym.SymlinkPath() != "file1" || sym.GetSymlinkFile() != nil {
+		t.Fatal("symlink opened by inode shouldn't be resolvable")
+	}
+	for _, n := range []uint32{0, 100000} {
+		if _, err = rdr.OpenInode(n); err == nil {
+			t.Fatal("expected error for inode", n)
+		}
+	}
+	rdr = openTestImage(t, root, testImageOptions{})
+	fil, err := rdr.OpenFile("sub/file0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.OpenInode(fil.InodeNum()); !errors.Is(err, squashfslow.ErrorNotExportable) {
+		t.Fatal("expected ErrorNotExportable, got", err)
+	}
+}
+
+// Fails all reads at or after limit once limit is set.
+type limitedReaderAt struct {
+	r     io.ReaderAt
+	limit int64
+}
+
+func (l *limitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if l.limit > 0 && off+int64(len(p)) > l.limit {
+		return 0, errors.New("read past limit")
+	}
+	return l.r.ReadAt(p, off)
+}
+
+func TestHandles(t *testing.T) {
+	root := testDir("", testDir("a", testDir("b", testFile("c", []byte("c")))))
+	rdr := openTestImage(t, root, testImageOptions{exportable: true})
+	other := openTestImage(t, testDir("", testFile("x", nil)), testImageOptions{exportable: true, modTime: 1})
+	for _, name := range []string{".", "a", "a/b", "a/b/c"} {
+		want, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := rdr.Handle(want)
+		got, err := rdr.FromHandle(h)
+		if err != nil || got.InodeNum() != want.InodeNum() {
+			t.Fatal(name, err)
+		}
+		if _, err = other.FromHandle(h); err != squashfs.ErrStaleHandle {
+			t.Fatal("expected ErrStaleHandle, got", err)
+		}
+	}
+	for name, parent := range map[string]string{"a/b": "a", "a": ".", ".": "."} {
+		f, _ := rdr.OpenFile(name)
+		p, _ := rdr.OpenFile(parent)
+		if n, ok := rdr.ParentInode(f); !ok || n != p.InodeNum() {
+			t.Fatalf("%s: got parent %d, want %d", name, n, p.InodeNum())
+		}
+	}
+	f, _ := rdr.OpenFile("a/b/c")
+	if _, ok := rdr.ParentInode(f); ok {
+		t.Fatal("files don't have parents")
+	}
+	if _, err := rdr.FromHandle([]byte{1, 2, 3}); err != squashfs.ErrStaleHandle {
+		t.Fatal("expected ErrStaleHandle, got", err)
+	}
+}
+
+func TestPreloadMetadata(t *testing.T) {
+	var dirs []*testNode
+	for i := range 20 {
+		var files []*testNode
+		for j := range 100 {
+			files = append(files, testFile("file"+strconv.Itoa(j), []byte(strconv.Itoa(i*j))))
+		}
+		files = append(files, testSymlink("link", "file1"))
+		dirs = append(dirs, testDir("dir"+strconv.Itoa(i), files...))
+	}
+	l := &limitedReaderAt{r: bytes.NewReader(buildTestImage(t, testDir("", dirs...), testImageOptions{compress: true, exportable: true}))}
+	rdr, err := squashfs.NewReader(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rdr.PreloadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	// Data and fragments are stored before the inode table, so anything after shouldn't be needed.
+	l.limit = int64(rdr.Low.Superblock.InodeTableStart)
+	var count int
+	err = rdr.Walk(func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		count++
+		if d.Type().IsRegular() {
+			_, err = rdr.ReadFile(p)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1+20*102 {
+		t.Fatal("walked", count, "files")
+	}
+	link, err := rdr.OpenFile("dir7/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := link.GetSymlinkFile()
+	if target == nil {
+		t.Fatal("failed to resolve symlink")
+	}
+	dat, err := io.ReadAll(target)
+	if err != nil || string(dat) != "7" {
+		t.Fatalf("got %q (%v)", dat, err)
+	}
+}
+
+func TestReadRanges(t *testing.T) {
+	dat := make([]byte, 4096*3+500)
+	rand.New(rand.NewSource(3)).Read(dat)
+	rdr := openTestImage(t, testDir("", testFile("file", dat)), testImageOptions{compress: true})
+	f, err := rdr.OpenFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := []squashfs.Range{
+		{Offset: 0, Length: 16},
+		{Offset: 4090, Length: 20},
+		{Offset: 4096 * 3, Length: 1000},
+		{Offset: 5, Length: 4096 * 2},
+		{Offset: int64(len(dat)), Length: 10},
+	}
+	got, err := f.ReadRanges(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rng := range ranges {
+		start := min(int(rng.Offset), len(dat))
+		if !bytes.Equal(got[i], dat[start:min(start+int(rng.Length), len(dat))]) {
+			t.Errorf("wrong data for range %+v", rng)
+		}
+	}
+}
+
+func TestLowMemory(t *testing.T) {
+	dat := make([]byte, 4096*4+100)
+	rand.New(rand.NewSource(4)).Read(dat)
+	rdr := openTestImage(t, testDir("",
+		testFile("big", dat),
+		testFile("small", []byte("small")),
+		testFile("text", bytes.Repeat([]byte("low memory "), 2000)),
+	), testImageOptions{compress: true})
+	rdr.SetGoroutineLimit(3)
+	rdr.SetLowMemory(true)
+	if rdr.Low.GoroutineLimit() != 1 {
+		t.Fatal("low memory mode should decompress one block at a time")
+	}
+	for range 2 {
+		got, err := rdr.ReadFile("big")
+		if err != nil || !bytes.Equal(got, dat) {
+			t.Fatal("wrong data for big:", err)
+		}
+		got, err = rdr.ReadFile("small")
+		if err != nil || string(got) != "small" {
+			t.Fatal("wrong data for small:", err)
+		}
+	}
+	dir := t.TempDir()
+	if err := rdr.ExtractWithOptions(dir, squashfs.FastOptions()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "big"))
+	if err != nil || !bytes.Equal(got, dat) {
+		t.Fatal("wrong extracted data:", err)
+	}
+	// ReadAt doesn't keep the last block, so reading from the same block twice decompresses it twice.
+	f, err := rdr.OpenFile("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := rdr.Stats().BlocksDecompressed
+	buf := make([]byte, 10)
+	for off := range int64(2) {
+		if _, err = f.ReadAt(buf, off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := rdr.Stats().BlocksDecompressed - start; n != 2 {
+		t.Fatal("expected the block to be decompressed twice, got", n)
+	}
+	rdr.SetLowMemory(false)
+	if rdr.Low.GoroutineLimit() != 3 {
+		t.Fatal("disabling low memory mode should restore the goroutine limit, got", rdr.Low.GoroutineLimit())
+	}
+}
+
+func TestStats(t *testing.T) {
+	dat := bytes.Repeat([]byte("compressible "), 4096)
+	rdr := openTestImage(t, testDir("", testFile("big", dat), testFile("small", []byte("small"))), testImageOptions{compress: true})
+	start := rdr.Stats()
+	if start.BytesRead == 0 || start.Reads == 0 || start.BlocksDecompressed == 0 {
+		t.Fatalf("opening the archive should be counted: %+v", start)
+	}
+	for range 2 {
+		if _, err := rdr.ReadFile("small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := rdr.Stats()
+	if st.FragCacheMisses != 1 || st.FragCacheHits != 1 {
+		t.Fatalf("expected 1 fragment cache miss and hit: %+v", st)
+	}
+	f, err := rdr.OpenFile("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetGoroutineLimit(4)
+	if _, err = f.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	end := rdr.Stats()
+	blocks := uint64(len(dat)+4095) / 4096
+	if end.Goroutines < st.Goroutines+blocks-1 || end.BlocksDecompressed < st.BlocksDecompressed+blocks-1 {
+		t.Fatalf("expected at least %d more blocks and goroutines: %+v -> %+v", blocks-1, st, end)
+	}
+	if end.BytesDecompressed <= st.BytesDecompressed || end.BytesRead <= st.BytesRead {
+		t.Fatalf("expected more bytes read and decompressed: %+v -> %+v", st, end)
+	}
+}
+
+func TestLargeBlocks(t *testing.T) {
+	const blockSize = 1024 * 1024
+	dat := bytes.Repeat([]byte("1MiB blocks "), (2*blockSize+500)/12)
+	rdr := openTestImage(t, testDir("", testFile("file", dat)), testImageOptions{blockSize: blockSize, compress: true})
+	got, err := rdr.ReadFile("file")
+	if err != nil || !bytes.Equal(got, dat) {
+		t.Fatal("wrong data:", err)
+	}
+	f, err := rdr.OpenFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small reads of the same block, including the fragment, should only decompress it once.
+	for _, off := range []int{10, 2*blockSize + 10} {
+		before := rdr.Stats().BlocksDecompressed
+		for i := range 50 {
+			buf := make([]byte, 100)
+			n, err := f.ReadAt(buf, int64(off+i*5))
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], dat[off+i*5:min(off+i*5+100, len(dat))]) {
+				t.Fatal("wrong data at", off+i*5)
+			}
+		}
+		if d := rdr.Stats().BlocksDecompressed - before; d > 1 {
+			t.Fatalf("decompressed %d blocks for reads at %d", d, off)
+		}
+	}
+}
+
+func TestOpenArchiveFile(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "test.sfs")
+	err := os.WriteFile(archive, buildTestImage(t, testDir("", testFile("file", []byte("data"))), testImageOptions{}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := squashfs.OpenFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := rdr.ReadFile("file")
+	if err != nil || string(dat) != "data" {
+		t.Fatalf("got %q (%v)", dat, err)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.ReadFile("file"); err == nil {
+		t.Fatal("reading after Close should fail")
+	}
+	if _, err = squashfs.OpenFile(filepath.Join(t.TempDir(), "missing.sfs")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected fs.ErrNotExist, got", err)
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", []byte("data"))), testImageOptions{})
+	// Extra data after the archive shouldn't matter.
+	padded := append(slices.Clone(img), make([]byte, 4096)...)
+	rdr, err := squashfs.NewReaderSize(bytes.NewReader(padded), int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := rdr.ReadFile("file")
+	if err != nil || string(dat) != "data" {
+		t.Fatalf("got %q (%v)", dat, err)
+	}
+	for _, size := range []int{len(img) - 10, 200} {
+		if _, err = squashfs.NewReaderSize(bytes.NewReader(img), int64(size)); err != squashfs.ErrTruncated {
+			t.Fatal("expected ErrTruncated, got", err)
+		}
+	}
+}
+
+func TestStreamReader(t *testing.T) {
+	img := buildTestImage(t, testDir("",
+		testFile("big", bytes.Repeat([]byte("stream"), 5000)),
+		testDir("sub", testFile("small", []byte("small")), testSymlink("link", "small")),
+	), testImageOptions{compress: true})
+	// io.MultiReader hides bytes.Reader's other methods.
+	rdr, err := squashfs.NewStreamReader(io.MultiReader(bytes.NewReader(img)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = rdr.Walk(func(path string, d fs.DirEntry, err error) error {
+		names = append(names, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{".", "big", "sub", "sub/link", "sub/small"}) {
+		t.Fatal("got", names)
+	}
+	info, err := rdr.Stat("big")
+	if err != nil || info.Size() != 30000 {
+		t.Fatal("wrong size for big:", err)
+	}
+	if _, err = rdr.ReadFile("sub/small"); !errors.Is(err, squashfs.ErrDataDiscarded) {
+		t.Fatal("expected ErrDataDiscarded, got", err)
+	}
+	// A huge bytes used must fail once the stream ends, not allocate it all up front.
+	bad := slices.Clone(img)
+	binary.LittleEndian.PutUint64(bad[40:], 1<<62)
+	if _, err = squashfs.NewStreamReader(io.MultiReader(bytes.NewReader(bad)), false); !errors.Is(err, squashfs.ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt, got", err)
+	}
+
+	rdr, err = squashfs.NewStreamReader(io.MultiReader(bytes.NewReader(img)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := rdr.ReadFile("big")
+	if err != nil || !bytes.Equal(dat, bytes.Repeat([]byte("stream"), 5000)) {
+		t.Fatal("wrong data for big:", err)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindSuperblock(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", []byte("embedded"))), testImageOptions{})
+	// A header containing a decoy magic, with the archive straddling FindSuperblock's read chunks.
+	header := append([]byte("\x7fELFhsqs"), make([]byte, 64*1024-40)...)
+	dat := append(header, img...)
+	off, err := squashfs.FindSuperblock(bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(len(header)) {
+		t.Fatal("found superblock at", off, "want", len(header))
+	}
+	rdr, err := squashfs.NewReaderAt(bytes.NewReader(dat), off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rdr.ReadFile("file")
+	if err != nil || string(got) != "embedded" {
+		t.Fatal("wrong data:", string(got), err)
+	}
+	if _, err = squashfs.FindSuperblock(bytes.NewReader(header)); err != squashfs.ErrNoSuperblock {
+		t.Fatal("expected ErrNoSuperblock, got", err)
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", []byte("padded"))), testImageOptions{})
+	padded := append(append([]byte{}, img...), make([]byte, 4096-len(img)%4096)...)
+	padded = append(padded, "signature"...)
+	rdr, err := squashfs.NewReader(bytes.NewReader(padded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rdr.ReadFile("file")
+	if err != nil || string(got) != "padded" {
+		t.Fatal("wrong data:", string(got), err)
+	}
+	if rdr.ArchiveSize() != int64(len(img)) {
+		t.Fatal("archive size is", rdr.ArchiveSize(), "want", len(img))
+	}
+	if size, ok := rdr.ContainerSize(); !ok || size != int64(len(padded)) {
+		t.Fatal("container size is", size, ok, "want", len(padded))
+	}
+	rdr, err = squashfs.NewReaderAt(bytes.NewReader(append([]byte("header"), padded...)), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := rdr.ContainerSize(); !ok || size != int64(len(padded)) {
+		t.Fatal("container size at offset is", size, ok, "want", len(padded))
+	}
+}
+
+// Fails every other read after returning part of the data.
+type flakyReaderAt struct {
+	r     io.ReaderAt
+	mut   sync.Mutex
+	reads int
+	short bool // Failed reads return a nil error, which io.ReaderAt doesn't allow.
+}
+
+func (f *flakyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.mut.Lock()
+	f.reads++
+	fail := f.reads%2 == 1
+	f.mut.Unlock()
+	if fail {
+		n, _ := f.r.ReadAt(p[:len(p)/2], off)
+		if f.short {
+			return n, nil
+		}
+		return n, errors.New("temporary failure")
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestRetries(t *testing.T) {
+	want := bytes.Repeat([]byte("retry"), 10000)
+	img := buildTestImage(t, testDir("", testFile("file", want)), testImageOptions{compress: true})
+	if _, err := squashfs.NewReader(&flakyReaderAt{r: bytes.NewReader(img)}); err == nil {
+		t.Fatal("expected an error without retries")
+	}
+	rdr, err := squashfs.NewReader(squashfs.WithRetries(&flakyReaderAt{r: bytes.NewReader(img)}, squashfs.RetryPolicy{Attempts: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rdr.ReadFile("file")
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatal("wrong data:", err)
+	}
+	short := squashfs.WithRetries(&flakyReaderAt{r: bytes.NewReader(img), short: true}, squashfs.RetryPolicy{Attempts: 2})
+	buf := make([]byte, 100)
+	if n, err := short.ReadAt(buf, 0); n != len(buf) || err != nil || !bytes.Equal(buf, img[:100]) {
+		t.Fatal("short read wasn't resumed:", n, err)
+	}
+	short = squashfs.WithRetries(&flakyReaderAt{r: bytes.NewReader(img), short: true}, squashfs.RetryPolicy{Attempts: 1})
+	if n, err := short.ReadAt(buf, 0); n != len(buf)/2 || err != io.ErrUnexpectedEOF {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", n, err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	whiteout := &testNode{name: "b", mode: fs.ModeDevice | fs.ModeCharDevice}
+	lower := openTestImage(t, testDir("",
+		testFile("a", []byte("lower a")),
+		testFile("b", []byte("lower b")),
+		testFile("c", []byte("lower c")),
+		testDir("d", testFile("x", []byte("x")), testFile("y", []byte("y"))),
+		testDir("o", testFile("p", []byte("p"))),
+		testFile("lowerOnly", []byte("lower only")),
+	), testImageOptions{})
+	upper := openTestImage(t, testDir("",
+		testFile("a", []byte("upper a")),
+		whiteout,
+		testFile(".wh.c", nil),
+		testDir("d", testFile("z", []byte("z")), testFile(".wh.x", nil)),
+		testDir("o", testFile(".wh..wh..opq", nil), testFile("q", []byte("q"))),
+	), testImageOptions{})
+	o := squashfs.OverlayFS(upper, lower)
+	if err := fstest.TestFS(o, "a", "d/y", "d/z", "o/q", "lowerOnly"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := o.ReadFile("a")
+	if err != nil || string(got) != "upper a" {
+		t.Fatal("wrong data for a:", string(got), err)
+	}
+	for _, name := range []string{"b", "c", "d/x", "o/p", ".wh.c", "d/.wh.x"} {
+		if _, err = o.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Error(name, "should be hidden, got", err)
+		}
+	}
+	var names []string
+	err = fs.WalkDir(o, ".", func(path string, d fs.DirEntry, err error) error {
+		names = append(names, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "a", "d", "d/y", "d/z", "lowerOnly", "o", "o/q"}
+	if !slices.Equal(names, want) {
+		t.Fatal("got", names, "want", want)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", nil)), testImageOptions{})
+	// squashfs 3.x stores its version at the same offset, but has a different layout after it.
+	img[28], img[30] = 3, 1
+	img[22] = 0xFF // An invalid block log shouldn't hide the version.
+	_, err := squashfs.NewReaderFromBytes(img)
+	var verErr squashfslow.VersionError
+	if !errors.As(err, &verErr) || verErr.Major != 3 || verErr.Minor != 1 || !errors.Is(err, squashfslow.ErrorVersion) {
+		t.Fatal("expected a VersionError for 3.1, got", err)
+	}
+	if err.Error() != "unsupported squashfs version 3.1. only 4.0 is supported" {
+		t.Fatal("unexpected message:", err)
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", nil)), testImageOptions{})
+	copy(img, "sqsh")
+	if _, err := squashfs.NewReaderFromBytes(img); err != squashfslow.ErrorBigEndian {
+		t.Fatal("expected ErrorBigEndian, got", err)
+	}
+	copy(img, "abcd")
+	if _, err := squashfs.NewReaderFromBytes(img); err != squashfslow.ErrorMagic {
+		t.Fatal("expected ErrorMagic, got", err)
+	}
+}
+
+func TestInvalidSuperblock(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", nil)), testImageOptions{})
+	size := binary.LittleEndian.Uint64(img[40:])
+	for _, c := range []struct {
+		off  int
+		val  uint64
+		bits int
+		want string
+	}{
+		{12, 3000, 32, "block size 3000 isn't a power of two between 4KiB and 1MiB"},
+		{72, size + 0x100, 64, fmt.Sprintf("directory table offset %#x beyond archive end (%#x)", size+0x100, size)},
+		{64, 10, 64, "inode table offset 0xa overlaps the superblock"},
+		{26, 0, 16, "id count is 0"},
+	} {
+		bad := slices.Clone(img)
+		switch c.bits {
+		case 16:
+			binary.LittleEndian.PutUint16(bad[c.off:], uint16(c.val))
+		case 32:
+			binary.LittleEndian.PutUint32(bad[c.off:], uint32(c.val))
+		case 64:
+			binary.LittleEndian.PutUint64(bad[c.off:], c.val)
+		}
+		_, err := squashfs.NewReaderFromBytes(bad)
+		var sbErr squashfslow.SuperblockError
+		if !errors.Is(err, squashfs.ErrInvalidSuperblock) || !errors.As(err, &sbErr) || sbErr.Reason != c.want {
+			t.Fatalf("expected %q, got %v", c.want, err)
+		}
+	}
+}
+
+func TestCorruptSizes(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", []byte("file")), testSymlink("link", "file")), testImageOptions{})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := make(map[uint32]squashfslow.MetaRef)
+	rdr.Inodes(func(r squashfslow.MetaRef, i inode.Inode) error {
+		refs[i.Num] = r
+		return nil
+	})
+	for _, c := range []struct {
+		name string
+		off  int // Offset of the size, after the inode's header.
+	}{
+		{"file", 12},
+		{"link", 4},
+	} {
+		f, err := rdr.OpenFile(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The test images' metadata is uncompressed, so the inode is at a fixed offset.
+		ref := refs[f.InodeNum()]
+		loc := int(rdr.Superblock().InodeTableStart+ref.Block()) + 2 + int(ref.Offset()) + 16 + c.off
+		bad := slices.Clone(img)
+		binary.LittleEndian.PutUint32(bad[loc:], 0xFFFFFFFF)
+		badRdr, err := squashfs.NewReaderFromBytes(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = badRdr.Low.InodeFromRef(ref); !errors.Is(err, squashfs.ErrCorrupt) {
+			t.Fatalf("%s: expected ErrCorrupt, got %v", c.name, err)
+		}
+	}
+}
+
+func TestDirectoryCycle(t *testing.T) {
+	img := buildTestImage(t, testDir("", testDir("a", testDir("b", testFile("file", nil)))), testImageOptions{})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := rdr.OpenFile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fileRef squashfslow.MetaRef
+	rdr.Inodes(func(r squashfslow.MetaRef, i inode.Inode) error {
+		if i.Num == a.InodeNum() {
+			fileRef = r
+		}
+		return nil
+	})
+	// Point a's entry for b at a. The test images' metadata is uncompressed, so the entry can be found by its name.
+	bad := slices.Clone(img)
+	dirTable := bad[rdr.Superblock().DirTableStart:]
+	i := bytes.Index(dirTable, []byte{byte(inode.Dir), 0, 0, 0, 'b'})
+	if i < 0 {
+		t.Fatal("entry not found")
+	}
+	binary.LittleEndian.PutUint16(dirTable[i-4:], fileRef.Offset())
+	rdr, err = squashfs.NewReaderFromBytes(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cycles []string
+	walkFn := func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, squashfs.ErrCorrupt) {
+			cycles = append(cycles, path)
+			return nil
+		}
+		return err
+	}
+	if err = rdr.Walk(walkFn); err != nil {
+		t.Fatal(err)
+	}
+	if err = rdr.WalkParallel(2, walkFn); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cycles, []string{"a/b", "a/b"}) {
+		t.Fatal("got", cycles)
+	}
+}
+
+func TestReaderOptions(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("file", []byte("options"))), testImageOptions{blockSize: 8192})
+	var logged bytes.Buffer
+	rdr, err := squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{
+		Logger:       log.New(&logged, "", 0),
+		CacheSize:    -1,
+		Workers:      2,
+		MaxBlockSize: 8192,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdr.Low.GoroutineLimit() != 2 {
+		t.Fatal("workers not set")
+	}
+	got, err := rdr.ReadFile("file")
+	if err != nil || string(got) != "options" {
+		t.Fatal("wrong data:", string(got), err)
+	}
+	if _, err = rdr.ReadFile("file"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := rdr.Stats(); stats.FragCacheHits != 0 || stats.FragCacheMisses != 2 {
+		t.Fatal("fragment cache should be disabled:", stats)
+	}
+	if logged.Len() != 0 {
+		t.Fatal("unexpected problems logged:", logged.String())
+	}
+	if _, err = squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{MaxBlockSize: 4096}); err != squashfs.ErrBlockSizeLimit {
+		t.Fatal("expected ErrBlockSizeLimit, got", err)
+	}
+
+	truncated := img[:len(img)-1]
+	if _, err = squashfs.NewReaderWithOptions(bytes.NewReader(truncated), squashfs.Options{StrictMode: true}); err != squashfs.ErrTruncated {
+		t.Fatal("expected ErrTruncated, got", err)
+	}
+	if _, err = squashfs.NewReaderWithOptions(bytes.NewReader(truncated), squashfs.Options{Logger: log.New(&logged, "", 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if logged.String() != squashfs.ErrTruncated.Error()+"\n" {
+		t.Fatal("expected the truncation to be logged, got", logged.String())
+	}
+	rdr, err = squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{Readahead: 3})
+	if err != nil || rdr.Low.Readahead() != 3 {
+		t.Fatal("readahead not set:", err)
+	}
+	rdr, err = squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{Readahead: 3, Workers: 2, LowMemory: true})
+	if err != nil || !rdr.Low.LowMemory() || rdr.Low.Readahead() != 0 || rdr.Low.GoroutineLimit() != 1 {
+		t.Fatal("low memory mode not set:", err)
+	}
+	rdr.SetLowMemory(false)
+	if rdr.Low.Readahead() != 3 || rdr.Low.GoroutineLimit() != 2 {
+		t.Fatal("options should be restored after low memory mode")
+	}
+	rdr, err = squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{PreloadMetadata: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With the metadata preloaded, opening a file doesn't read from the archive.
+	before := rdr.Stats().Reads
+	if _, err = rdr.Stat("file"); err != nil || rdr.Stats().Reads != before {
+		t.Fatal("metadata not preloaded:", err)
+	}
+	if _, err = squashfs.NewReaderWithOptions(bytes.NewReader(img), squashfs.Options{LowMemory: true, PreloadMetadata: true}); err == nil {
+		t.Fatal("expected LowMemory and PreloadMetadata to conflict")
+	}
+	// The flags are after the magic, inode count, mod time, block size, fragment count, compression, and block log.
+	flagged := slices.Clone(img)
+	binary.LittleEndian.PutUint16(flagged[24:], binary.LittleEndian.Uint16(flagged[24:])|0x8000)
+	if _, err = squashfs.NewReaderWithOptions(bytes.NewReader(flagged), squashfs.Options{StrictMode: true}); err == nil {
+		t.Fatal("expected unknown flags to fail in strict mode")
+	}
+}
+
+func TestClone(t *testing.T) {
+	want := bytes.Repeat([]byte("clone"), 10000)
+	rdr := openTestImage(t, testDir("", testFile("File", want), testFile("small", []byte("small"))), testImageOptions{compress: true})
+	rdr.SetCaseMode(squashfs.CaseInsensitive)
+	rdr.SetGoroutineLimit(3)
+	clone := rdr.Clone()
+	if clone.Low.GoroutineLimit() != 3 {
+		t.Fatal("settings weren't copied")
+	}
+	var wg sync.WaitGroup
+	for _, r := range []*squashfs.Reader{rdr, clone} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := r.ReadFile("file")
+			if err != nil || !bytes.Equal(got, want) {
+				t.Error("wrong data:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	before := rdr.Stats()
+	if _, err := clone.ReadFile("small"); err != nil {
+		t.Fatal(err)
+	}
+	if rdr.Stats() != before {
+		t.Fatal("stats should be independent:", before, rdr.Stats())
+	}
+}
+
+func TestReaderClose(t *testing.T) {
+	want := bytes.Repeat([]byte("close"), 10000)
+	rdr := openTestImage(t, testDir("", testFile("file", want), testDir("dir", testFile("small", []byte("small")))), testImageOptions{compress: true})
+	if err := rdr.PreloadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	fil, err := rdr.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := rdr.Clone()
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.Open("dir/small"); !errors.Is(err, squashfs.ErrReaderClosed) {
+		t.Fatal("expected ErrReaderClosed when opening, got", err)
+	}
+	if _, err = io.ReadAll(fil); !errors.Is(err, squashfs.ErrReaderClosed) {
+		t.Fatal("expected ErrReaderClosed when reading, got", err)
+	}
+	if _, err = rdr.ReadDir("."); !errors.Is(err, squashfs.ErrReaderClosed) {
+		t.Fatal("expected ErrReaderClosed when reading a directory, got", err)
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal("closing twice:", err)
+	}
+	got, err := clone.ReadFile("file")
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatal("clone should still work:", err)
+	}
+}
+
+func TestSplitArchive(t *testing.T) {
+	want := bytes.Repeat([]byte("split"), 10000)
+	img := buildTestImage(t, testDir("", testFile("file", want)), testImageOptions{compress: true})
+	dir := t.TempDir()
+	var paths []string
+	var parts []io.ReaderAt
+	for i := 0; i*1000 < len(img); i++ {
+		part := img[i*1000 : min((i+1)*1000, len(img))]
+		parts = append(parts, bytes.NewReader(part))
+		paths = append(paths, filepath.Join(dir, fmt.Sprintf("image.sfs.%03d", i)))
+		if err := os.WriteFile(paths[i], part, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	concat, err := squashfs.ConcatReaderAt(parts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = iotest.TestReader(io.NewSectionReader(concat, 0, int64(len(img))), img); err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := squashfs.OpenSplitFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	got, err := rdr.ReadFile("file")
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatal("wrong data:", err)
+	}
+	if size, ok := rdr.ContainerSize(); !ok || size != int64(len(img)) {
+		t.Fatal("container size is", size, ok)
+	}
+}
+
+func TestSuperblock(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("a", nil), testFile("b", nil)), testImageOptions{blockSize: 8192, modTime: 1234})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := rdr.Superblock()
+	if sb.BlockSize != 8192 || sb.BlockLog != 13 || sb.ModTime != 1234 || sb.InodeCount != 3 ||
+		sb.VerMaj != 4 || sb.VerMin != 0 || sb.Size != uint64(len(img)) || sb.CompType != squashfslow.ZlibCompression {
+		t.Fatalf("unexpected superblock: %+v", sb)
+	}
+	if sb.InodeTableStart >= sb.DirTableStart || !sb.ValidMagic() {
+		t.Fatalf("unexpected superblock: %+v", sb)
+	}
+	flags := rdr.Flags()
+	if !flags.UncompressedData() || !flags.NoXattrs() || flags.Exportable() || flags.DuplicatesRemoved() {
+		t.Fatal("unexpected flags:", flags)
+	}
+	if flags.String() != "uncompressed inodes, uncompressed data, uncompressed fragments, uncompressed xattrs, no xattrs, uncompressed ids" {
+		t.Fatal("unexpected flag names:", flags.String())
+	}
+	info := rdr.Info()
+	if info.ModTime != time.Unix(1234, 0).UTC() || info.Compression != "gzip" || info.BlockSize != 8192 ||
+		info.InodeCount != 3 || info.FragmentCount != sb.FragCount || info.IDCount != sb.IdCount ||
+		info.Flags != flags || info.BytesUsed != int64(len(img)) {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
+func TestInodes(t *testing.T) {
+	var kids []*testNode
+	for i := range 1000 {
+		kids = append(kids, testFile("file"+strconv.Itoa(i), bytes.Repeat([]byte{'a'}, i)))
+	}
+	rdr := openTestImage(t, testDir("", testDir("dir", kids...), testSymlink("link", "dir")), testImageOptions{})
+	seen := make(map[uint32]bool)
+	var files int
+	err := rdr.Inodes(func(ref squashfslow.MetaRef, i inode.Inode) error {
+		if seen[i.Num] {
+			t.Fatal("inode", i.Num, "seen twice")
+		}
+		seen[i.Num] = true
+		if i.Type == inode.Fil {
+			files++
+		}
+		// The reference must point at the same inode.
+		got, err := rdr.Low.InodeFromRef(ref)
+		if err != nil || got.Num != i.Num {
+			t.Fatal("wrong inode at ref", ref, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1003 || files != 1000 {
+		t.Fatal("got", len(seen), "inodes and", files, "files")
+	}
+	var count int
+	err = rdr.Inodes(func(squashfslow.MetaRef, inode.Inode) error {
+		count++
+		return fs.SkipAll
+	})
+	if err != nil || count != 1 {
+		t.Fatal("SkipAll should stop iteration:", count, err)
+	}
+}
+
+func TestDirectoryTable(t *testing.T) {
+	var kids []*testNode
+	for i := range 300 {
+		kids = append(kids, testFile("file"+strconv.Itoa(i), nil))
+	}
+	img := buildTestImage(t, testDir("", testDir("dir", kids...), testDir("empty"), testFile("a", nil)), testImageOptions{})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries for each header.
+	headers := make(map[squashfslow.MetaRef]int)
+	var total int
+	err = rdr.DirectoryTable(func(header squashfslow.MetaRef, h directory.Header, e directory.Entry) error {
+		headers[header]++
+		total++
+		if e.BlockStart != h.BlockStart {
+			t.Fatal("entry", e.Name, "doesn't match its header")
+		}
+		// Each entry must point at an inode with the same number and type.
+		i, err := rdr.Low.InodeFromEntry(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i.Num != e.Num || i.Type.Basic() != inode.Type(e.InodeType) {
+			t.Fatalf("entry %s doesn't match inode %d (%v)", e.Name, i.Num, i.Type)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 303 {
+		t.Fatal("got", total, "entries")
+	}
+	for ref, count := range headers {
+		if count > 256 {
+			t.Fatal("header at", ref, "has", count, "entries")
+		}
+	}
+	dir, err := rdr.OpenFile("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fileRef squashfslow.MetaRef
+	rdr.Inodes(func(ref squashfslow.MetaRef, i inode.Inode) error {
+		switch {
+		case i.Num == dir.InodeNum():
+			// The directory's listing starts with a header.
+			data := i.Data.(inode.Directory)
+			if _, ok := headers[squashfslow.NewMetaRef(uint64(data.BlockStart), data.Offset)]; !ok {
+				t.Fatal("no header at the start of dir's listing")
+			}
+		case i.Type == inode.Fil && i.Data.(inode.File).Size == 0 && fileRef == 0:
+			fileRef = ref
+		}
+		return nil
+	})
+	var count int
+	err = rdr.DirectoryTable(func(squashfslow.MetaRef, directory.Header, directory.Entry) error {
+		count++
+		return fs.SkipAll
+	})
+	if err != nil || count != 1 {
+		t.Fatal("SkipAll should stop iteration:", count, err)
+	}
+	// An unreadable file inode doesn't stop the directory table from being read. The test images' metadata is uncompressed,
+	// so the inode's type is right after its block's 2 byte header.
+	sb := rdr.Superblock()
+	bad := slices.Clone(img)
+	binary.LittleEndian.PutUint16(bad[sb.InodeTableStart+fileRef.Block()+2+uint64(fileRef.Offset()):], 99)
+	rdr, err = squashfs.NewReaderFromBytes(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdr.Inodes(func(squashfslow.MetaRef, inode.Inode) error { return nil }) == nil {
+		t.Fatal("expected the inode table to be unreadable")
+	}
+	total = 0
+	err = rdr.DirectoryTable(func(squashfslow.MetaRef, directory.Header, directory.Entry) error {
+		total++
+		return nil
+	})
+	if err != nil || total != 303 {
+		t.Fatal("got", total, "entries:", err)
+	}
+}
+
+func TestFragments(t *testing.T) {
+	var kids []*testNode
+	for i := range 100 {
+		kids = append(kids, testFile("file"+strconv.Itoa(i), bytes.Repeat([]byte{byte(i)}, 1000)))
+	}
+	img := buildTestImage(t, testDir("", kids...), testImageOptions{blockSize: 4096, compress: true})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frags, err := rdr.Fragments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 files fit in each fragment block.
+	if len(frags) != 25 || len(frags) != int(rdr.Superblock().FragCount) {
+		t.Fatal("got", len(frags), "fragments")
+	}
+	for i, f := range frags {
+		if !f.Compressed() || f.StoredSize() == 0 || f.Start+uint64(f.StoredSize()) > rdr.Superblock().InodeTableStart {
+			t.Fatalf("bad fragment %d: %+v", i, f)
+		}
+	}
+	fil, err := rdr.OpenFile("file5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	place, ok := fil.Fragment()
+	if !ok || place.Index >= 25 || place.Offset%1000 != 0 || place.Size != 1000 {
+		t.Fatalf("unexpected fragment placement: %+v", place)
+	}
+	if _, ok = rdr.File().Fragment(); ok {
+		t.Fatal("directory reported a fragment")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	root := testDir("",
+		testFile("small", []byte("small")),
+		testFile("exact", bytes.Repeat([]byte("a"), 4096)),
+		testFile("tail", bytes.Repeat([]byte("a"), 5000)),
+		testFile("empty", nil),
+	)
+	for _, noFrags := range []bool{false, true} {
+		rdr := openTestImage(t, root, testImageOptions{noFrags: noFrags})
+		want := map[string]squashfslow.Storage{
+			"small": squashfslow.StorageFragment,
+			"exact": squashfslow.StorageBlocks,
+			"tail":  squashfslow.StorageBlocksAndFragment,
+			"empty": squashfslow.StorageNone,
+			".":     squashfslow.StorageNone,
+		}
+		if noFrags {
+			want["small"], want["tail"] = squashfslow.StorageBlocks, squashfslow.StorageBlocks
+		}
+		for name, storage := range want {
+			f, err := rdr.OpenFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Storage() != storage {
+				t.Errorf("%s (noFrags %v): got %v, want %v", name, noFrags, f.Storage(), storage)
+			}
+		}
+	}
+}
+
+func TestStoredSize(t *testing.T) {
+	root := testDir("",
+		testFile("a", bytes.Repeat([]byte("a"), 10000)),
+		testFile("b", []byte("b")),
+		testFile("sparse", make([]byte, 8192)),
+	)
+	storedSize := func(rdr *squashfs.Reader, name string) uint64 {
+		f, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := f.StoredSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+	rdr := openTestImage(t, root, testImageOptions{})
+	// Uncompressed fragments store each file's end as is.
+	if got := storedSize(rdr, "a"); got != 10000 {
+		t.Fatal("a stored as", got, "bytes")
+	}
+	if got := storedSize(rdr, "b"); got != 1 {
+		t.Fatal("b stored as", got, "bytes")
+	}
+	if got := storedSize(rdr, "sparse"); got != 0 {
+		t.Fatal("sparse file stored as", got, "bytes")
+	}
+	if got := storedSize(rdr, "."); got != 0 {
+		t.Fatal("directory stored as", got, "bytes")
+	}
+	rdr = openTestImage(t, root, testImageOptions{compress: true})
+	if got := storedSize(rdr, "a"); got == 0 || got >= 1000 {
+		t.Fatal("compressed a stored as", got, "bytes")
+	}
+}
+
+func TestRawBlocks(t *testing.T) {
+	dat := append(bytes.Repeat([]byte("abcd"), 2048), make([]byte, 4096)...)
+	dat = append(dat, []byte("tail")...)
+	for _, noFrags := range []bool{false, true} {
+		rdr := openTestImage(t, testDir("", testFile("f", dat)), testImageOptions{compress: true, noFrags: noFrags})
+		f, err := rdr.OpenFile("f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 3
+		if noFrags {
+			want = 4
+		}
+		if f.BlockCount() != want {
+			t.Fatal("got", f.BlockCount(), "blocks")
+		}
+		blocks, err := f.RawBlocks(0, f.BlockCount())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		for i, b := range blocks {
+			switch {
+			case b.Data == nil:
+				got = append(got, make([]byte, b.Size)...)
+			case b.Compressed:
+				zr, err := zlib.NewReader(bytes.NewReader(b.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(dec) != int(b.Size) {
+					t.Fatal("block", i, "decompressed to", len(dec), "bytes, expected", b.Size)
+				}
+				got = append(got, dec...)
+			default:
+				got = append(got, b.Data...)
+			}
+		}
+		if !bytes.Equal(got, dat[:len(got)]) || (noFrags && len(got) != len(dat)) {
+			t.Fatal("raw blocks don't match the file's data")
+		}
+		if blocks[2].Data != nil {
+			t.Fatal("sparse block has data")
+		}
+		if _, err = f.RawBlocks(1, want+1); err == nil {
+			t.Fatal("out of bounds range succeeded")
+		}
+	}
+}
+
+func TestIDs(t *testing.T) {
+	a := testFile("a", nil)
+	a.uid, a.gid = 1000, 100
+	b := testFile("b", nil)
+	b.uid, b.gid = 0, 1000
+	rdr := openTestImage(t, testDir("", a, b), testImageOptions{})
+	ids, err := rdr.IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []uint32{0, 100, 1000} {
+		if !slices.Contains(ids, want) {
+			t.Fatal("id table", ids, "is missing", want)
+		}
+	}
+	fil, err := rdr.OpenFile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := fil.Uid()
+	if err != nil || uid != 1000 {
+		t.Fatal("wrong uid:", uid, err)
+	}
+	gid, err := fil.Gid()
+	if err != nil || gid != 100 {
+		t.Fatal("wrong gid:", gid, err)
+	}
+	// Sys resolves the ids the same way, including for entries from ReadDir.
+	ents, err := rdr.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]squashfs.Stat{"a": {Uid: 1000, Gid: 100}, "b": {Uid: 0, Gid: 1000}}
+	for _, e := range ents {
+		info, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys, ok := info.Sys().(*squashfs.Stat)
+		if !ok || sys.Uid != want[e.Name()].Uid || sys.Gid != want[e.Name()].Gid || sys.Inode == 0 {
+			t.Fatalf("%s: unexpected Sys: %+v", e.Name(), info.Sys())
+		}
+	}
+}
+
+func TestXattrTable(t *testing.T) {
+	long := strings.Repeat("v", 100)
+	var kids []*testNode
+	for i := range 600 {
+		// Enough xattr ids to span multiple metadata blocks.
+		kids = append(kids, withXattrs(testFile("file"+strconv.Itoa(i), nil), "user.n", strconv.Itoa(i), "user.long", long))
+	}
+	kids = append(kids,
+		withXattrs(testSymlink("link", "file0"), "security.selinux", "system_u:object_r:bin_t:s0", "trusted.t", "x"),
+		withXattrs(&testNode{name: "dev", mode: fs.ModeDevice, rdev: 1}, "user.dev", "1"),
+		withXattrs(&testNode{name: "fifo", mode: fs.ModeNamedPipe}, "user.fifo", "1"),
+		testFile("plain", []byte("plain")),
+	)
+	root := withXattrs(testDir("", withXattrs(testDir("dir", kids...), "user.dir", "d")), "user.root", "r")
+	for _, compress := range []bool{false, true} {
+		rdr := openTestImage(t, root, testImageOptions{compress: compress})
+		table, err := rdr.XattrTable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(table) != 605 {
+			t.Fatal("xattr table has", len(table), "entries")
+		}
+		check := func(name string, want ...squashfslow.Xattr) {
+			t.Helper()
+			b, err := rdr.Low.Root.Open(&rdr.Low, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := b.Xattrs(&rdr.Low)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if !slices.EqualFunc(got, want, func(a, b squashfslow.Xattr) bool { return a.Name == b.Name && bytes.Equal(a.Value, b.Value) }) {
+				t.Fatalf("%s: got %q, want %q", name, got, want)
+			}
+		}
+		check(".", squashfslow.Xattr{Name: "user.root", Value: []byte("r")})
+		check("dir", squashfslow.Xattr{Name: "user.dir", Value: []byte("d")})
+		check("dir/file0", squashfslow.Xattr{Name: "user.n", Value: []byte("0")}, squashfslow.Xattr{Name: "user.long", Value: []byte(long)})
+		check("dir/file599", squashfslow.Xattr{Name: "user.n", Value: []byte("599")}, squashfslow.Xattr{Name: "user.long", Value: []byte(long)})
+		check("dir/link", squashfslow.Xattr{Name: "security.selinux", Value: []byte("system_u:object_r:bin_t:s0")}, squashfslow.Xattr{Name: "trusted.t", Value: []byte("x")})
+		check("dir/dev", squashfslow.Xattr{Name: "user.dev", Value: []byte("1")})
+		check("dir/fifo", squashfslow.Xattr{Name: "user.fifo", Value: []byte("1")})
+		check("dir/plain")
+	}
+}
+
+func TestFileXattrs(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		withXattrs(testFile("a", nil), "user.comment", "hi", "security.capability", "\x01\x00\x00\x02"),
+		testFile("plain", nil),
+	), testImageOptions{})
+	f, err := rdr.OpenFile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err := f.Xattrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xattrs) != 2 || string(xattrs["user.comment"]) != "hi" || string(xattrs["security.capability"]) != "\x01\x00\x00\x02" {
+		t.Fatalf("got xattrs %q", xattrs)
+	}
+	if val, err := f.Xattr("user.comment"); err != nil || string(val) != "hi" {
+		t.Fatal("got", val, err)
+	}
+	if _, err = f.Xattr("user.missing"); err != squashfs.ErrNoXattr {
+		t.Fatal("expected ErrNoXattr, got", err)
+	}
+	plain, err := rdr.OpenFile("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xattrs, err = plain.Xattrs(); err != nil || len(xattrs) != 0 {
+		t.Fatal("plain file has xattrs", xattrs, err)
+	}
+	if _, err = plain.Xattr("user.comment"); err != squashfs.ErrNoXattr {
+		t.Fatal("expected ErrNoXattr, got", err)
+	}
+}
+
+func TestSELinuxLabel(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		withXattrs(testFile("bin", nil), "security.selinux", "system_u:object_r:bin_t:s0\x00"),
+		testFile("plain", nil),
+	), testImageOptions{})
+	f, err := rdr.OpenFile("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label, err := f.SELinuxLabel(); err != nil || label != "system_u:object_r:bin_t:s0" {
+		t.Fatalf("got label %q: %v", label, err)
+	}
+	f, err = rdr.OpenFile("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.SELinuxLabel(); err != squashfs.ErrNoXattr {
+		t.Fatal("expected ErrNoXattr, got", err)
+	}
+}
+
+func TestXattrNamespaces(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		withXattrs(testFile("file", nil), "user.a", "1", "trusted.b", "2", "security.c", "3"),
+	), testImageOptions{})
+	f, err := rdr.OpenFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xattrs, err := f.XattrsIn(squashfs.XattrUser | squashfs.XattrSecurity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xattrs) != 2 || string(xattrs["user.a"]) != "1" || string(xattrs["security.c"]) != "3" {
+		t.Fatal("got", xattrs)
+	}
+	if xattrs, err = f.XattrsIn(squashfs.XattrSystem); err != nil || len(xattrs) != 0 {
+		t.Fatal("got", xattrs, err)
+	}
+	if !squashfs.XattrAll.Match("trusted.b") || squashfs.XattrUser.Match("trusted.b") || squashfs.XattrAll.Match("other.d") {
+		t.Fatal("Match is wrong")
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	raw := binary.LittleEndian.AppendUint32(nil, 2)
+	for _, e := range []squashfs.ACLEntry{
+		{Tag: squashfs.ACLUserObj, Perm: 7, ID: 0xFFFFFFFF},
+		{Tag: squashfs.ACLUser, Perm: 4, ID: 1000},
+		{Tag: squashfs.ACLGroupObj, Perm: 5, ID: 0xFFFFFFFF},
+		{Tag: squashfs.ACLMask, Perm: 5, ID: 0xFFFFFFFF},
+		{Tag: squashfs.ACLOther, Perm: 4, ID: 0xFFFFFFFF},
+	} {
+		raw = binary.LittleEndian.AppendUint16(raw, uint16(e.Tag))
+		raw = binary.LittleEndian.AppendUint16(raw, e.Perm)
+		raw = binary.LittleEndian.AppendUint32(raw, e.ID)
+	}
+	acl, err := squashfs.ParseACL(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl.String() != "user::rwx,user:1000:r--,group::r-x,mask::r-x,other::r--" {
+		t.Fatal("got", acl.String())
+	}
+	for _, bad := range [][]byte{nil, raw[:len(raw)-1], append([]byte{1}, raw[1:]...)} {
+		if _, err = squashfs.ParseACL(bad); err == nil {
+			t.Fatal("parsed invalid ACL", bad)
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	capData := func(magic uint32, words ...uint32) string {
+		out := binary.LittleEndian.AppendUint32(nil, magic)
+		for _, w := range words {
+			out = binary.LittleEndian.AppendUint32(out, w)
+		}
+		return string(out)
+	}
+	rdr := openTestImage(t, testDir("",
+		withXattrs(testFile("ping", nil), "security.capability", capData(0x02000001, 1<<10|1<<13, 0, 0, 0)),
+		testFile("plain", nil),
+	), testImageOptions{})
+	f, err := rdr.OpenFile("ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps, err := f.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caps.Has(10) || caps.Has(0) || caps.String() != "cap_net_bind_service,cap_net_raw+ep" {
+		t.Fatalf("got %+v (%s)", caps, caps)
+	}
+	// Version 3 adds the root id, and the upper words hold capabilities 32 and above.
+	caps, err = squashfs.ParseCapabilities([]byte(capData(0x03000000, 1, 1, 1<<2, 0, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.RootID != 1000 || caps.String() != "cap_chown+ip cap_syslog+p" {
+		t.Fatalf("got %+v (%s)", caps, caps)
+	}
+	if _, err = squashfs.ParseCapabilities([]byte(capData(0x02000000, 0, 0))); err == nil {
+		t.Fatal("parsed truncated capabilities")
+	}
+	f, err = rdr.OpenFile("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Capabilities(); err != squashfs.ErrNoXattr {
+		t.Fatal("expected ErrNoXattr, got", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	root := testDir("",
+		testFile("big", bytes.Repeat([]byte("big file "), 2000)),
+		testDir("dir", testFile("small", []byte("small")), withXattrs(testFile("x", []byte("x")), "user.a", "1")),
+		testSymlink("link", "big"),
+	)
+	img := buildTestImage(t, root, testImageOptions{exportable: true})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := rdr.Check(); !report.OK() || report.Files != 6 {
+		t.Fatalf("got %+v", report)
+	}
+	// Point the small file's fragment past the end of the fragment block.
+	f, err := rdr.OpenFile("dir/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ref squashfslow.MetaRef
+	rdr.Inodes(func(r squashfslow.MetaRef, i inode.Inode) error {
+		if i.Num == f.InodeNum() {
+			ref = r
+		}
+		return nil
+	})
+	// The test images' metadata is uncompressed, so the inode is at a fixed offset. Skip the block's header, the inode's
+	// header, and the file's block start and fragment index.
+	loc := int(rdr.Superblock().InodeTableStart+ref.Block()) + 2 + int(ref.Offset()) + 16 + 4 + 4
+	bad := slices.Clone(img)
+	binary.LittleEndian.PutUint32(bad[loc:], 1<<20)
+	rdr, err = squashfs.NewReaderFromBytes(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := rdr.Check()
+	if report.OK() {
+		t.Fatal("expected problems")
+	}
+	var found bool
+	for _, e := range report.Errors {
+		if strings.Contains(e.Error(), "past the end of fragment block") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("got", report.Errors)
+	}
+}
+
+func TestCheckLocations(t *testing.T) {
+	// Enough files that the inode table takes more than one metadata block, so inode and directory locations differ.
+	var kids []*testNode
+	for i := range 400 {
+		kids = append(kids, testFile("file"+strconv.Itoa(i), nil))
+	}
+	kids = append(kids, testDir("zdir", testFile("small", []byte("small")), withXattrs(testFile("x", []byte("x")), "user.a", "1")))
+	img := buildTestImage(t, testDir("", kids...), testImageOptions{})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := rdr.Superblock()
+	dir, err := rdr.OpenFile("zdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirRef squashfslow.MetaRef
+	var listing uint32
+	rdr.Inodes(func(r squashfslow.MetaRef, i inode.Inode) error {
+		if i.Num == dir.InodeNum() {
+			dirRef, listing = r, i.Data.(inode.Directory).BlockStart
+		}
+		return nil
+	})
+	if dirRef.Block() == uint64(listing) {
+		t.Fatal("the directory's inode and listing must be in different blocks")
+	}
+	bad := slices.Clone(img)
+	// Change small's inode number. The test images' metadata is uncompressed, so the entry can be found by its name.
+	dirTable := bad[sb.DirTableStart:]
+	i := bytes.Index(dirTable, []byte{byte(inode.Fil), 0, 4, 0, 's', 'm', 'a', 'l', 'l'})
+	if i < 0 {
+		t.Fatal("entry not found")
+	}
+	binary.LittleEndian.PutUint16(dirTable[i-2:], binary.LittleEndian.Uint16(dirTable[i-2:])+1)
+	// Make x's xattr value too large. The value's size follows the block's header, the key's type and size, and the name "a".
+	kvStart := binary.LittleEndian.Uint64(bad[sb.XattrTableStart:])
+	binary.LittleEndian.PutUint32(bad[kvStart+2+4+1:], 1<<20)
+	rdr, err = squashfs.NewReaderFromBytes(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirErr, xattrErr bool
+	for _, e := range rdr.Check().Errors {
+		switch e.Where {
+		case `directory entry "small"`:
+			dirErr = e.Offset == int64(sb.DirTableStart)+int64(listing)
+		case "xattr 0":
+			xattrErr = errors.Is(e, squashfs.ErrCorrupt)
+		}
+	}
+	if !dirErr || !xattrErr {
+		t.Fatal("got", rdr.Check().Errors)
+	}
+}
+
+func TestMetadataReader(t *testing.T) {
+	rdr := openTestImage(t, testDir("", testFile("file", nil)), testImageOptions{compress: true})
+	sb := rdr.Superblock()
+	ref := squashfslow.NewMetaRef(sb.InodeTableStart+sb.RootInodeRef.Block(), sb.RootInodeRef.Offset())
+	meta, err := rdr.MetadataReader(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := inode.Read(meta, sb.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Type != inode.Dir || i.Num != rdr.File().InodeNum() {
+		t.Fatalf("read the wrong inode: %+v", i.Header)
+	}
+}
+
+func TestMetaRef(t *testing.T) {
+	ref := squashfslow.NewMetaRef(8194, 300)
+	if ref.Block() != 8194 || ref.Offset() != 300 || uint64(ref) != 8194<<16|300 {
+		t.Fatal("bad ref", uint64(ref))
+	}
+	if ref.String() != "8194:300" {
+		t.Fatal("got", ref.String())
+	}
+	parsed, err := squashfslow.ParseMetaRef(ref.String())
+	if err != nil || parsed != ref {
+		t.Fatal("failed to parse", ref, parsed, err)
+	}
+	for _, bad := range []string{"", "1", "1:", "a:1", "1:65536", "-1:0"} {
+		if _, err = squashfslow.ParseMetaRef(bad); err == nil {
+			t.Fatal("parsed invalid ref", bad)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	root := testDir("", withXattrs(testFile("a", bytes.Repeat([]byte("a"), 10000)), "user.a", "a"), testFile("b", []byte("b")))
+	for _, compress := range []bool{false, true} {
+		rdr := openTestImage(t, root, testImageOptions{compress: compress, exportable: true})
+		regions, err := rdr.Layout()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		var end int64
+		for _, r := range regions {
+			names = append(names, r.Name)
+			if r.Start != end || r.Size <= 0 {
+				t.Fatalf("region %+v doesn't start at the end of the previous region (%d)", r, end)
+			}
+			if r.Name != "superblock" && r.Compressed != compress {
+				t.Fatalf("region %+v has the wrong compression", r)
+			}
+			end = r.Start + r.Size
+		}
+		if end != rdr.ArchiveSize() {
+			t.Fatal("regions end at", end, "want", rdr.ArchiveSize())
+		}
+		want := []string{"superblock", "data", "inode table", "directory table", "fragment table", "export table", "id table", "xattr table"}
+		if !slices.Equal(names, want) {
+			t.Fatal("got regions", names)
+		}
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	root := testDir("", testFile("a", []byte("a")))
+	rdr := openTestImage(t, root, testImageOptions{})
+	if raw, err := rdr.CompressionOptionsRaw(); err != nil || raw != nil {
+		t.Fatal("unexpected options:", raw, err)
+	}
+	opts := []byte{9, 0, 0, 0, 15, 0, 1, 0}
+	rdr = openTestImage(t, root, testImageOptions{compOpts: opts})
+	raw, err := rdr.CompressionOptionsRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, opts) {
+		t.Fatal("got raw options", raw)
+	}
+	parsed, err := rdr.CompressionOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := squashfslow.ZlibOptions{CompressionLevel: 9, WindowSize: 15, Strategies: 1}
+	if parsed != want {
+		t.Fatalf("got options %+v", parsed)
+	}
+	if dat, err := rdr.ReadFile("a"); err != nil || string(dat) != "a" {
+		t.Fatal("failed to read file after options:", dat, err)
+	}
+}
+
+func TestUnknownInodeType(t *testing.T) {
+	img := buildTestImage(t, testDir("", testFile("a", nil), testFile("b", nil), testFile("c", nil)), testImageOptions{})
+	rdr, err := squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt b's inode type. The inode table is a single uncompressed metadata block.
+	e := rdr.Low.Root.Entries[1]
+	binary.LittleEndian.PutUint16(img[rdr.Superblock().InodeTableStart+uint64(e.BlockStart)+2+uint64(e.Offset):], 99)
+	rdr, err = squashfs.NewReaderFromBytes(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = rdr.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			var typeErr inode.UnknownTypeError
+			if !errors.Is(err, squashfs.ErrUnknownInodeType) || !errors.As(err, &typeErr) || typeErr.Header.Type != 99 {
+				t.Fatal("unexpected error:", err)
+			}
+			names = append(names, path+" (unknown)")
+			return nil
+		}
+		names = append(names, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{".", "a", "b (unknown)", "c"}) {
+		t.Fatal("got", names)
+	}
+}
+
+func TestMmapReader(t *testing.T) {
+	if !mmap.Supported {
+		t.Skip("mmap not supported on this platform")
+	}
+	big := bytes.Repeat([]byte("mapped "), 20000)
+	img := buildTestImage(t, testDir("", testFile("file", []byte("mapped")), testFile("big", big)), testImageOptions{blockSize: 4096})
+	path := filepath.Join(t.TempDir(), "test.sfs")
+	if err := os.WriteFile(path, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	open := func() *squashfs.Reader {
+		fil, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fil.Close()
+		rdr, err := squashfs.NewMmapReader(fil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rdr
+	}
+	rdr := open()
+	dat, err := rdr.ReadFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "mapped" {
+		t.Fatal("wrong contents:", string(dat))
+	}
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing while files are being read must wait for the reads instead of unmapping memory that's in use.
+	rdr = open()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				dat, err := rdr.ReadFile("big")
+				if err != nil {
+					if !errors.Is(err, squashfs.ErrReaderClosed) {
+						t.Error(err)
+					}
+					return
+				}
+				if !bytes.Equal(dat, big) {
+					t.Error("wrong contents")
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err = rdr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestHTTPHandler(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testFile("data.bin", []byte("0123456789")),
+		testDir("site", testFile("index.html", []byte("<h1>hi</h1>"))),
+		testSymlink("latest", "data.bin"),
+	), testImageOptions{})
+	srv := httptest.NewServer(rdr.HTTPHandler())
+	defer srv.Close()
+	get := func(path string, hdr ...string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	resp, body := get("/latest", "Range", "bytes=2-4")
+	if resp.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatal("got", resp.Status, body)
+	}
+	tag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(tag, `W/"`) {
+		t.Fatal("expected a weak ETag, got", tag)
+	}
+	if resp, _ = get("/data.bin", "If-None-Match", tag); resp.StatusCode != http.StatusNotModified {
+		t.Fatal("got", resp.Status)
+	}
+	if resp, body = get("/site/"); resp.StatusCode != http.StatusOK || body != "<h1>hi</h1>" {
+		t.Fatal("got", resp.Status, body)
+	}
+	if resp, body = get("/"); !strings.Contains(body, "data.bin") {
+		t.Fatal("got", resp.Status, body)
+	}
+	if resp, _ = get("/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Fatal("got", resp.Status)
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	rdr := openTestImage(t, testDir("",
+		testFile("hello.txt", []byte("hello world")),
+		testDir("dir", testFile("a b", []byte("a")), testDir("sub", testFile("deep", nil))),
+		testSymlink("link", "hello.txt"),
+	), testImageOptions{})
+	h := rdr.WebDAVHandler("/dav")
+	do := func(method, target string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do("GET", "/dav/link", "Range", "bytes=6-")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Fatal("got", rec.Code, rec.Body.String())
+	}
+	rec = do("PROPFIND", "/dav/dir", "Depth", "1")
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatal("got", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"<D:href>/dav/dir/</D:href>", "<D:href>/dav/dir/a%20b</D:href>", "<D:href>/dav/dir/sub/</D:href>", "<D:collection/>", "<D:getcontentlength>1</D:getcontentlength>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %s in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "deep") {
+		t.Fatal("depth 1 included a nested file:", body)
+	}
+	for _, depth := range []string{"infinity", ""} {
+		if rec = do("PROPFIND", "/dav/", "Depth", depth); rec.Code != http.StatusForbidden ||
+			!strings.Contains(rec.Body.String(), "<D:propfind-finite-depth/>") {
+			t.Fatalf("depth %q got %d %s", depth, rec.Code, rec.Body.String())
+		}
+	}
+	if rec = do("GET", "/dav/dir?x=1"); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/dav/dir/?x=1" {
+		t.Fatal("got", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec = do("GET", "/dav/dir/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `href="./sub/"`) {
+		t.Fatal("got", rec.Code, rec.Body.String())
+	}
+	if rec = do("PROPFIND", "/dav/missing", "Depth", "0"); rec.Code != http.StatusNotFound {
+		t.Fatal("got", rec.Code)
+	}
+	if rec = do("PUT", "/dav/new"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal("got", rec.Code)
+	}
+	if rec = do("OPTIONS", "/dav/"); rec.Header().Get("DAV") != "1" {
+		t.Fatal("missing DAV header")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	big := testFile("big", bytes.Repeat([]byte("manifest "), 2000))
+	rdr := openTestImage(t, testDir("",
+		big,
+		testDir("dir", testFile("small", []byte("small")), testFile("empty", nil)),
+		testLink("hard", big),
+		testSymlink("link", "big"),
+	), testImageOptions{blockSize: 4096})
+	got, err := rdr.Manifest(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigSum := sha256.Sum256(bytes.Repeat([]byte("manifest "), 2000))
+	smallSum := sha256.Sum256([]byte("small"))
+	emptySum := sha256.Sum256(nil)
+	want := map[string][]byte{
+		"big":       bigSum[:],
+		"hard":      bigSum[:],
+		"dir/small": smallSum[:],
+		"dir/empty": emptySum[:],
+	}
+	if !maps.EqualFunc(got, want, bytes.Equal) {
+		t.Fatalf("got %x", got)
+	}
+}
+
+func TestCompareDir(t *testing.T) {
+	big := testFile("big", bytes.Repeat([]byte("compare "), 2000))
+	root := testDir("",
+		big,
+		testDir("dir", testFile("small", []byte("small")), testFile("gone", []byte("gone"))),
+		testSymlink("link", "big"),
+	)
+	// Extracting as a regular user can't change the owner.
+	var setOwner func(n *testNode)
+	setOwner = func(n *testNode) {
+		n.uid, n.gid = uint32(os.Getuid()), uint32(os.Getgid())
+		for _, c := range n.children {
+			setOwner(c)
+		}
+	}
+	setOwner(root)
+	rdr := openTestImage(t, root, testImageOptions{blockSize: 4096})
+	dir := t.TempDir()
+	op := squashfs.DefaultOptions()
+	op.PreserveModTime = true
+	if err := rdr.ExtractWithOptions(dir, op); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := rdr.CompareDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatal("extracted archive is different:", diffs)
+	}
+	// Same size, different data, so only the data differs.
+	if err = os.WriteFile(filepath.Join(dir, "dir/small"), []byte("SMALL"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(filepath.Join(dir, "big"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "dir/gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "extra"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("elsewhere", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// Changing a directory's contents changes its mtime, so put the mtimes back.
+	for _, name := range []string{"dir/small", "dir", "."} {
+		f, err := rdr.OpenFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(filepath.Join(dir, name), time.Time{}, f.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diffs, err = rdr.CompareDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []squashfs.Difference{
+		{Path: "big", Mismatch: squashfs.MismatchMode},
+		{Path: "dir/gone", Mismatch: squashfs.MismatchMissing},
+		{Path: "dir/small", Mismatch: squashfs.MismatchContent},
+		{Path: "extra", Mismatch: squashfs.MismatchExtra},
+		{Path: "link", Mismatch: squashfs.MismatchContent},
+	}
+	if !slices.Equal(diffs, want) {
+		t.Fatalf("got %v, want %v", diffs, want)
+	}
+	if s := (squashfs.MismatchMode | squashfs.MismatchModTime).String(); s != "mode,mtime" {
+		t.Fatal("got", s)
+	}
+	if _, err = rdr.CompareDir(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected a missing directory to fail, got", err)
+	}
+	if _, err = rdr.CompareDir(filepath.Join(dir, "big")); !errors.Is(err, squashfs.ErrNotDir) {
+		t.Fatal("expected ErrNotDir for a regular file, got", err)
+	}
+}
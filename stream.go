@@ -0,0 +1,108 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+)
+
+// Returned when reading file data from a Reader created by NewStreamReader without keeping data.
+var ErrDataDiscarded = errors.New("file data was discarded while reading the archive from a stream")
+
+// Reads an archive from a non-seekable stream, such as a pipe or network connection.
+// squashfs stores its metadata after the file data, so the stream is always read to the end of the archive.
+//
+// If keepData is false, file data is discarded as it's read and only the metadata is kept in memory.
+// This is enough to list the archive's contents (names, modes, sizes, symlinks, etc.), but reading a file's data returns ErrDataDiscarded.
+// If keepData is true, the archive is spooled to a temporary file, which is removed on Close, so files can be read and extracted.
+func NewStreamReader(r io.Reader, keepData bool) (*Reader, error) {
+	var sb [96]byte
+	_, err := io.ReadFull(r, sb[:])
+	if err != nil {
+		return nil, errors.Join(errors.New("failed to read superblock"), err)
+	}
+	// Locations in the superblock. Everything needed for listing is stored after the inode table's start.
+	size := int64(binary.LittleEndian.Uint64(sb[40:]))
+	inodeStart := int64(binary.LittleEndian.Uint64(sb[64:]))
+	if keepData {
+		return spoolStream(r, sb[:], size)
+	}
+	if inodeStart < int64(len(sb)) || inodeStart > size {
+		// Let NewReader report a proper error if the superblock is invalid.
+		return NewReader(&metadataReaderAt{sb: sb[:]})
+	}
+	_, err = io.CopyN(io.Discard, r, inodeStart-int64(len(sb)))
+	if err != nil {
+		return nil, err
+	}
+	// size isn't trusted for the allocation, so the metadata is read as it arrives instead of into a buffer of that size.
+	meta, err := io.ReadAll(io.LimitReader(r, size-inodeStart))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(meta)) != size-inodeStart {
+		return nil, corrupt.Error("stream ended before the archive's bytes used")
+	}
+	return NewReader(&metadataReaderAt{sb: sb[:], metaStart: inodeStart, meta: meta})
+}
+
+// An io.ReaderAt of an archive's superblock and metadata, without any file data.
+type metadataReaderAt struct {
+	sb        []byte
+	meta      []byte
+	metaStart int64
+}
+
+func (m *metadataReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= 0 && off+int64(len(p)) <= int64(len(m.sb)) {
+		return copy(p, m.sb[off:]), nil
+	}
+	if off >= m.metaStart && m.meta != nil {
+		if off >= m.metaStart+int64(len(m.meta)) {
+			return 0, io.EOF
+		}
+		n := copy(p, m.meta[off-m.metaStart:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	return 0, ErrDataDiscarded
+}
+
+// Copies the archive to a temporary file and opens it.
+func spoolStream(r io.Reader, sb []byte, size int64) (*Reader, error) {
+	f, err := os.CreateTemp("", "squashfs-stream-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := &tempFile{f}
+	_, err = f.Write(sb)
+	if err == nil && size > int64(len(sb)) {
+		_, err = io.CopyN(f, r, size-int64(len(sb)))
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	out, err := NewReader(f)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	out.closer = tmp
+	return out, nil
+}
+
+// A temporary file that's removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	return errors.Join(err, os.Remove(t.Name()))
+}
@@ -0,0 +1,302 @@
+package squashfs
+
+import (
+	"io/fs"
+	"path"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/CalebQ42/squashfs/internal/corrupt"
+)
+
+// The maximum number of symlinks followed when resolving a single path. Matches Linux's limit.
+const maxSymlinkHops = 40
+
+type WalkOptions struct {
+	FollowSymlinks bool //Resolve symlinks and report their target (under the symlink's name) instead. Symlinked directories are walked into unless it would cause a cycle.
+}
+
+// Walk the FS, calling fn for each file or directory, including the root (".").
+// Behaves like fs.WalkDir. Symlinks are reported, but not followed.
+func (f *FS) Walk(fn fs.WalkDirFunc) error {
+	return f.WalkWithOptions(fn, &WalkOptions{})
+}
+
+// Walk the FS, calling fn for each file or directory, including the root (".").
+// Behaves like fs.WalkDir, except symlinks can be followed via WalkOptions.
+func (f *FS) WalkWithOptions(fn fs.WalkDirFunc, op *WalkOptions) error {
+	return f.walkFiles(func(name string, _ *File, d fs.DirEntry, err error) error {
+		return fn(name, d, err)
+	}, op)
+}
+
+// Like fs.WalkDirFunc, but also given the File, so it doesn't need to be opened again. fil is nil if the entry couldn't be read.
+type walkFileFunc func(name string, fil *File, d fs.DirEntry, err error) error
+
+// Walks the FS like WalkWithOptions, giving fn each File.
+func (f *FS) walkFiles(fn walkFileFunc, op *WalkOptions) error {
+	root := f.File()
+	info, err := root.Stat()
+	if err != nil {
+		err = fn(".", nil, nil, err)
+	} else {
+		err = f.walk(".", root, fs.FileInfoToDirEntry(info), fn, op, nil)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// Returns the paths of files with more than one hard link, grouped by inode number. Each group holds every path that
+// links to the inode, in walk order, so converters can write the first path and link the rest to it. Directories are never included.
+func (f *FS) HardLinks() (map[uint32][]string, error) {
+	out := make(map[uint32][]string)
+	err := f.Walk(func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fi, ok := info.(fileInfo)
+		if ok && fi.nlink > 1 {
+			out[fi.inodeNum] = append(out[fi.inodeNum], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for num, paths := range out {
+		// Links to the inode might be outside of f.
+		if len(paths) < 2 {
+			delete(out, num)
+		}
+	}
+	return out, nil
+}
+
+// Given to fn when a directory contains one of its ancestors, which only happens in corrupted archives.
+var errDirCycle = corrupt.Error("directory contains itself")
+
+// ancestors contains the inode numbers of all directories above fil. It keeps followed symlinks from walking into a directory
+// they're in, and reports a directory that contains one of its ancestors with errDirCycle instead of walking it forever.
+func (f *FS) walk(name string, fil *File, d fs.DirEntry, fn walkFileFunc, op *WalkOptions, ancestors []uint32) error {
+	err := fn(name, fil, d, nil)
+	if err != nil || !fil.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	dir, err := fil.FS()
+	if err != nil {
+		err = fn(name, fil, d, err)
+		if err == fs.SkipDir {
+			err = nil
+		}
+		return err
+	}
+	ancestors = append(ancestors, fil.InodeNum())
+	for _, e := range dir.d.Entries {
+		childName := path.Join(name, e.Name)
+		b, err := f.r.Low.BaseFromEntry(e)
+		if err != nil {
+			err = fn(childName, nil, nil, err)
+			if err == fs.SkipDir {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		child := f.r.FileFromBase(b, dir)
+		if op.FollowSymlinks && child.IsSymlink() {
+			target := child.resolveSymlink()
+			if target != nil && !(target.IsDir() && slices.Contains(ancestors, target.InodeNum())) {
+				child = target
+			}
+		}
+		d := fs.FileInfoToDirEntry(newFileInfo(&f.r.Low, e.Name, &child.b.Inode))
+		if child.IsDir() && slices.Contains(ancestors, child.InodeNum()) {
+			err = fn(childName, child, d, errDirCycle)
+		} else {
+			err = f.walk(childName, child, d, fn, op, ancestors)
+		}
+		if err == fs.SkipDir {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk the FS like Walk, but with directories read and walked by multiple goroutines.
+// If workers <= 0, runtime.NumCPU() is used.
+//
+// fn is called concurrently, in no particular order, except a directory is always reported before its contents.
+// Returning fs.SkipDir skips the directory (or, for a file, the rest of its directory), and fs.SkipAll or an error
+// stops the walk as soon as possible. Symlinks are reported, but not followed.
+func (f *FS) WalkParallel(workers int, fn fs.WalkDirFunc) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	root := f.File()
+	info, err := root.Stat()
+	if err != nil {
+		err = fn(".", nil, err)
+	} else {
+		d := fs.FileInfoToDirEntry(info)
+		err = fn(".", d, nil)
+		if err == nil {
+			w := &parallelWalk{
+				fn:      fn,
+				queue:   []walkJob{{name: ".", fil: root, d: d}},
+				pending: 1,
+			}
+			w.cond = sync.NewCond(&w.mut)
+			var wg sync.WaitGroup
+			for range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w.work()
+				}()
+			}
+			wg.Wait()
+			err = w.err
+		}
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+type walkJob struct {
+	fil       *File
+	d         fs.DirEntry
+	name      string
+	ancestors []uint32 // Inode numbers of the directories above fil, to detect cycles in corrupted archives.
+}
+
+type parallelWalk struct {
+	err     error // The first error returned by fn. Stops the walk.
+	fn      fs.WalkDirFunc
+	cond    *sync.Cond
+	queue   []walkJob // Directories that have been reported but not read.
+	mut     sync.Mutex
+	pending int // Directories that are queued or being walked.
+}
+
+func (w *parallelWalk) work() {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	for {
+		for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+		if w.pending == 0 || w.err != nil {
+			return
+		}
+		job := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mut.Unlock()
+		err := w.walkDir(job)
+		w.mut.Lock()
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		w.pending--
+		w.cond.Broadcast()
+	}
+}
+
+func (w *parallelWalk) stopped() bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.err != nil
+}
+
+// Reports the directory's contents and queues its subdirectories.
+func (w *parallelWalk) walkDir(job walkJob) error {
+	dir, err := job.fil.FS()
+	if err != nil {
+		err = w.fn(job.name, job.d, err)
+		if err == fs.SkipDir {
+			err = nil
+		}
+		return err
+	}
+	ancestors := append(slices.Clip(job.ancestors), job.fil.InodeNum())
+	for _, e := range dir.d.Entries {
+		if w.stopped() {
+			return nil
+		}
+		childName := path.Join(job.name, e.Name)
+		b, err := dir.r.Low.BaseFromEntry(e)
+		if err != nil {
+			err = w.fn(childName, nil, err)
+			if err == fs.SkipDir {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		child := dir.r.FileFromBase(b, dir)
+		d := fs.FileInfoToDirEntry(newFileInfo(&dir.r.Low, e.Name, &child.b.Inode))
+		cycle := child.IsDir() && slices.Contains(ancestors, child.InodeNum())
+		if cycle {
+			err = w.fn(childName, d, errDirCycle)
+		} else {
+			err = w.fn(childName, d, nil)
+		}
+		if err == fs.SkipDir {
+			if child.IsDir() {
+				continue
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if child.IsDir() && !cycle {
+			w.mut.Lock()
+			w.queue = append(w.queue, walkJob{name: childName, fil: child, d: d, ancestors: ancestors})
+			w.pending++
+			w.cond.Signal()
+			w.mut.Unlock()
+		}
+	}
+	return nil
+}
+
+// Follows the symlink (and any symlinks it points to) to the final file. Symlinks followed along the way are closed, but f isn't.
+// Returns nil if the symlink can't be resolved inside the archive.
+func (f *File) resolveSymlink() *File {
+	cur := f
+	for i := 0; i < maxSymlinkHops && cur.IsSymlink(); i++ {
+		next := cur.GetSymlinkFile()
+		if cur != f {
+			cur.Close()
+		}
+		if next == nil {
+			return nil
+		}
+		cur = next.(*File)
+	}
+	if cur.IsSymlink() {
+		if cur != f {
+			cur.Close()
+		}
+		return nil
+	}
+	return cur
+}
@@ -0,0 +1,176 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Returns a read-only WebDAV (class 1) handler serving the FS, so it can be browsed from file managers or mounted over the
+// network without FUSE. Requests' paths must start with prefix, which is removed, like http.StripPrefix, and is added back
+// to the paths in PROPFIND responses. PROPFIND only supports a Depth of 0 or 1. Symlinks are followed, and any method that would
+// modify the FS gets 405 Method Not Allowed.
+func (f *FS) WebDAVHandler(prefix string) http.Handler {
+	return &webDAV{fs: f, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+type webDAV struct {
+	fs     *FS
+	prefix string
+}
+
+const webDAVMethods = "OPTIONS, GET, HEAD, PROPFIND"
+
+func (d *webDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, d.prefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name = httpPath(name)
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", webDAVMethods)
+		w.Header().Set("MS-Author-Via", "DAV")
+	case http.MethodGet, http.MethodHead:
+		d.get(w, r, name)
+	case "PROPFIND":
+		d.propfind(w, r, name)
+	default:
+		w.Header().Set("Allow", webDAVMethods)
+		http.Error(w, "the archive is read-only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *webDAV) get(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := d.fs.OpenResolved(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+	if f.IsDir() {
+		// The listing's links are relative to the directory, so its URL needs to end with a slash.
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		d.dirListing(w, r, f)
+		return
+	}
+	serveFile(w, r, f)
+}
+
+// Writes a simple HTML list of the directory's entries, for browsers.
+func (d *webDAV) dirListing(w http.ResponseWriter, r *http.Request, f *File) {
+	ents, err := f.ReadDir(-1)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintln(w, "<!doctype html>\n<pre>")
+	for _, e := range ents {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", (&url.URL{Path: "./" + n}).String(), html.EscapeString(n))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+func (d *webDAV) propfind(w http.ResponseWriter, r *http.Request, name string) {
+	// A missing Depth is infinity, which RFC 4918 allows servers to reject, since it can list the whole archive.
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(xml.Header + `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>` + "\n"))
+		return
+	}
+	f, err := d.fs.OpenResolved(name)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">` + "\n")
+	err = d.propResponse(&buf, name, f)
+	if err == nil && f.IsDir() && depth == "1" {
+		var ents []fs.DirEntry
+		ents, err = f.ReadDir(-1)
+		for _, e := range ents {
+			p := path.Join(name, e.Name())
+			fil, resolveErr := d.fs.OpenResolved(p)
+			if resolveErr != nil {
+				// Skip broken symlinks.
+				continue
+			}
+			err = d.propResponse(&buf, p, fil)
+			fil.Close()
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	buf.WriteString("</D:multistatus>\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(buf.Bytes())
+}
+
+// Writes the D:response element for f, which is at name. All properties are always included.
+func (d *webDAV) propResponse(w *bytes.Buffer, name string, f *File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	href := d.prefix + "/"
+	if name != "." {
+		href += name
+		if f.IsDir() {
+			href += "/"
+		}
+	}
+	w.WriteString("<D:response><D:href>")
+	xml.EscapeText(w, []byte((&url.URL{Path: href}).EscapedPath()))
+	w.WriteString("</D:href><D:propstat><D:prop><D:displayname>")
+	xml.EscapeText(w, []byte(path.Base(href)))
+	w.WriteString("</D:displayname>")
+	if f.IsDir() {
+		w.WriteString("<D:resourcetype><D:collection/></D:resourcetype>")
+	} else {
+		w.WriteString("<D:resourcetype/><D:getcontentlength>" + strconv.FormatInt(info.Size(), 10) + "</D:getcontentlength>")
+		if typ := mime.TypeByExtension(path.Ext(name)); typ != "" {
+			w.WriteString("<D:getcontenttype>")
+			xml.EscapeText(w, []byte(typ))
+			w.WriteString("</D:getcontenttype>")
+		}
+		w.WriteString("<D:getetag>")
+		xml.EscapeText(w, []byte(etag(f)))
+		w.WriteString("</D:getetag>")
+	}
+	w.WriteString("<D:getlastmodified>" + info.ModTime().Format(http.TimeFormat) + "</D:getlastmodified>")
+	w.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>\n")
+	return nil
+}
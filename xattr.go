@@ -0,0 +1,52 @@
+package squashfs
+
+import "strings"
+
+// A set of xattr namespaces, used to choose which xattrs are read or applied when extracting.
+type XattrNamespace uint8
+
+const (
+	XattrUser     XattrNamespace = 1 << iota // user.*
+	XattrTrusted                             // trusted.*, which can only be set by root.
+	XattrSecurity                            // security.*, such as SELinux labels and capabilities.
+	XattrSystem                              // system.*, such as POSIX ACLs.
+
+	XattrAll = XattrUser | XattrTrusted | XattrSecurity | XattrSystem
+)
+
+var xattrNamespaces = []string{"user.", "trusted.", "security.", "system."}
+
+// Returns whether the xattr with the given full name is in one of the namespaces.
+func (n XattrNamespace) Match(name string) bool {
+	for i, prefix := range xattrNamespaces {
+		if n&(1<<i) != 0 && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the file's xattrs in the given namespaces, keyed by their full name.
+func (f *File) XattrsIn(ns XattrNamespace) (map[string][]byte, error) {
+	out, err := f.Xattrs()
+	if err != nil {
+		return nil, err
+	}
+	for name := range out {
+		if !ns.Match(name) {
+			delete(out, name)
+		}
+	}
+	return out, nil
+}
+
+// Returns the file's SELinux security context (the security.selinux xattr), such as "system_u:object_r:bin_t:s0".
+// Returns ErrNoXattr if the file isn't labeled.
+func (f *File) SELinuxLabel() (string, error) {
+	val, err := f.Xattr("security.selinux")
+	if err != nil {
+		return "", err
+	}
+	// The kernel stores the context NUL terminated.
+	return strings.TrimRight(string(val), "\x00"), nil
+}
@@ -0,0 +1,7 @@
+package squashfs
+
+import "syscall"
+
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}